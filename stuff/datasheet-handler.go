@@ -0,0 +1,134 @@
+// Upload and download of datasheet PDFs. Datasheet URLs rot, so a copy is
+// better kept here.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kDatasheet = "/datasheet/"
+
+	// Larger uploads are rejected.
+	kMaxDatasheetSize = 32 << 20
+)
+
+type DatasheetHandler struct {
+	store    StuffStore
+	dir      string // Where the PDFs are stored, next to the images.
+	editNets []*net.IPNet
+}
+
+func AddDatasheetHandler(store StuffStore, dir string, editNets []*net.IPNet) *DatasheetHandler {
+	handler := &DatasheetHandler{
+		store:    store,
+		dir:      dir,
+		editNets: editNets,
+	}
+	http.Handle(kDatasheet, handler)
+	return handler
+}
+
+func (h *DatasheetHandler) ServeHTTP(out http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, kDatasheet))
+	component := h.store.FindById(id)
+	if err != nil || component == nil {
+		http.Error(out, "No such component", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		path := findDatasheet(h.store, h.dir, component)
+		if path == "" {
+			http.Error(out, "No datasheet", http.StatusNotFound)
+			return
+		}
+		out.Header().Set("Cache-Control", "max-age=60")
+		http.ServeFile(out, r, path)
+		return
+	}
+	if !editAllowed(r, h.editNets) {
+		http.Error(out, "Not allowed to edit", http.StatusForbidden)
+		return
+	}
+	r.Body = http.MaxBytesReader(out, r.Body, kMaxDatasheetSize+(1<<20))
+	if r.FormValue("delete") != "" {
+		err = os.Remove(datasheetPath(h.dir, id))
+		if err == nil || os.IsNotExist(err) {
+			log.Printf("Datasheet %d removed", id)
+			h.store.SetDatasheetText(id, "")
+			err = nil
+		}
+	} else {
+		err = h.upload(id, r)
+	}
+	if err != nil {
+		http.Error(out, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(out, r, fmt.Sprintf("/form?id=%d", id), http.StatusSeeOther)
+}
+
+// Store the PDF uploaded as "pdf" for the component and index its text.
+func (h *DatasheetHandler) upload(id int, r *http.Request) error {
+	file, _, err := r.FormFile("pdf")
+	if err != nil {
+		return fmt.Errorf("Need a PDF file: %v", err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, kMaxDatasheetSize+1))
+	if err != nil {
+		return err
+	}
+	if len(content) > kMaxDatasheetSize {
+		return fmt.Errorf("Datasheet larger than %d MB", kMaxDatasheetSize>>20)
+	}
+	text, err := extractPdfText(content)
+	if err != nil {
+		return err
+	}
+	tmpfile, err := os.CreateTemp(h.dir, ".datasheet")
+	if err != nil {
+		return err
+	}
+	if _, err = tmpfile.Write(content); err == nil {
+		err = tmpfile.Close()
+	} else {
+		tmpfile.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpfile.Name(), datasheetPath(h.dir, id))
+	}
+	if err != nil {
+		_ = os.Remove(tmpfile.Name())
+		return err
+	}
+	log.Printf("Datasheet %d uploaded, %d bytes, %d bytes of text", id, len(content), len(text))
+	h.store.SetDatasheetText(id, text)
+	return nil
+}
+
+// Extract the text of datasheets that are not indexed yet, e.g. copied
+// into the directory by hand.
+func (h *DatasheetHandler) IndexMissing() {
+	defer ElapsedPrint("Index datasheets", time.Now())
+	indexed := h.store.DatasheetTextIds()
+	files, _ := filepath.Glob(filepath.Join(h.dir, "*.pdf"))
+	for _, file := range files {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), ".pdf"))
+		if err != nil || indexed[id] || h.store.FindById(id) == nil {
+			continue
+		}
+		if err := indexDatasheet(h.store, file, id); err != nil {
+			log.Printf("Datasheet %s: %v", file, err)
+		}
+	}
+}
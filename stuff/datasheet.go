@@ -0,0 +1,129 @@
+// Datasheets uploaded as PDF, stored next to the images as <id>.pdf. A
+// datasheet is also used for all components of the equivalence set that
+// don't have one of their own. The text of the datasheets is searched, but
+// with a low weight, so that the components themselves rank first.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Weight of a match in the datasheet, compared to e.g. 1.0 in the
+// footprint.
+const kDatasheetWeight = 0.5
+
+func datasheetPath(dir string, id int) string {
+	return fmt.Sprintf("%s/%d.pdf", dir, id)
+}
+
+// Extract the text of the datasheet file and store it for the component.
+func indexDatasheet(store StuffStore, path string, id int) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text, err := extractPdfText(content)
+	if err != nil {
+		return err
+	}
+	store.SetDatasheetText(id, text)
+	return nil
+}
+
+// The datasheet file of the component: its own, or the one of the first
+// member of its set that has one. Empty if there is none.
+func findDatasheet(store StuffStore, dir string, c *Component) string {
+	if c == nil {
+		return ""
+	}
+	if path := datasheetPath(dir, c.Id); fileExists(path) {
+		return path
+	}
+	for _, member := range store.EquivSetMembers(c.Equiv_set) {
+		if path := datasheetPath(dir, member.Id); fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func datasheetWords(text string) map[string]bool {
+	result := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		result[word] = true
+	}
+	return result
+}
+
+// Set the text of the datasheet of the component with the given ID. Empty
+// text removes it.
+func (s *FulltextSearch) SetDatasheet(id int, text string) {
+	s.SetDatasheets(map[int]string{id: text})
+}
+
+// Set the text of several datasheets, by component ID.
+func (s *FulltextSearch) SetDatasheets(texts map[int]string) {
+	s.lock.Lock()
+	for id, text := range texts {
+		if before, found := s.datasheets[id]; found {
+			s.datasheetVocabulary.remove(datasheetWords(before))
+			delete(s.datasheets, id)
+		}
+		if text = preprocessTerm(text); text != "" {
+			s.datasheets[id] = text
+			s.datasheetVocabulary.add(datasheetWords(text))
+		}
+	}
+	s.resolveDatasheets()
+	s.lock.Unlock()
+	s.cache.invalidate()
+}
+
+// The datasheet text that applies to the component. Needs to be called
+// with lock held.
+func (s *FulltextSearch) datasheetText(c *Component) string {
+	if text, found := s.datasheets[c.Id]; found {
+		return text
+	}
+	owner := -1
+	for id := range s.datasheets {
+		if other, found := s.id2Component[id]; found &&
+			other.orig.Equiv_set == c.Equiv_set && (owner < 0 || id < owner) {
+			owner = id
+		}
+	}
+	return s.datasheets[owner]
+}
+
+// Assign the datasheet texts to all components, after datasheets or sets
+// changed. Needs to be called with lock held.
+func (s *FulltextSearch) resolveDatasheets() {
+	set_owner := make(map[int]int)
+	for id := range s.datasheets {
+		if c, found := s.id2Component[id]; found {
+			if owner, found := set_owner[c.orig.Equiv_set]; !found || id < owner {
+				set_owner[c.orig.Equiv_set] = id
+			}
+		}
+	}
+	s.datasheetIds = s.datasheetIds[:0]
+	for id, c := range s.id2Component {
+		if text, found := s.datasheets[id]; found {
+			c.datasheet = text
+		} else if owner, found := set_owner[c.orig.Equiv_set]; found {
+			c.datasheet = s.datasheets[owner]
+		} else {
+			c.datasheet = ""
+		}
+		if c.datasheet != "" {
+			s.datasheetIds = append(s.datasheetIds, id)
+		}
+	}
+	sort.Ints(s.datasheetIds)
+}
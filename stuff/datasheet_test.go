@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestDatasheetSearch(t *testing.T) {
+	fts := NewFulltextSearch()
+	fts.Update(&Component{Id: 1, Equiv_set: 1, Category: "IC", Value: "LM358"})
+	fts.Update(&Component{Id: 2, Equiv_set: 1, Category: "IC", Value: "LM2904"})
+	fts.Update(&Component{Id: 3, Equiv_set: 3, Category: "IC", Value: "TLV2372", Description: "Rail-to-rail opamp"})
+	fts.Update(&Component{Id: 4, Equiv_set: 4, Category: "IC", Value: "NE555"})
+	ids := func(query string) string {
+		return fmt.Sprint(resultIds(fts.Search(query)))
+	}
+	expectEqual(t, ids("rail-to-rail"), "[3]")
+
+	fts.SetDatasheet(1, "LM358 low power dual op-amp. Output is rail-to-rail on the low side.")
+	expectEqual(t, ids("rail-to-rail"), "[3 2 1]") // Set shares it, ranked low.
+	expectEqual(t, ids("datasheet:low power"), "[2 1]")
+	expectEqual(t, fts.Search("railtorail").Suggestion, "") // Known word.
+
+	// Other set: datasheet does not apply anymore.
+	fts.Update(&Component{Id: 2, Equiv_set: 2, Category: "IC", Value: "LM2904"})
+	expectEqual(t, ids("rail-to-rail"), "[3 1]")
+	// Own datasheet wins over the set's.
+	fts.Update(&Component{Id: 4, Equiv_set: 1, Category: "IC", Value: "NE555"})
+	fts.SetDatasheet(4, "Timer")
+	expectEqual(t, ids("rail-to-rail"), "[3 1]")
+	expectEqual(t, ids("timer"), "[4]")
+
+	fts.SetDatasheet(1, "")
+	expectEqual(t, ids("rail-to-rail"), "[3]")
+}
+
+func resultIds(result *SearchResult) []int {
+	ids := make([]int, len(result.Results))
+	for i, c := range result.Results {
+		ids[i] = c.Id
+	}
+	return ids
+}
+
+func TestDatasheetText(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "datasheet")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+	store.EditRecord(1, func(c *Component) bool { c.Value = "LM358"; return true })
+	store.EditRecord(2, func(c *Component) bool { c.Value = "NE555"; return true })
+	store.SetDatasheetText(1, "Rail-to-rail output")
+	ExpectTrue(t, store.DatasheetTextIds()[1], "Stored")
+	ExpectTrue(t, len(store.Search("rail-to-rail").Results) == 1, "Searched")
+
+	ExpectTrue(t, store.SwapComponents(1, 2) == nil, "Swap")
+	ExpectTrue(t, store.DatasheetTextIds()[2] && !store.DatasheetTextIds()[1], "Moved along")
+	result := store.Search("rail-to-rail").Results
+	ExpectTrue(t, len(result) == 1 && result[0].Id == 2, "Search follows")
+
+	// Still there after a restart.
+	store, _ = NewSqlStuffStore(db, false)
+	result = store.Search("rail-to-rail").Results
+	ExpectTrue(t, len(result) == 1 && result[0].Id == 2, "Loaded")
+
+	store.SetDatasheetText(2, "")
+	ExpectTrue(t, len(store.DatasheetTextIds()) == 0, "Removed")
+	ExpectTrue(t, len(store.Search("rail-to-rail").Results) == 0, "Not searched")
+}
@@ -0,0 +1,215 @@
+// Review likely duplicate components and merge them into one record.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kDuplicatesPage = "/duplicates"
+	kMergePage      = "/duplicates/merge"
+
+	kDuplicatesMaxShown = 200
+)
+
+type DuplicatesHandler struct {
+	store    StuffStore
+	template *TemplateRenderer
+	imgPath  string
+	editNets []*net.IPNet
+}
+
+func AddDuplicatesHandler(store StuffStore, template *TemplateRenderer, imgPath string, editNets []*net.IPNet) {
+	handler := &DuplicatesHandler{
+		store:    store,
+		template: template,
+		imgPath:  imgPath,
+		editNets: editNets,
+	}
+	http.Handle(kDuplicatesPage, handler)
+	http.Handle(kMergePage, handler)
+}
+
+// The text fields of a component an editor can pick from either record.
+var mergeableFields = []struct {
+	name  string
+	label string
+	field func(c *Component) *string
+}{
+	{"category", "Category", func(c *Component) *string { return &c.Category }},
+	{"value", "Name/Value", func(c *Component) *string { return &c.Value }},
+	{"footprint", "Footprint", func(c *Component) *string { return &c.Footprint }},
+	{"description", "Description", func(c *Component) *string { return &c.Description }},
+	{"notes", "Notes", func(c *Component) *string { return &c.Notes }},
+	{"datasheet", "Datasheet", func(c *Component) *string { return &c.Datasheet_url }},
+	{"vendor", "Vendor", func(c *Component) *string { return &c.Vendor }},
+}
+
+type DuplicatesPage struct {
+	Candidates []*DuplicateCandidate
+	Total      int
+}
+
+type MergeField struct {
+	Name  string
+	Label string
+	A, B  string
+	PickB bool // Default choice
+}
+
+type MergePage struct {
+	A, B        *Component
+	Fields      []MergeField
+	Quantity    string
+	EditAllowed bool
+}
+
+func (h *DuplicatesHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+	defer ElapsedPrint("Duplicates", time.Now())
+	switch {
+	case strings.HasPrefix(req.URL.Path, kMergePage):
+		h.mergePage(out, req)
+	default:
+		candidates := findDuplicates(h.store)
+		page := &DuplicatesPage{
+			Candidates: candidates,
+			Total:      len(candidates),
+		}
+		if len(page.Candidates) > kDuplicatesMaxShown {
+			page.Candidates = page.Candidates[:kDuplicatesMaxShown]
+		}
+		h.template.Render(out, "duplicates.html", page)
+	}
+}
+
+func (h *DuplicatesHandler) mergePage(out http.ResponseWriter, r *http.Request) {
+	id_a, _ := strconv.Atoi(r.FormValue("a"))
+	id_b, _ := strconv.Atoi(r.FormValue("b"))
+	page := &MergePage{
+		A:           h.store.FindById(id_a),
+		B:           h.store.FindById(id_b),
+		EditAllowed: editAllowed(r, h.editNets),
+	}
+	if page.A == nil || page.B == nil || id_a == id_b {
+		http.Error(out, "Need two different, existing components", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "POST" && r.FormValue("op") == "merge" {
+		if !page.EditAllowed {
+			http.Error(out, "Not allowed to edit", http.StatusForbidden)
+			return
+		}
+		from, into := page.B, page.A
+		if r.FormValue("keep") == "b" {
+			from, into = page.A, page.B
+		}
+		merged := *into
+		for _, f := range mergeableFields {
+			if r.FormValue("pick_"+f.name) == "b" {
+				*f.field(&merged) = *f.field(page.B)
+			} else {
+				*f.field(&merged) = *f.field(page.A)
+			}
+		}
+		merged.Quantity = r.FormValue("quantity")
+		merged.Drawersize = max(page.A.Drawersize, page.B.Drawersize)
+		merged.Min_stock = max(page.A.Min_stock, page.B.Min_stock)
+		if err := h.mergeComponents(from, into, merged); err != nil {
+			log.Printf("Merge %d into %d: %v", from.Id, into.Id, err)
+			http.Error(out, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Merged %d into %d", from.Id, into.Id)
+		http.Redirect(out, r, fmt.Sprintf("/form?id=%d", into.Id), http.StatusSeeOther)
+		return
+	}
+
+	page.Quantity = sumQuantities(page.A.Quantity, page.B.Quantity)
+	for _, f := range mergeableFields {
+		a, b := *f.field(page.A), *f.field(page.B)
+		page.Fields = append(page.Fields, MergeField{
+			Name:  f.name,
+			Label: f.label,
+			A:     a,
+			B:     b,
+			PickB: len(b) > len(a), // More information is typically better
+		})
+	}
+	h.template.Render(out, "merge-form.html", page)
+}
+
+// Merge component "from" into "into": move all the images and the
+// datasheet over, store the merged record under the ID of "into" and mark
+// the bin of "from" as empty. The files are moved first: if that fails,
+// they are moved back and both records are unchanged, so the stock is not
+// counted twice.
+func (h *DuplicatesHandler) mergeComponents(from, into *Component, merged Component) error {
+	renames := &fileRenames{}
+	moved_datasheet, err := h.moveFiles(from.Id, into.Id, renames)
+	if err != nil {
+		if undo_err := renames.undo(); undo_err != nil {
+			return fmt.Errorf("moving files failed: %v; moving them back failed: %v", err, undo_err)
+		}
+		return fmt.Errorf("moving files failed, not merged: %v", err)
+	}
+	merged.Id = into.Id
+	cleanupComponent(&merged)
+	if err := editFailure(h.store.EditRecord(into.Id, func(c *Component) bool {
+		*c = merged
+		return true
+	})); err != nil {
+		return fmt.Errorf("images moved, but storing the merged record failed: %v", err)
+	}
+	// The part is not in that bin anymore, so it is not part of any set.
+	h.store.LeaveSet(from.Id)
+	h.store.SetDatasheetText(from.Id, "")
+	if moved_datasheet {
+		if err := indexDatasheet(h.store, datasheetPath(h.imgPath, into.Id), into.Id); err != nil {
+			log.Printf("Datasheet of %d: %v", into.Id, err)
+		}
+	}
+	if err := editFailure(h.store.EditRecord(from.Id, func(c *Component) bool {
+		*c = Component{
+			Id:    from.Id,
+			Value: "empty",
+			Notes: fmt.Sprintf("Merged into %d", into.Id),
+		}
+		return true
+	})); err != nil {
+		return fmt.Errorf("merged, but emptying %d failed: %v", from.Id, err)
+	}
+	return nil
+}
+
+// Move the images of "from" into the gallery of "into", and its datasheet
+// unless "into" has its own; then it is kept as a replaced one. Returns if
+// "into" got the datasheet.
+func (h *DuplicatesHandler) moveFiles(from, into int, renames *fileRenames) (bool, error) {
+	if err := moveImagesToGallery(h.imgPath, from, into, renames); err != nil {
+		return false, err
+	}
+	datasheet := datasheetPath(h.imgPath, from)
+	if !fileExists(datasheet) {
+		return false, nil
+	}
+	if target := datasheetPath(h.imgPath, into); !fileExists(target) {
+		return true, renames.rename(datasheet, target)
+	}
+	return false, renames.rename(datasheet, replacedPath(h.imgPath, into, ".pdf"))
+}
+
+// The error of an EditRecord() call; not storing an unchanged record is fine.
+func editFailure(stored bool, msg string) error {
+	if stored || msg == "" || msg == "No change." {
+		return nil
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,239 @@
+// Finding components that are likely catalogued more than once.
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	valueNoise      = regexp.MustCompile(`(?i)(\s+|-|_|ohms?|Ω)`)
+	capacitorSuffix = regexp.MustCompile(`([0-9][pnuµm])f\b`)
+	plainNumber     = regexp.MustCompile(`^\d+$`)
+)
+
+// Categories that are, for the purpose of finding duplicates, the same thing.
+var categoryFamilies = map[string]string{
+	"capacitor (c)":           "capacitor",
+	"aluminum cap":            "capacitor",
+	"diode (d)":               "diode",
+	"power diode":             "diode",
+	"transistor":              "transistor",
+	"mosfet":                  "transistor",
+	"igbt":                    "transistor",
+	"integrated circuit (ic)": "ic",
+	"ic analog":               "ic",
+	"ic digital":              "ic",
+	"connector":               "connector",
+	"socket":                  "connector",
+}
+
+// Minimum score of a pair of components to be considered duplicates.
+const kDuplicateThreshold = 0.6
+
+type DuplicateCandidate struct {
+	A, B  *Component
+	Score float32
+}
+
+// Reduce the value to something that makes different spellings comparable,
+// e.g. "10 kOhm" and "10k", or "100nF" and "100n".
+func normalizeValue(value string) string {
+	value = strings.ToLower(value)
+	value = strings.Replace(value, "µ", "u", -1)
+	value = valueNoise.ReplaceAllString(value, "")
+	return capacitorSuffix.ReplaceAllString(value, "$1")
+}
+
+// Split a value into comparable tokens. Values with SI prefix become plain
+// numbers, so "0.1uF", "100nF" and "100 n" all result in "1e-07"; other
+// tokens are normalized with normalizeValue().
+func valueTokens(value string) []string {
+	fields := strings.Fields(value)
+	result := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		token := fields[i]
+		// A number separated from its unit, e.g. "10 kOhm"
+		if plainNumber.MatchString(token) && i+1 < len(fields) {
+			if _, ok := parseValue(token + fields[i+1]); ok {
+				token += fields[i+1]
+				i++
+			}
+		}
+		token = strings.NewReplacer("-", "", "_", "").Replace(token)
+		if value, ok := parseValue(token); ok {
+			// Enough digits to not mix up long part numbers, few
+			// enough to hide rounding errors.
+			token = strconv.FormatFloat(value.number, 'g', 12, 64)
+		} else {
+			token = normalizeValue(token)
+		}
+		if token != "" {
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+// Returns true if both values likely describe the same part: the same after
+// normalization, or one is just a more detailed version of the other, such
+// as "2N3904" and "2N3904 NPN".
+func relatedValues(a, b string) bool {
+	tokens_a, tokens_b := valueTokens(a), valueTokens(b)
+	if len(tokens_a) > len(tokens_b) {
+		tokens_a, tokens_b = tokens_b, tokens_a
+	}
+	if len(tokens_a) == 0 {
+		return false
+	}
+	for i, t := range tokens_a {
+		if t != tokens_b[i] {
+			return false
+		}
+	}
+	if len(tokens_a) == len(tokens_b) {
+		return true
+	}
+	// Don't relate everything starting with e.g. "LM" or "IC".
+	_, err := strconv.ParseFloat(tokens_a[0], 64)
+	return err == nil || len(strings.Join(tokens_a, "")) >= 4
+}
+
+func categoryFamily(category string) string {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if family, found := categoryFamilies[category]; found {
+		return family
+	}
+	return category
+}
+
+func normalizeFootprint(footprint string) string {
+	return valueNoise.ReplaceAllString(strings.ToLower(footprint), "")
+}
+
+// Score how likely it is that both components are the same part. Zero if
+// they are clearly not.
+func duplicateScore(a, b *Component) float32 {
+	value_a := normalizeValue(a.Value)
+	value_b := normalizeValue(b.Value)
+	if len(value_a) == 0 || len(value_b) == 0 {
+		return 0
+	}
+	var score float32
+	switch {
+	case value_a == value_b:
+		score = 0.6
+	case strings.Join(valueTokens(a.Value), " ") == strings.Join(valueTokens(b.Value), " "):
+		score = 0.6 // Different notation, e.g. 4k7 and 4.7k
+	case relatedValues(a.Value, b.Value),
+		len(value_a) >= 4 && len(value_b) >= 4 &&
+			(strings.HasPrefix(value_a, value_b) || strings.HasPrefix(value_b, value_a)):
+		// Things like 2N3904 vs. 2N3904NPN
+		score = 0.4
+	default:
+		return 0
+	}
+
+	family_a := categoryFamily(a.Category)
+	family_b := categoryFamily(b.Category)
+	switch {
+	case family_a == family_b:
+		score += 0.25
+	case family_a == "" || family_b == "":
+		score += 0.1
+	default:
+		return 0 // A 10k resistor is not a 10k potentiometer.
+	}
+
+	footprint_a := normalizeFootprint(a.Footprint)
+	footprint_b := normalizeFootprint(b.Footprint)
+	switch {
+	case footprint_a == footprint_b:
+		score += 0.15
+	case footprint_a == "" || footprint_b == "":
+		score += 0.05
+	default:
+		score -= 0.3 // Different packages rightfully live in different bins.
+	}
+	return score
+}
+
+func isEmptyBin(c *Component) bool {
+	return strings.Contains(strings.ToLower(c.Value), "empty") ||
+		strings.Contains(strings.ToLower(c.Category), "empty")
+}
+
+// Find pairs of components that are likely the same part. Ordered by score,
+// highest first.
+func findDuplicates(store StuffStore) []*DuplicateCandidate {
+	// Only compare components that share the beginning of their
+	// first value token; prefix matches are the most lenient we score.
+	buckets := make(map[string][]*Component)
+	store.IterateAll(func(c *Component) bool {
+		if isEmptyBin(c) {
+			return true
+		}
+		tokens := valueTokens(c.Value)
+		if len(tokens) == 0 {
+			return true
+		}
+		// Values are either the same number, or share a prefix.
+		key := tokens[0]
+		if _, err := strconv.ParseFloat(key, 64); err != nil && len(key) > 4 {
+			key = key[:4]
+		}
+		buckets[key] = append(buckets[key], c)
+		return true
+	})
+	result := make([]*DuplicateCandidate, 0)
+	for _, bucket := range buckets {
+		for i := 0; i < len(bucket); i++ {
+			for j := i + 1; j < len(bucket); j++ {
+				if bucket[i].Equiv_set == bucket[j].Equiv_set {
+					// Already deliberately organized together.
+					continue
+				}
+				score := duplicateScore(bucket[i], bucket[j])
+				if score < kDuplicateThreshold {
+					continue
+				}
+				result = append(result, &DuplicateCandidate{
+					A:     bucket[i],
+					B:     bucket[j],
+					Score: score,
+				})
+			}
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Score != result[b].Score {
+			return result[a].Score > result[b].Score
+		}
+		if result[a].A.Id != result[b].A.Id {
+			return result[a].A.Id < result[b].A.Id
+		}
+		return result[a].B.Id < result[b].B.Id
+	})
+	return result
+}
+
+// Add up two free-form quantities. If both are plain numbers, this is a
+// simple sum, otherwise we keep both for a human to sort out.
+func sumQuantities(a, b string) string {
+	a = strings.TrimSpace(a)
+	b = strings.TrimSpace(b)
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	case plainNumber.MatchString(a) && plainNumber.MatchString(b):
+		num_a, _ := strconv.Atoi(a)
+		num_b, _ := strconv.Atoi(b)
+		return strconv.Itoa(num_a + num_b)
+	default:
+		return a + " + " + b
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestNormalizeValue(t *testing.T) {
+	expectEqual(t, normalizeValue("10 kOhm"), "10k")
+	expectEqual(t, normalizeValue("10K"), "10k")
+	expectEqual(t, normalizeValue("100nF"), "100n")
+	expectEqual(t, normalizeValue("4.7µF"), "4.7u")
+	expectEqual(t, normalizeValue("LM-358"), "lm358")
+}
+
+func TestRelatedValues(t *testing.T) {
+	ExpectTrue(t, relatedValues("0.1uF", "100nF"), "0.1uF")
+	ExpectTrue(t, relatedValues("100n", "100nF"), "100n")
+	ExpectTrue(t, relatedValues("4k7", "4.7k"), "4k7")
+	ExpectTrue(t, relatedValues("10 kOhm", "10K"), "10 kOhm")
+	ExpectTrue(t, relatedValues("2N3904", "2N3904 NPN"), "2N3904")
+	ExpectTrue(t, relatedValues("1k 1%", "1k"), "tolerance")
+
+	ExpectTrue(t, !relatedValues("2N3904", "2N3906"), "2N3906")
+	ExpectTrue(t, !relatedValues("10k", "1k"), "1k")
+	ExpectTrue(t, !relatedValues("100nF", "100uF"), "100uF")
+	ExpectTrue(t, !relatedValues("LM", "LM 317"), "Too short")
+	ExpectTrue(t, !relatedValues("44-00853-010", "44-00853-272"), "Part numbers")
+	ExpectTrue(t, !relatedValues("", ""), "Empty")
+}
+
+func TestDuplicateScore(t *testing.T) {
+	resistor := &Component{Category: "Resistor", Value: "10k"}
+	ExpectTrue(t, duplicateScore(resistor, &Component{Category: "Resistor", Value: "10 kOhm"}) >= kDuplicateThreshold, "Same resistor")
+	ExpectTrue(t, duplicateScore(resistor, &Component{Category: "Potentiometer", Value: "10k"}) == 0, "Not a pot")
+	ExpectTrue(t, duplicateScore(resistor, &Component{Category: "Resistor", Value: "1k"}) == 0, "Other value")
+
+	cap := &Component{Category: "Capacitor (C)", Value: "100nF"}
+	ExpectTrue(t, duplicateScore(cap, &Component{Category: "Aluminum Cap", Value: "100n"}) >= kDuplicateThreshold, "Capacitor family")
+	ExpectTrue(t, duplicateScore(cap, &Component{Category: "Capacitor (C)", Value: "0.1µF"}) >= kDuplicateThreshold, "Other notation")
+
+	transistor := &Component{Category: "Transistor", Value: "2N3904", Footprint: "TO-92"}
+	ExpectTrue(t, duplicateScore(transistor, &Component{Category: "Transistor", Value: "2N3904 NPN", Footprint: "to92"}) >= kDuplicateThreshold, "Prefix match")
+	ExpectTrue(t, duplicateScore(transistor, &Component{Category: "Transistor", Value: "2N3904", Footprint: "SOT-23"}) < kDuplicateThreshold, "Different footprint")
+}
+
+func TestSumQuantities(t *testing.T) {
+	expectEqual(t, sumQuantities("10", "32"), "42")
+	expectEqual(t, sumQuantities("", "32"), "32")
+	expectEqual(t, sumQuantities("10", " "), "10")
+	expectEqual(t, sumQuantities("< 50", "20"), "< 50 + 20")
+}
+
+func TestFindDuplicates(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "duplicates")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+	store.EditRecord(1, func(c *Component) bool { c.Category = "Resistor"; c.Value = "10k"; return true })
+	store.EditRecord(2, func(c *Component) bool { c.Category = "Resistor"; c.Value = "4.7k"; return true })
+	store.EditRecord(3, func(c *Component) bool { c.Category = "Resistor"; c.Value = "10K Ohm"; return true })
+	store.EditRecord(4, func(c *Component) bool { c.Category = "Potentiometer"; c.Value = "10k"; return true })
+
+	found := findDuplicates(store)
+	ExpectTrue(t, len(found) == 1, fmt.Sprintf("Expected one pair, got %d", len(found)))
+	ExpectTrue(t, found[0].A.Id == 1 && found[0].B.Id == 3, "Resistor pair")
+}
+
+func TestMoveImagesToGallery(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "images")
+	defer os.RemoveAll(dir)
+	writeImage := func(path string) {
+		if err := os.WriteFile(dir+"/"+path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(dir+"/1", 0755)
+	writeImage("1.jpg")
+	writeImage("1/1.jpg")
+	writeImage("1/2.jpg")
+	writeImage("2.jpg")
+
+	renames := &fileRenames{}
+	if err := moveImagesToGallery(dir, 1, 2, renames); err != nil {
+		t.Fatal(err)
+	}
+	expectContent := func(path string, content string) {
+		got, _ := os.ReadFile(dir + "/" + path)
+		expectEqual(t, string(got), content)
+	}
+	expectContent("2.jpg", "2.jpg") // Main image untouched
+	expectContent("2/1.jpg", "1.jpg")
+	expectContent("2/2.jpg", "1/1.jpg")
+	expectContent("2/3.jpg", "1/2.jpg")
+	ExpectTrue(t, !fileExists(dir+"/1.jpg"), "Image moved away")
+	ExpectTrue(t, !fileExists(dir+"/1"), "Gallery directory removed")
+
+	// Moved back, e.g. because a later step failed.
+	if err := renames.undo(); err != nil {
+		t.Fatal(err)
+	}
+	expectContent("1.jpg", "1.jpg")
+	expectContent("1/1.jpg", "1/1.jpg")
+	expectContent("1/2.jpg", "1/2.jpg")
+	ExpectTrue(t, !fileExists(dir+"/2"), "Created gallery directory removed")
+}
+
+func TestMergeComponents(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "merge")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+	dir, _ := os.MkdirTemp("", "images")
+	defer os.RemoveAll(dir)
+	h := &DuplicatesHandler{store: store, imgPath: dir}
+	store.EditRecord(1, func(c *Component) bool { c.Value = "10k"; c.Quantity = "10"; return true })
+	store.EditRecord(2, func(c *Component) bool { c.Value = "10K"; c.Quantity = "5"; return true })
+	os.WriteFile(dir+"/1.jpg", []byte("image"), 0644)
+	os.WriteFile(dir+"/1.pdf", makeTestPdf(pdfStream("", "BT (Thick film) Tj ET", true)), 0644)
+	store.SetDatasheetText(1, "Thick film")
+
+	// Images can't be moved: nothing changes.
+	os.WriteFile(dir+"/2", []byte("not a directory"), 0644)
+	merged := *store.FindById(2)
+	merged.Quantity = "15"
+	ExpectTrue(t, h.mergeComponents(store.FindById(1), store.FindById(2), merged) != nil, "Failed")
+	expectEqual(t, store.FindById(1).Quantity, "10")
+	expectEqual(t, store.FindById(2).Quantity, "5")
+
+	os.Remove(dir + "/2")
+	ExpectTrue(t, h.mergeComponents(store.FindById(1), store.FindById(2), merged) == nil, "Merged")
+	expectEqual(t, store.FindById(2).Quantity, "15")
+	expectEqual(t, store.FindById(1).Value, "empty")
+	ExpectTrue(t, fileExists(dir+"/2/0.jpg"), "Image moved")
+	ExpectTrue(t, fileExists(dir+"/2.pdf") && !fileExists(dir+"/1.pdf"), "Datasheet moved")
+	ids := store.DatasheetTextIds()
+	ExpectTrue(t, ids[2] && !ids[1], "Datasheet text moved")
+	result := store.Search("thick film").Results
+	ExpectTrue(t, len(result) == 1 && result[0].Id == 2, "Empty bin not found")
+
+	// With a datasheet of its own, the other one is kept aside.
+	store.EditRecord(3, func(c *Component) bool { c.Value = "10k"; return true })
+	os.WriteFile(dir+"/3.pdf", []byte("other"), 0644)
+	ExpectTrue(t, h.mergeComponents(store.FindById(3), store.FindById(2), merged) == nil, "Merged")
+	ExpectTrue(t, fileExists(dir+"/2.pdf") && fileExists(dir+"/2-replaced.pdf"), "Kept")
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"math"
 	"net"
 	"net/http"
 	"regexp"
@@ -18,6 +17,7 @@ const (
 	kFormPage = "/form"
 	kSetApi   = "/api/related-set"
 	kInfoApi  = "/api/info"
+	kMoveApi  = "/api/move"
 )
 
 // Some useful pre-defined set of categories
@@ -49,6 +49,7 @@ func AddFormHandler(store StuffStore, template *TemplateRenderer, imgPath string
 	http.Handle(kFormPage, handler)
 	http.Handle(kSetApi, handler)
 	http.Handle(kInfoApi, handler)
+	http.Handle(kMoveApi, handler)
 }
 
 func (h *FormHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
@@ -57,6 +58,8 @@ func (h *FormHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
 		h.relatedComponentSetOperations(out, req)
 	case strings.HasPrefix(req.URL.Path, kInfoApi):
 		h.apiInfo(out, req)
+	case strings.HasPrefix(req.URL.Path, kMoveApi):
+		h.moveComponent(out, req)
 	default:
 		h.entryFormHandler(out, req)
 	}
@@ -73,6 +76,9 @@ type FormPage struct {
 	PageTitle         string
 	ImageUrl          string
 	DatasheetLinkText string // Abbreviated link for display
+	DatasheetPdf      string // Link to the uploaded datasheet, own or of the set.
+	OwnDatasheetPdf   bool   // The uploaded datasheet is not just the one of the set.
+	LastEdited        string // Human readable, e.g. "3 days ago"
 
 	DescriptionRows int // Number of rows displayed in textarea
 	NotesRows       int
@@ -104,34 +110,53 @@ type JsonInfoComponent struct {
 
 // -- TODO: For cleanup, we need some kind of category-aware plugin structure.
 
+// The value cleanup is also used when indexing for search, so we only
+// want to compile these once.
+var (
+	optional_ppm      = regexp.MustCompile(`(?i)[,;]\s*(\d+\s*ppm)`)
+	optional_percent  = regexp.MustCompile(`[,;]\s*((\+/-\s*)?(0?\.)?\d+\%)`)
+	optional_watt     = regexp.MustCompile(`(?i)[,;]\s*((((\d*\.)?\d+)|(\d+/\d+))\s*W(att)?)`)
+	optional_ohm      = regexp.MustCompile(`(?i)\s*ohm`)
+	spaced_upper_kilo = regexp.MustCompile(`(?i)\s*k$`)
+	farad_value       = regexp.MustCompile(`(?i)^((\d*.)?\d+)\s*([uµnp])F(.*)$`)
+	three_digit       = regexp.MustCompile(`(?i)^(\d\d)(\d)\s*([dfghjkmpz])?$`)
+)
+
 func cleanupResistor(c *Component) {
-	optional_ppm, _ := regexp.Compile(`(?i)[,;]\s*(\d+\s*ppm)`)
 	if match := optional_ppm.FindStringSubmatch(c.Value); match != nil {
 		c.Description = strings.ToLower(match[1]) + "; " + c.Description
 		c.Value = optional_ppm.ReplaceAllString(c.Value, "")
 	}
 
 	// Move percent into description.
-	optional_percent, _ := regexp.Compile(`[,;]\s*((\+/-\s*)?(0?\.)?\d+\%)`)
 	if match := optional_percent.FindStringSubmatch(c.Value); match != nil {
 		c.Description = match[1] + "; " + c.Description
 		c.Value = optional_percent.ReplaceAllString(c.Value, "")
 	}
 
-	optional_watt, _ := regexp.Compile(`(?i)[,;]\s*((((\d*\.)?\d+)|(\d+/\d+))\s*W(att)?)`)
 	if match := optional_watt.FindStringSubmatch(c.Value); match != nil {
 		c.Description = match[1] + "; " + c.Description
 		c.Value = optional_watt.ReplaceAllString(c.Value, "")
 	}
 
-	// Get rid of Ohm
-	optional_ohm, _ := regexp.Compile(`(?i)\s*ohm`)
-	c.Value = optional_ohm.ReplaceAllString(c.Value, "")
+	if value, ok := parseValue(c.Value); ok && (value.unit == "" || value.unit == "ohm") {
+		if value.number >= 1 {
+			// Canonical notation, e.g. 4k7 Ohm becomes 4.7k
+			c.Value = formatValue(value.number, "", kResistorPrefixes, value.digits)
+		} else {
+			// Sub-ohm values are kept as written, e.g. 0.47 or R47.
+			c.Value = optional_ohm.ReplaceAllString(c.Value, "")
+		}
+	} else if ohm, ok := parseEIA96(strings.TrimSpace(c.Value)); ok {
+		c.Value = formatValue(ohm, "", kResistorPrefixes, 3)
+	} else {
+		// Get rid of Ohm
+		c.Value = optional_ohm.ReplaceAllString(c.Value, "")
 
-	// Upper-case kilo at end or with spaces before are replaced
-	// with simple 'k'.
-	spaced_upper_kilo, _ := regexp.Compile(`(?i)\s*k$`)
-	c.Value = spaced_upper_kilo.ReplaceAllString(c.Value, "k")
+		// Upper-case kilo at end or with spaces before are replaced
+		// with simple 'k'.
+		c.Value = spaced_upper_kilo.ReplaceAllString(c.Value, "k")
+	}
 
 	c.Description = cleanString(c.Description)
 	c.Value = cleanString(c.Value)
@@ -161,27 +186,8 @@ func createLinkTextFromUrl(u string) string {
 	return shortenurl.ReplaceAllString(u, "$2/…$4")
 }
 
-// Format a float value with single digit precision, but remove unneccessary .0
-// (mmh, this looks like there should be some standard formatting modifier that
-// does exactly that.
-func fmtFloatNoZero(f float64) string {
-	result := fmt.Sprintf("%.1f", f)
-	if strings.HasSuffix(result, ".0") {
-		return result[:len(result)-2] // remove trailing zero
-	} else {
-		return result
-	}
-}
-
 func makeCapacitanceString(farad float64) string {
-	switch {
-	case farad < 1000e-12:
-		return fmtFloatNoZero(farad*1e12) + "pF"
-	case farad < 1000e-9:
-		return fmtFloatNoZero(farad*1e9) + "nF"
-	default:
-		return fmtFloatNoZero(farad*1e6) + "uF"
-	}
+	return formatValue(farad, "F", kCapacitorPrefixes, 0)
 }
 
 func translateCapacitorToleranceLetter(letter string) string {
@@ -209,28 +215,15 @@ func translateCapacitorToleranceLetter(letter string) string {
 }
 
 func cleanupCapacitor(component *Component) {
-	farad_value, _ := regexp.Compile(`(?i)^((\d*.)?\d+)\s*([uµnp])F(.*)$`)
-	three_digit, _ := regexp.Compile(`(?i)^(\d\d)(\d)\s*([dfghjkmpz])?$`)
 	if match := farad_value.FindStringSubmatch(component.Value); match != nil {
-		number_digits := match[1]
-		factor_character := match[3]
 		trailing := cleanString(match[4])
 		// Sometimes, values are written as strange multiples,
 		// e.g. 100nF is sometimes written as 0.1uF. Normalize here.
-		factor := 1e-6
-		switch factor_character {
-		case "u", "U", "µ":
-			factor = 1e-6
-		case "n", "N":
-			factor = 1e-9
-		case "p", "P":
-			factor = 1e-12
-		}
-		val, err := strconv.ParseFloat(number_digits, 32)
-		if err != nil || val < 0 {
+		value, ok := parseValue(match[1] + match[3] + "F")
+		if !ok {
 			return // Strange value. Don't touch.
 		}
-		component.Value = makeCapacitanceString(val * factor)
+		component.Value = makeCapacitanceString(value.number)
 		if len(trailing) > 0 {
 			if len(component.Description) > 0 {
 				component.Description = trailing + "; " + component.Description
@@ -239,15 +232,12 @@ func cleanupCapacitor(component *Component) {
 			}
 		}
 	} else if match := three_digit.FindStringSubmatch(component.Value); match != nil {
-		value, _ := strconv.ParseFloat(match[1], 32)
-		magnitude, _ := strconv.ParseFloat(match[2], 32)
-		tolerance_letter := match[3]
-		if magnitude < 0 || magnitude > 6 {
+		farad, ok := parseEIACode(match[1]+match[2], 1e-12)
+		if !ok {
 			return
 		}
-		multiplier := math.Exp(magnitude*math.Log(10)) * 1e-12
-		component.Value = makeCapacitanceString(value * multiplier)
-		tolerance := translateCapacitorToleranceLetter(tolerance_letter)
+		component.Value = makeCapacitanceString(farad)
+		tolerance := translateCapacitorToleranceLetter(match[3])
 		if len(tolerance) > 0 {
 			if len(component.Description) > 0 {
 				component.Description = tolerance + "; " + component.Description
@@ -270,8 +260,16 @@ func cleanupComponent(component *Component) {
 	component.Quantity = cleanString(component.Quantity)
 	component.Notes = cleanString(component.Notes)
 	component.Datasheet_url = cleanString(component.Datasheet_url)
+	component.Vendor = cleanString(component.Vendor)
+	if component.Min_stock < 0 {
+		component.Min_stock = 0
+	}
 	cleanupFootprint(component)
+	cleanupValue(component)
+}
 
+// Normalize the value depending on the category.
+func cleanupValue(component *Component) {
 	// We should have pluggable cleanup modules per category. For
 	// now just a quick hack.
 	switch component.Category {
@@ -285,7 +283,13 @@ func cleanupComponent(component *Component) {
 // If this particular request is allowed to edit. Can depend on IP address,
 // cookies etc.
 func (h *FormHandler) EditAllowed(r *http.Request) bool {
-	if len(h.editNets) == 0 {
+	return editAllowed(r, h.editNets)
+}
+
+// Check if request comes from one of the networks allowed to edit. If there
+// are no networks given, everyone is allowed.
+func editAllowed(r *http.Request, editNets []*net.IPNet) bool {
+	if len(editNets) == 0 {
 		return true // No restrictions.
 	}
 	addr, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -295,12 +299,15 @@ func (h *FormHandler) EditAllowed(r *http.Request) bool {
 	if h := r.Header["X-Forwarded-For"]; h != nil {
 		addr = h[0]
 	}
-	var ip net.IP
-	if ip = net.ParseIP(addr); ip == nil {
+	return ipInNetworks(net.ParseIP(addr), editNets)
+}
+
+func ipInNetworks(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
 		return false
 	}
-	for i := 0; i < len(h.editNets); i++ {
-		if h.editNets[i].Contains(ip) {
+	for i := 0; i < len(nets); i++ {
+		if nets[i].Contains(ip) {
 			return true
 		}
 	}
@@ -346,6 +353,7 @@ func (h *FormHandler) entryFormHandler(w http.ResponseWriter, r *http.Request) {
 
 	if requestStore && edit_allowed {
 		drawersize, _ := strconv.Atoi(r.FormValue("drawersize"))
+		min_stock, _ := strconv.Atoi(r.FormValue("min_stock"))
 		fromForm := Component{
 			Id:            edit_id,
 			Value:         r.FormValue("value"),
@@ -355,6 +363,8 @@ func (h *FormHandler) entryFormHandler(w http.ResponseWriter, r *http.Request) {
 			Datasheet_url: r.FormValue("datasheet"),
 			Drawersize:    drawersize,
 			Footprint:     r.FormValue("footprint"),
+			Vendor:        r.FormValue("vendor"),
+			Min_stock:     min_stock,
 		}
 		// If there only was a ?: operator ...
 		if r.FormValue("category_select") == "-" {
@@ -404,6 +414,14 @@ func (h *FormHandler) entryFormHandler(w http.ResponseWriter, r *http.Request) {
 			int(time.Now().UnixNano()%10000))
 	}
 	currentItem := h.store.FindById(id)
+	if currentItem == nil && r.Method == "GET" && r.FormValue("id") != "" {
+		// Links to bins that have been moved elsewhere.
+		if moved_to := h.store.FindRedirect(id); moved_to > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/form?id=%d", moved_to),
+				http.StatusMovedPermanently)
+			return
+		}
+	}
 	http_code := http.StatusOK
 	if currentItem != nil {
 		page.Component = *currentItem
@@ -412,6 +430,13 @@ func (h *FormHandler) entryFormHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		page.PageTitle += currentItem.Value
 		page.DatasheetLinkText = createLinkTextFromUrl(currentItem.Datasheet_url)
+		if path := findDatasheet(h.store, h.imgPath, currentItem); path != "" {
+			page.DatasheetPdf = fmt.Sprintf("%s%d", kDatasheet, id)
+			page.OwnDatasheetPdf = path == datasheetPath(h.imgPath, id)
+		}
+		if changed := lastChange(currentItem); changed != nil {
+			page.LastEdited = humanizeAge(*changed, time.Now())
+		}
 	} else {
 		http_code = http.StatusNotFound
 		msg = msg + fmt.Sprintf(" (%d: New item)", id)
@@ -516,6 +541,7 @@ func (h *FormHandler) relatedComponentSetRemove(out http.ResponseWriter, r *http
 
 type EquivalenceSet struct {
 	Id    int
+	Name  string
 	Items []*Component
 }
 type EquivalenceSetList struct {
@@ -554,6 +580,9 @@ func (h *FormHandler) relatedComponentSetHtml(out http.ResponseWriter, r *http.R
 				Id:    c.Equiv_set,
 				Items: make([]*Component, 0, 5),
 			}
+			if info := h.store.FindEquivSetInfo(c.Equiv_set); info != nil {
+				current_set.Name = info.Name
+			}
 			page.Sets = append(page.Sets, current_set)
 		}
 		current_set.Items = append(current_set.Items, c)
@@ -561,6 +590,49 @@ func (h *FormHandler) relatedComponentSetHtml(out http.ResponseWriter, r *http.R
 	h.template.Render(out, "set-drag-drop.html", page)
 }
 
+// Move a component to a different bin, or swap two bins.
+func (h *FormHandler) moveComponent(out http.ResponseWriter, r *http.Request) {
+	from, err := strconv.Atoi(r.FormValue("from"))
+	if err != nil {
+		http.Error(out, "Invalid from ID", http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.Atoi(r.FormValue("to"))
+	if err != nil || to < 0 {
+		http.Error(out, "Invalid target ID", http.StatusBadRequest)
+		return
+	}
+	if !h.EditAllowed(r) {
+		http.Error(out, "Not allowed to edit", http.StatusForbidden)
+		return
+	}
+	swap := r.FormValue("swap") != ""
+	mapping := map[int]int{from: to}
+	if swap {
+		mapping[to] = from
+	}
+	// Files first: renaming them back is possible if the records can't be
+	// changed, while the records are changed in one transaction.
+	undoImages, err := renumberComponentImages(h.imgPath, mapping)
+	if err == nil {
+		if swap {
+			err = h.store.SwapComponents(from, to)
+		} else {
+			err = h.store.MoveComponent(from, to)
+		}
+		if err != nil {
+			if undo_err := undoImages(); undo_err != nil {
+				err = fmt.Errorf("%v; renaming images back failed: %v", err, undo_err)
+			}
+		}
+	}
+	if err != nil {
+		http.Error(out, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(out, r, fmt.Sprintf("/form?id=%d", to), http.StatusSeeOther)
+}
+
 // Search for an item with a given ID, and present the information in an JSON endpoint.
 func (h *FormHandler) apiInfo(out http.ResponseWriter, r *http.Request) {
 	out.Header().Set("Cache-Control", "max-age=10")
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
 	"testing"
 )
 
@@ -35,6 +41,12 @@ func TestCleanResistor(t *testing.T) {
 	testResistor(t, "150K, .1%, 1/4W", "150k", "1/4W; .1%;")
 	testResistor(t, "150K, +/- .1%, 100ppm", "150k", "+/- .1%; 100ppm;")
 	testResistor(t, "150K; +/- 0.25%; 5 wAtT, 100 PPM", "150k", "5 wAtT; +/- 0.25%; 100 ppm;")
+
+	testResistor(t, "1,000", "1k", "")  // Thousands separator.
+	testResistor(t, "4,7k", "4.7k", "") // Decimal comma.
+	testResistor(t, "0.47", "0.47", "") // Sub-ohm values as written.
+	testResistor(t, "R47", "R47", "")
+	testResistor(t, "0.1 Ohm", "0.1", "")
 }
 
 func testPackage(t *testing.T, input string, expected string) {
@@ -104,3 +116,33 @@ func TestCleanCapacitor(t *testing.T) {
 	testCapacitor(t, "1000", "1000", "")
 	testCapacitor(t, "157k", "157k", "")
 }
+
+func TestMoveComponentKeepsImagesInSync(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "move")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+	dir, _ := os.MkdirTemp("", "images")
+	defer os.RemoveAll(dir)
+	h := &FormHandler{store: store, imgPath: dir}
+	store.EditRecord(1, func(c *Component) bool { c.Value = "LM358"; return true })
+	store.EditRecord(2, func(c *Component) bool { c.Value = "NE555"; return true })
+	os.WriteFile(dir+"/1.jpg", []byte("1.jpg"), 0644)
+	move := func(params string) int {
+		out := httptest.NewRecorder()
+		h.moveComponent(out, httptest.NewRequest("POST", "/api/move?"+params, nil))
+		return out.Code
+	}
+
+	// Bin 2 is not empty: neither the records nor the images change.
+	ExpectTrue(t, move("from=1&to=2") == http.StatusBadRequest, "Not moved")
+	expectEqual(t, store.FindById(1).Value, "LM358")
+	ExpectTrue(t, fileExists(dir+"/1.jpg") && !fileExists(dir+"/2.jpg"), "Image stays")
+
+	ExpectTrue(t, move("from=1&to=3") == http.StatusSeeOther, "Moved")
+	expectEqual(t, store.FindById(3).Value, "LM358")
+	ExpectTrue(t, !fileExists(dir+"/1.jpg") && fileExists(dir+"/3.jpg"), "Image moved along")
+}
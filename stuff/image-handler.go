@@ -11,12 +11,20 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	kStaticResource = "/static/"
 	kComponentImage = "/img/"
+
+	// How long we cache the list of components that have a photo.
+	kPhotoListRefresh = time.Minute
 )
 
 type ImageHandler struct {
@@ -24,6 +32,10 @@ type ImageHandler struct {
 	template   *TemplateRenderer
 	imgPath    string
 	staticPath string
+
+	photoLock sync.Mutex
+	photoIds  map[int]bool // Components that have a photo.
+	photoTime time.Time    // When photoIds was last read.
 }
 
 // There can be multiple images per part. The main ID describes the
@@ -119,6 +131,42 @@ func (h *ImageHandler) hasComponentImage(component *Component) bool {
 	return err == nil
 }
 
+// Returns true if there is a photo of the component; generated images
+// don't count. Uses a cached directory listing, so that it is cheap enough
+// to be called for every component in a search.
+func (h *ImageHandler) hasPhoto(id int) bool {
+	h.photoLock.Lock()
+	defer h.photoLock.Unlock()
+	if h.photoIds == nil || time.Since(h.photoTime) > kPhotoListRefresh {
+		h.photoIds = listPhotoIds(h.imgPath)
+		h.photoTime = time.Now()
+	}
+	return h.photoIds[id]
+}
+
+// IDs of the components with an image <id>.jpg or a gallery <id>/0.jpg
+func listPhotoIds(imgPath string) map[int]bool {
+	result := make(map[int]bool)
+	entries, err := os.ReadDir(imgPath)
+	if err != nil {
+		return result
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			id, err := strconv.Atoi(name)
+			if err == nil && fileExists(fmt.Sprintf("%s/%d/0.jpg", imgPath, id)) {
+				result[id] = true
+			}
+		} else if strings.HasSuffix(name, ".jpg") {
+			if id, err := strconv.Atoi(strings.TrimSuffix(name, ".jpg")); err == nil {
+				result[id] = true
+			}
+		}
+	}
+	return result
+}
+
 func (h *ImageHandler) sendImageIfAvailable(path string, out http.ResponseWriter) bool {
 	if _, err := os.Stat(path); err == nil {
 		sendResource(path, h.staticPath+"/fallback.png", out)
@@ -184,3 +232,169 @@ func sendResource(local_path string, fallback_resource string, out http.Response
 
 	out.Write(content)
 }
+
+// Move all images of component "from" into the gallery of component "to".
+// If "to" does not have a main image yet, the first image moved becomes it.
+// The renames are recorded, so that they can be undone if this or a later
+// step fails.
+func moveImagesToGallery(imgPath string, from int, to int, renames *fileRenames) error {
+	sources := make([]string, 0)
+	if main := fmt.Sprintf("%s/%d.jpg", imgPath, from); fileExists(main) {
+		sources = append(sources, main)
+	}
+	sources = append(sources, galleryImages(imgPath, from)...)
+	if len(sources) == 0 {
+		return nil
+	}
+	if err := renames.mkdirAll(fmt.Sprintf("%s/%d", imgPath, to)); err != nil {
+		return err
+	}
+	next := 0
+	if fileExists(fmt.Sprintf("%s/%d.jpg", imgPath, to)) {
+		next = 1 // Main image is served as gallery image zero.
+	}
+	for _, src := range sources {
+		for fileExists(fmt.Sprintf("%s/%d/%d.jpg", imgPath, to, next)) {
+			next++
+		}
+		if err := renames.rename(src, fmt.Sprintf("%s/%d/%d.jpg", imgPath, to, next)); err != nil {
+			return err
+		}
+	}
+	_ = os.Remove(fmt.Sprintf("%s/%d", imgPath, from)) // Only if empty now.
+	return nil
+}
+
+// File renames done so far, to be undone if a later step fails.
+type fileRenames struct {
+	done    [][2]string // From, to.
+	created []string    // Directories created for them.
+}
+
+func (f *fileRenames) mkdirAll(dir string) error {
+	if fileExists(dir) {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f.created = append(f.created, dir)
+	return nil
+}
+
+func (f *fileRenames) rename(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	f.done = append(f.done, [2]string{from, to})
+	return nil
+}
+
+// Rename everything back, last first, and remove the created directories.
+func (f *fileRenames) undo() error {
+	var result error
+	for i := len(f.done) - 1; i >= 0; i-- {
+		from, to := f.done[i][0], f.done[i][1]
+		os.MkdirAll(filepath.Dir(from), 0755) // Removed once empty.
+		if err := os.Rename(to, from); err != nil && result == nil {
+			result = err
+		}
+	}
+	for i := len(f.created) - 1; i >= 0; i-- {
+		_ = os.Remove(f.created[i]) // Only if empty now.
+	}
+	f.done, f.created = nil, nil
+	return result
+}
+
+// Returns the paths of all the gallery images <id>/<n>.jpg, ordered by n.
+func galleryImages(imgPath string, id int) []string {
+	entries, _ := os.ReadDir(fmt.Sprintf("%s/%d", imgPath, id))
+	numbers := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		var n int
+		if _, err := fmt.Sscanf(entry.Name(), "%d.jpg", &n); err == nil &&
+			entry.Name() == fmt.Sprintf("%d.jpg", n) {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	result := make([]string, len(numbers))
+	for i, n := range numbers {
+		result[i] = fmt.Sprintf("%s/%d/%d.jpg", imgPath, id, n)
+	}
+	return result
+}
+
+// A name for the replaced file of the component that is not taken yet:
+// <id>-replaced<ext>, <id>-replaced-2<ext>, ...
+func replacedPath(imgPath string, id int, ext string) string {
+	path := fmt.Sprintf("%s/%d-replaced%s", imgPath, id, ext)
+	for n := 2; fileExists(path); n++ {
+		path = fmt.Sprintf("%s/%d-replaced-%d%s", imgPath, id, n, ext)
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Rename the images of components according to the mapping old ID -> new ID,
+// main image as well as gallery, and their datasheets. Images at a new ID
+// that is not itself renumbered are kept with a "-replaced" suffix, numbered
+// if replaced before, so nothing gets lost.
+// Returns a function that renames everything back, to undo the renumbering
+// if changing the records fails. If renaming fails half-way, the renames
+// done so far are undone right away.
+func renumberComponentImages(imgPath string, mapping map[int]int) (undo func() error, err error) {
+	renames := &fileRenames{}
+	rename := renames.rename
+	defer func() {
+		if err != nil {
+			if undo_err := renames.undo(); undo_err != nil {
+				err = fmt.Errorf("%v; renaming back failed: %v", err, undo_err)
+			}
+		}
+	}()
+
+	imagePaths := func(id int) []string {
+		return []string{fmt.Sprintf("%s/%d.jpg", imgPath, id),
+			fmt.Sprintf("%s/%d", imgPath, id),
+			datasheetPath(imgPath, id)}
+	}
+	for _, new_id := range mapping {
+		if _, renumbered := mapping[new_id]; renumbered {
+			continue
+		}
+		for _, path := range imagePaths(new_id) {
+			if fileExists(path) {
+				if err := rename(path, replacedPath(imgPath, new_id, filepath.Ext(path))); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	// First move everything out of the way, then to the final place.
+	for old_id := range mapping {
+		for _, path := range imagePaths(old_id) {
+			if fileExists(path) {
+				if err := rename(path, path+".moving"); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for old_id, new_id := range mapping {
+		new_paths := imagePaths(new_id)
+		for i, path := range imagePaths(old_id) {
+			if fileExists(path + ".moving") {
+				if err := rename(path+".moving", new_paths[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return renames.undo, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRenumberComponentImages(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "images")
+	defer os.RemoveAll(dir)
+	writeImage := func(path string) {
+		if err := os.WriteFile(dir+"/"+path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectContent := func(path string, content string) {
+		got, _ := os.ReadFile(dir + "/" + path)
+		expectEqual(t, string(got), content)
+	}
+	os.Mkdir(dir+"/1", 0755)
+	writeImage("1.jpg")
+	writeImage("1/1.jpg")
+	writeImage("2.jpg")
+	writeImage("3.jpg")
+	writeImage("1.pdf")
+	writeImage("3.pdf")
+
+	// Swap
+	if _, err := renumberComponentImages(dir, map[int]int{1: 2, 2: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expectContent("1.jpg", "2.jpg")
+	expectContent("2.jpg", "1.jpg")
+	expectContent("2/1.jpg", "1/1.jpg")
+	ExpectTrue(t, !fileExists(dir+"/1"), "Gallery moved along")
+	expectContent("2.pdf", "1.pdf")
+	ExpectTrue(t, !fileExists(dir+"/1.pdf"), "Datasheet moved along")
+
+	// Move to a bin that still has a picture.
+	undo, err := renumberComponentImages(dir, map[int]int{2: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectContent("3.jpg", "1.jpg")
+	expectContent("3/1.jpg", "1/1.jpg")
+	expectContent("3-replaced.jpg", "3.jpg")
+	expectContent("3.pdf", "1.pdf")
+	expectContent("3-replaced.pdf", "3.pdf")
+	ExpectTrue(t, !fileExists(dir+"/2.jpg"), "Moved away")
+
+	// Undone, e.g. because the records could not be changed.
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	expectContent("2.jpg", "1.jpg")
+	expectContent("2/1.jpg", "1/1.jpg")
+	expectContent("2.pdf", "1.pdf")
+	expectContent("3.jpg", "3.jpg")
+	expectContent("3.pdf", "3.pdf")
+	ExpectTrue(t, !fileExists(dir+"/3-replaced.jpg"), "Nothing left over")
+
+	// Replaced again: the first replaced image is kept as well.
+	writeImage("3-replaced.jpg")
+	if _, err := renumberComponentImages(dir, map[int]int{2: 3}); err != nil {
+		t.Fatal(err)
+	}
+	expectContent("3-replaced.jpg", "3-replaced.jpg")
+	expectContent("3-replaced-2.jpg", "3.jpg")
+	if _, err := renumberComponentImages(dir, map[int]int{3: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing half-way undoes what was renamed so far.
+	os.Mkdir(dir+"/2.pdf.moving", 0755) // In the way of the datasheet.
+	_, err = renumberComponentImages(dir, map[int]int{2: 4})
+	ExpectTrue(t, err != nil, "Failed")
+	expectContent("2.jpg", "1.jpg")
+	expectContent("2/1.jpg", "1/1.jpg")
+	expectContent("2.pdf", "1.pdf")
+	ExpectTrue(t, !fileExists(dir+"/2.jpg.moving") && !fileExists(dir+"/4.jpg"), "Renamed back")
+}
@@ -21,7 +21,22 @@ import (
 type SearchResult struct {
 	OrignialQuery  string
 	RewrittenQuery string
+	Suggestion     string       // Query with misspelled words corrected, if any.
+	Errors         []QueryError // Syntax errors, the query is evaluated anyway.
 	Results        []*Component
+	Total          int            // Number of all results, Results might only be the first.
+	Substitutes    map[int]string // Component ID -> part it substitutes.
+
+	matcher     func(c *Component) []TermMatch
+	corrections map[string]string // Misspelled word -> correction.
+}
+
+// Where the terms of the query matched the component, nil if not known.
+func (r *SearchResult) Matches(c *Component) []TermMatch {
+	if r.matcher == nil {
+		return nil
+	}
+	return r.matcher(c)
 }
 
 var wantTimings = flag.Bool("want-timings", false, "Print processing timings.")
@@ -32,7 +47,7 @@ func ElapsedPrint(msg string, start time.Time) {
 	}
 }
 
-func parseAllowedEditorCIDR(allowed string) []*net.IPNet {
+func parseAllowedEditorCIDR(flag_name string, allowed string) []*net.IPNet {
 	all_allowed := strings.Split(allowed, ",")
 	allowed_nets := make([]*net.IPNet, 0, len(all_allowed))
 	for i := 0; i < len(all_allowed); i++ {
@@ -41,7 +56,7 @@ func parseAllowedEditorCIDR(allowed string) []*net.IPNet {
 		}
 		_, net, err := net.ParseCIDR(all_allowed[i])
 		if err != nil {
-			log.Fatal("--edit-permission-nets: Need IP/Network format: ", err)
+			log.Fatalf("--%s: Need IP/Network format: %v", flag_name, err)
 		} else {
 			allowed_nets = append(allowed_nets, net)
 		}
@@ -58,16 +73,25 @@ func main() {
 		"Directory with static resources")
 	bindAddress := flag.String("bind-address", ":2000", "Listen address:port to serve from")
 	dbFile := flag.String("dbfile", "stuff-database.db", "SQLite database file")
+	searchIndex := flag.String("search-index", "", "File to persist the search index in for faster startup. Optional.")
+	synonymFile := flag.String("synonyms", "synonyms.txt", "File with synonyms for the search, reloaded when changed. Empty: none.")
+	rankingSpec := flag.String("ranking-weights", "", "How much availability changes the search rank, e.g. 'stock=0.2,status=0.5,image=0.1,complete=0.1'. Each 0..1, unset ones default to these.")
 	logfile := flag.String("logfile", "", "Logfile to write interesting events")
 	do_cleanup := flag.Bool("cleanup-db", false, "Cleanup run of database")
 	permitted_nets := flag.String("edit-permission-nets", "", "Comma separated list of networks (CIDR format IP-Addr/network) that are allowed to edit content")
+	proxy_nets := flag.String("trusted-proxies", "127.0.0.1/32,::1/128", "Comma separated list of networks (CIDR format) of reverse proxies whose X-Forwarded-For header is trusted for search statistics")
 	site_name := flag.String("site-name", "", "Site-name, in particular needed for SSL")
 	ssl_key := flag.String("ssl-key", "", "Key file")
 	ssl_cert := flag.String("ssl-cert", "", "Cert file")
 
 	flag.Parse()
 
-	edit_nets := parseAllowedEditorCIDR(*permitted_nets)
+	edit_nets := parseAllowedEditorCIDR("edit-permission-nets", *permitted_nets)
+	trustedProxies = parseAllowedEditorCIDR("trusted-proxies", *proxy_nets)
+	ranking, err := parseRankingWeights(*rankingSpec)
+	if err != nil {
+		log.Fatal("--ranking-weights: ", err)
+	}
 
 	if *logfile != "" {
 		f, err := os.OpenFile(*logfile,
@@ -93,7 +117,7 @@ func main() {
 	}
 
 	var store StuffStore
-	store, err = NewSqlStuffStore(db, is_dbfilenew)
+	store, err = NewSqlStuffStoreWithSearchIndex(db, is_dbfilenew, *searchIndex)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -120,10 +144,28 @@ func main() {
 
 	templates := NewTemplateRenderer(*templateDir, *cacheTemplates)
 	imagehandler := AddImageHandler(store, templates, *imageDir, *staticResource)
+	store.SetImageChecker(imagehandler.hasPhoto)
+	store.SetRankingWeights(ranking)
+	synonyms := NewSynonymDictionary(*synonymFile)
+	store.SetSynonyms(synonyms)
+	if *synonymFile != "" {
+		go synonyms.Watch(10 * time.Second)
+	}
 	AddFormHandler(store, templates, *imageDir, edit_nets)
-	AddSearchHandler(store, templates, imagehandler)
+	analytics := NewSearchAnalytics(store)
+	go analytics.Run()
+	AddSearchHandler(store, templates, imagehandler, analytics)
 	AddStatusHandler(store, templates, *imageDir)
+	AddRestockHandler(store, templates, edit_nets)
+	AddDuplicatesHandler(store, templates, *imageDir, edit_nets)
 	AddSitemapHandler(store, *site_name)
+	AddRecentHandler(store, templates, *site_name)
+	AddSetHandler(store, templates, edit_nets)
+	AddSynonymsHandler(synonyms, templates, edit_nets)
+	AddSearchStatsHandler(store, templates, edit_nets)
+	AddSubstitutesHandler(store, templates, edit_nets)
+	datasheets := AddDatasheetHandler(store, *imageDir, edit_nets)
+	go datasheets.IndexMissing()
 	http.Handle("/metrics", promhttp.Handler())
 
 	log.Printf("Listening on %q", *bindAddress)
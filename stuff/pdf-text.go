@@ -0,0 +1,598 @@
+// Text extraction from PDF files, good enough to index datasheets.
+//
+// This is not a complete PDF reader: it goes through all objects, inflates
+// the streams, and collects the strings shown by the text operators of the
+// content streams. Fonts with a ToUnicode map are decoded with it, others
+// are assumed to be (roughly) Latin-1. Encrypted files and text in images
+// are not found; the text might come out of order if the file has its
+// pages in a different order than the objects.
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// Longer text is cut; the first pages are what matters anyway.
+	kMaxPdfText = 512 << 10
+
+	// Limit of the inflated size of all streams of a file, so that a
+	// compression bomb can't use up the memory.
+	kMaxPdfInflated = 256 << 20
+)
+
+var (
+	pdfObjectStart = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfReference   = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s*(\d+)\s+\d+\s+R`)
+	pdfFontDict    = regexp.MustCompile(`/Font\s*(<<[^>]*>>|\d+\s+\d+\s+R)`)
+	pdfToUnicode   = regexp.MustCompile(`/ToUnicode\s*(\d+)\s+\d+\s+R`)
+	pdfIntValue    = regexp.MustCompile(`/(N|First)\s+(\d+)`)
+	pdfFilter      = regexp.MustCompile(`/(\w+Decode)\b`)
+)
+
+type pdfObject struct {
+	dict   []byte // Everything but the stream.
+	stream []byte // Inflated stream, nil if none or not decodable.
+}
+
+// Maps character codes of a font to text.
+type pdfCMap struct {
+	codeLen int // Bytes per character code.
+	chars   map[string]string
+}
+
+// All the objects of the file by number, including the ones inside object
+// streams.
+func parsePdfObjects(data []byte) map[int]*pdfObject {
+	result := make(map[int]*pdfObject)
+	budget := kMaxPdfInflated
+	starts := pdfObjectStart.FindAllSubmatchIndex(data, -1)
+	for i, start := range starts {
+		number, _ := strconv.Atoi(string(data[start[2]:start[3]]))
+		end := len(data)
+		if i+1 < len(starts) {
+			end = starts[i+1][0]
+		}
+		body := data[start[1]:end]
+		if pos := bytes.Index(body, []byte("endobj")); pos >= 0 {
+			body = body[:pos]
+		}
+		object := &pdfObject{dict: body}
+		if pos := bytes.Index(body, []byte("stream")); pos >= 0 {
+			object.dict = body[:pos]
+			raw := body[pos+len("stream"):]
+			raw = bytes.TrimPrefix(bytes.TrimPrefix(raw, []byte("\r")), []byte("\n"))
+			if end := bytes.LastIndex(raw, []byte("endstream")); end >= 0 {
+				raw = raw[:end]
+			}
+			object.stream = decodePdfStream(object.dict, raw, &budget)
+		}
+		result[number] = object
+	}
+	// Objects can be compressed inside object streams.
+	for _, object := range result {
+		if object.stream == nil || !bytes.Contains(object.dict, []byte("/ObjStm")) {
+			continue
+		}
+		for number, dict := range objectStreamObjects(object) {
+			if _, found := result[number]; !found {
+				result[number] = &pdfObject{dict: dict}
+			}
+		}
+	}
+	return result
+}
+
+// Inflate the stream. Nil if it is compressed in some other way, such as
+// an image, or if the budget of inflated bytes of the file is used up.
+func decodePdfStream(dict []byte, raw []byte, budget *int) []byte {
+	filters := 0
+	for _, filter := range pdfFilter.FindAllSubmatch(dict, -1) {
+		if string(filter[1]) != "FlateDecode" {
+			return nil
+		}
+		filters++
+	}
+	if filters == 0 {
+		return raw
+	}
+	if *budget <= 0 {
+		return nil
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+	// Truncated streams are common enough, keep what we got.
+	result, _ := io.ReadAll(io.LimitReader(reader, int64(*budget)))
+	*budget -= len(result)
+	return result
+}
+
+// The objects in an object stream: a header with pairs of object number
+// and offset, then the objects.
+func objectStreamObjects(stream *pdfObject) map[int][]byte {
+	result := make(map[int][]byte)
+	values := make(map[string]int)
+	for _, match := range pdfIntValue.FindAllSubmatch(stream.dict, -1) {
+		values[string(match[1])], _ = strconv.Atoi(string(match[2]))
+	}
+	first := values["First"]
+	if first <= 0 || first > len(stream.stream) {
+		return result
+	}
+	header := strings.Fields(string(stream.stream[:first]))
+	for i := 0; i+1 < len(header) && i/2 < values["N"]; i += 2 {
+		number, err1 := strconv.Atoi(header[i])
+		offset, err2 := strconv.Atoi(header[i+1])
+		if err1 != nil || err2 != nil || offset < 0 || first+offset > len(stream.stream) {
+			break
+		}
+		end := len(stream.stream)
+		if i+3 < len(header) {
+			if next, err := strconv.Atoi(header[i+3]); err == nil && next >= offset && first+next <= end {
+				end = first + next
+			}
+		}
+		result[number] = stream.stream[first+offset : end]
+	}
+	return result
+}
+
+// Parse the bfchar and bfrange sections of a ToUnicode CMap.
+func parsePdfCMap(data []byte) *pdfCMap {
+	result := &pdfCMap{codeLen: 1, chars: make(map[string]string)}
+	tokens := newPdfLexer(data)
+	var operands []pdfToken
+	for {
+		token, ok := tokens.next()
+		if !ok {
+			break
+		}
+		if token.kind != kPdfOperator {
+			operands = append(operands, token)
+			continue
+		}
+		switch token.text {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				result.add(operands[i].text, decodeUTF16(operands[i+1].text))
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, high := operands[i].text, operands[i+1].text
+				if len(low) != len(high) || len(low) == 0 || len(low) > 4 {
+					continue
+				}
+				from, to := codeNumber(low), codeNumber(high)
+				for code := from; code <= to && code-from < 0x10000; code++ {
+					var target string
+					if operands[i+2].kind == kPdfArray {
+						if int(code-from) >= len(operands[i+2].array) {
+							break
+						}
+						target = decodeUTF16(operands[i+2].array[code-from])
+					} else {
+						// The last character counts up.
+						target = incrementUTF16(operands[i+2].text, int(code-from))
+					}
+					result.add(codeBytes(code, len(low)), target)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	return result
+}
+
+func (m *pdfCMap) add(code string, text string) {
+	if len(code) > m.codeLen {
+		m.codeLen = len(code)
+	}
+	m.chars[code] = text
+}
+
+func (m *pdfCMap) decode(s string) string {
+	var result strings.Builder
+	for i := 0; i+m.codeLen <= len(s); i += m.codeLen {
+		result.WriteString(m.chars[s[i:i+m.codeLen]])
+	}
+	return result.String()
+}
+
+func codeNumber(code string) uint32 {
+	var result uint32
+	for i := 0; i < len(code); i++ {
+		result = result<<8 | uint32(code[i])
+	}
+	return result
+}
+
+func codeBytes(code uint32, length int) string {
+	result := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		result[i] = byte(code)
+		code >>= 8
+	}
+	return string(result)
+}
+
+func decodeUTF16(s string) string {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+func incrementUTF16(s string, delta int) string {
+	if len(s) < 2 {
+		return ""
+	}
+	last := int(s[len(s)-2])<<8 | int(s[len(s)-1])
+	last += delta
+	return decodeUTF16(s[:len(s)-2] + string([]byte{byte(last >> 8), byte(last)}))
+}
+
+// Text of a string without known encoding: printable Latin-1.
+func decodeLatin1(s string) string {
+	var result strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] >= ' ' || s[i] == '\t' || s[i] == '\n' {
+			result.WriteRune(rune(s[i]))
+		}
+	}
+	return result.String()
+}
+
+// The ToUnicode maps of the fonts by their resource name, e.g. "F1". Names
+// are only unique per page; if the same name is used for different fonts,
+// one of them wins.
+func pdfFontMaps(objects map[int]*pdfObject) map[string]*pdfCMap {
+	cmaps := make(map[int]*pdfCMap) // By font object.
+	for number, object := range objects {
+		if match := pdfToUnicode.FindSubmatch(object.dict); match != nil {
+			cmap_object, _ := strconv.Atoi(string(match[1]))
+			if o := objects[cmap_object]; o != nil && o.stream != nil {
+				cmaps[number] = parsePdfCMap(o.stream)
+			}
+		}
+	}
+	result := make(map[string]*pdfCMap)
+	for _, object := range objects {
+		for _, match := range pdfFontDict.FindAllSubmatch(object.dict, -1) {
+			fonts := match[1]
+			if !bytes.HasPrefix(fonts, []byte("<<")) {
+				var number int
+				fmt.Sscanf(string(fonts), "%d", &number)
+				if objects[number] == nil {
+					continue
+				}
+				fonts = objects[number].dict
+			}
+			for _, ref := range pdfReference.FindAllSubmatch(fonts, -1) {
+				number, _ := strconv.Atoi(string(ref[2]))
+				if cmap := cmaps[number]; cmap != nil {
+					result[string(ref[1])] = cmap
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Append the text shown in the content stream.
+func appendPdfContentText(out *strings.Builder, content []byte, fonts map[string]*pdfCMap) {
+	var font *pdfCMap
+	decode := func(s string) string {
+		if font != nil {
+			return font.decode(s)
+		}
+		return decodeLatin1(s)
+	}
+	tokens := newPdfLexer(content)
+	var operands []pdfToken
+	for out.Len() < kMaxPdfText {
+		token, ok := tokens.next()
+		if !ok {
+			break
+		}
+		if token.kind != kPdfOperator {
+			operands = append(operands, token)
+			continue
+		}
+		switch token.text {
+		case "Tf":
+			if len(operands) >= 2 {
+				font = fonts[operands[len(operands)-2].text]
+			}
+		case "Tj", "'", "\"":
+			if len(operands) > 0 && operands[len(operands)-1].kind == kPdfString {
+				if token.text != "Tj" {
+					out.WriteByte('\n')
+				}
+				out.WriteString(decode(operands[len(operands)-1].text))
+			}
+		case "TJ":
+			if len(operands) > 0 && operands[len(operands)-1].kind == kPdfArray {
+				for _, element := range operands[len(operands)-1].array {
+					if element == kPdfWordGap {
+						out.WriteByte(' ')
+					} else {
+						out.WriteString(decode(element))
+					}
+				}
+			}
+		case "Td", "TD", "T*", "Tm", "ET":
+			out.WriteByte('\n')
+		case "ID":
+			tokens.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+}
+
+// Extract the text of a PDF file. Broken files that the parsing does not
+// expect are reported as an error rather than a panic.
+func extractPdfText(data []byte) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("broken PDF: %v", r)
+		}
+	}()
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("%PDF-")) {
+		return "", fmt.Errorf("not a PDF file")
+	}
+	objects := parsePdfObjects(data)
+	for _, object := range objects {
+		if bytes.Contains(object.dict, []byte("/Encrypt")) {
+			return "", fmt.Errorf("encrypted PDF")
+		}
+	}
+	fonts := pdfFontMaps(objects)
+	numbers := make([]int, 0, len(objects))
+	for number := range objects {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	var out strings.Builder
+	for _, number := range numbers {
+		object := objects[number]
+		if object.stream == nil || isPdfNonContentStream(object) {
+			continue
+		}
+		appendPdfContentText(&out, object.stream, fonts)
+		out.WriteByte('\n')
+	}
+	text = strings.Join(strings.Fields(out.String()), " ")
+	if len(text) > kMaxPdfText {
+		text = strings.ToValidUTF8(text[:kMaxPdfText], "")
+	}
+	return text, nil
+}
+
+// Streams that certainly don't contain page content.
+func isPdfNonContentStream(object *pdfObject) bool {
+	for _, marker := range []string{"/ObjStm", "/XRef", "/Image", "/Metadata",
+		"/Length1", "/Length2", "/FontFile", "/Type1C", "/CIDFontType0C", "/OpenType"} {
+		if bytes.Contains(object.dict, []byte(marker)) {
+			return true
+		}
+	}
+	return bytes.Contains(object.stream, []byte("begincmap"))
+}
+
+// Tokens of content streams and CMaps.
+const (
+	kPdfOperator = iota
+	kPdfString   // Literal or hex string, decoded to its bytes.
+	kPdfArray    // Only the strings in it are kept, and kPdfWordGap.
+	kPdfOther    // Number, name (without /) or dictionary delimiter.
+)
+
+// In arrays of strings: a gap wide enough to be a space.
+const kPdfWordGap = "\x00"
+
+type pdfToken struct {
+	kind  int
+	text  string
+	array []string
+}
+
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func newPdfLexer(data []byte) *pdfLexer {
+	return &pdfLexer{data: data}
+}
+
+func isPdfDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func isPdfSpace(c byte) bool {
+	return strings.IndexByte(" \t\r\n\f\x00", c) >= 0
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPdfSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return pdfToken{kind: kPdfString, text: l.literalString()}, true
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<',
+			c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+			l.pos += 2
+			return pdfToken{kind: kPdfOther, text: string([]byte{c, c})}, true
+		case c == '<':
+			return pdfToken{kind: kPdfString, text: l.hexString()}, true
+		case c == '[':
+			l.pos++
+			return pdfToken{kind: kPdfArray, array: l.array()}, true
+		case c == '/':
+			l.pos++
+			return pdfToken{kind: kPdfOther, text: l.word()}, true
+		case isPdfDelimiter(c):
+			l.pos++ // Stray ] or the like.
+		default:
+			word := l.word()
+			if word == "" {
+				l.pos++
+				continue
+			}
+			if _, err := strconv.ParseFloat(word, 64); err == nil {
+				return pdfToken{kind: kPdfOther, text: word}, true
+			}
+			return pdfToken{kind: kPdfOperator, text: word}, true
+		}
+	}
+	return pdfToken{}, false
+}
+
+func (l *pdfLexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPdfSpace(l.data[l.pos]) && !isPdfDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// Strings in an array, up to the closing bracket. Large negative offsets
+// between them are word gaps.
+func (l *pdfLexer) array() []string {
+	result := make([]string, 0)
+	for {
+		for l.pos < len(l.data) && isPdfSpace(l.data[l.pos]) {
+			l.pos++
+		}
+		if l.pos < len(l.data) && l.data[l.pos] == ']' {
+			l.pos++
+			return result
+		}
+		token, ok := l.next()
+		if !ok {
+			return result
+		}
+		switch token.kind {
+		case kPdfString:
+			result = append(result, token.text)
+		case kPdfArray:
+			result = append(result, token.array...)
+		case kPdfOther:
+			if offset, err := strconv.ParseFloat(token.text, 64); err == nil && offset < -200 {
+				result = append(result, kPdfWordGap)
+			}
+		}
+	}
+}
+
+// Skip the binary data of an inline image, up to EI.
+func (l *pdfLexer) skipInlineImage() {
+	end := bytes.Index(l.data[l.pos:], []byte("EI"))
+	for end >= 0 {
+		at := l.pos + end
+		if isPdfSpace(l.data[at-1]) && (at+2 == len(l.data) || isPdfSpace(l.data[at+2])) {
+			l.pos = at + 2
+			return
+		}
+		next := bytes.Index(l.data[at+2:], []byte("EI"))
+		if next < 0 {
+			break
+		}
+		end += 2 + next
+	}
+	l.pos = len(l.data)
+}
+
+func (l *pdfLexer) literalString() string {
+	var result []byte
+	depth := 0
+	l.pos++ // Opening (
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return string(result)
+			}
+			depth--
+		case '\\':
+			if l.pos >= len(l.data) {
+				return string(result)
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				if c == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue // Line continuation.
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) &&
+						l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(value)
+				}
+			}
+		}
+		result = append(result, c)
+	}
+	return string(result)
+}
+
+func (l *pdfLexer) hexString() string {
+	var digits []byte
+	l.pos++ // Opening <
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		c := l.data[l.pos]
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++ // Closing >
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	result := make([]byte, len(digits)/2)
+	for i := range result {
+		value, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		result[i] = byte(value)
+	}
+	return string(result)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// A minimal PDF with the given objects; streams are stored as given.
+func makeTestPdf(objects ...string) []byte {
+	var result bytes.Buffer
+	result.WriteString("%PDF-1.5\n")
+	for i, object := range objects {
+		fmt.Fprintf(&result, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	result.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return result.Bytes()
+}
+
+func pdfStream(dict string, content string, compress bool) string {
+	if compress {
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		w.Write([]byte(content))
+		w.Close()
+		content = compressed.String()
+		dict += " /Filter /FlateDecode"
+	}
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(content), content)
+}
+
+func TestExtractPdfText(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar
+<0001> <0056>
+<0002> <0020>
+endbfchar
+1 beginbfrange
+<0003> <0005> <0061>
+endbfrange
+endcmap`
+	pdf := makeTestPdf(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Page /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents [6 0 R 7 0 R] >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Type /Font /Subtype /Type0 /ToUnicode 5 0 R >>",
+		pdfStream("", cmap, true),
+		pdfStream("", `BT /F1 12 Tf 72 712 Td (Rail-to-rail \(RRIO\) output) Tj
+T* [(Op)-50(amp)-300(LM\063\0658)] TJ ET`, false),
+		pdfStream("", "BT /F2 10 Tf <0001000200030004 0005> Tj ET", true),
+		pdfStream("/Subtype /Image /Filter /DCTDecode", "(Not text) Tj", false),
+	)
+	text, err := extractPdfText(pdf)
+	ExpectTrue(t, err == nil, "Extracted")
+	expectEqual(t, text, "Rail-to-rail (RRIO) output Opamp LM358 V abc")
+
+	_, err = extractPdfText([]byte("<html>"))
+	ExpectTrue(t, err != nil, "Not a PDF")
+
+	// Text beyond the limit is cut.
+	long := makeTestPdf(pdfStream("", "BT ("+strings.Repeat("x ", kMaxPdfText)+") Tj ET", true))
+	text, _ = extractPdfText(long)
+	ExpectTrue(t, len(text) == kMaxPdfText, "Limited")
+}
+
+func TestObjectStreams(t *testing.T) {
+	// The font is only in the compressed object stream.
+	objects := "5 0 << /Type /Font /ToUnicode 3 0 R >>"
+	pdf := makeTestPdf(
+		pdfStream("/Type /ObjStm /N 1 /First 4", objects, true),
+		"<< /Type /Page /Resources << /Font << /F9 5 0 R >> >> /Contents 4 0 R >>",
+		pdfStream("", "begincmap 1 beginbfchar <01> <0041> endbfchar endcmap", true),
+		pdfStream("", "BT /F9 10 Tf <01> Tj ET", false),
+	)
+	text, err := extractPdfText(pdf)
+	ExpectTrue(t, err == nil, "Extracted")
+	expectEqual(t, text, "A")
+}
+
+func TestBrokenPdf(t *testing.T) {
+	// Offsets in the object stream header pointing before the objects.
+	for _, header := range []string{"5 -50", "5 0 6 -2"} {
+		pdf := makeTestPdf(pdfStream("/Type /ObjStm /N 2 /First 9", header+"    << >>", false))
+		_, err := extractPdfText(pdf)
+		ExpectTrue(t, err == nil, header+": no panic")
+	}
+
+	// The inflated size of all streams together is limited.
+	bomb := pdfStream("", strings.Repeat("\x00", 1000), true)
+	dict := []byte("<< /Filter /FlateDecode >>")
+	raw := []byte(bomb[strings.Index(bomb, "stream\n")+7 : strings.LastIndex(bomb, "\nendstream")])
+	budget := 1500
+	ExpectTrue(t, len(decodePdfStream(dict, raw, &budget)) == 1000, "First inflated")
+	ExpectTrue(t, len(decodePdfStream(dict, raw, &budget)) == 500, "Second cut")
+	ExpectTrue(t, decodePdfStream(dict, raw, &budget) == nil, "Budget used up")
+}
@@ -0,0 +1,180 @@
+// Recently created or changed components: as page and as Atom feed.
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kRecentPage = "/recent"
+	kRecentFeed = "/recent.atom"
+
+	kRecentDefaultLimit = 50
+	kRecentMaxLimit     = 500
+)
+
+type RecentHandler struct {
+	store      StuffStore
+	template   *TemplateRenderer
+	siteprefix string
+}
+
+func AddRecentHandler(store StuffStore, template *TemplateRenderer, siteprefix string) {
+	handler := &RecentHandler{
+		store:      store,
+		template:   template,
+		siteprefix: siteprefix,
+	}
+	http.Handle(kRecentPage, handler)
+	http.Handle(kRecentFeed, handler)
+}
+
+type RecentItem struct {
+	*Component
+	IsNew bool   // Created, not changed since.
+	Age   string // Human readable time since last change.
+}
+
+type RecentPage struct {
+	Items []RecentItem
+}
+
+// Human readable approximation of how long ago the given time was,
+// e.g. "3 days ago".
+func humanizeAge(t time.Time, now time.Time) string {
+	plural := func(count int, unit string) string {
+		if count == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", count, unit)
+	}
+	age := now.Sub(t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return plural(int(age/time.Minute), "minute")
+	case age < 24*time.Hour:
+		return plural(int(age/time.Hour), "hour")
+	case age < 30*24*time.Hour:
+		return plural(int(age/(24*time.Hour)), "day")
+	case age < 365*24*time.Hour:
+		return plural(int(age/(30*24*time.Hour)), "month")
+	default:
+		return plural(int(age/(365*24*time.Hour)), "year")
+	}
+}
+
+// A component counts as new if it has not been changed after it was created
+// (within a little slack, as both timestamps are not set at the same time in
+// older records).
+func isNewComponent(c *Component) bool {
+	if c.Created == nil || c.Updated == nil {
+		return c.Created != nil
+	}
+	return c.Updated.Sub(*c.Created) < time.Second
+}
+
+func (h *RecentHandler) recentItems(r *http.Request) []RecentItem {
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		limit = kRecentDefaultLimit
+	}
+	if limit > kRecentMaxLimit {
+		limit = kRecentMaxLimit
+	}
+	now := time.Now()
+	components := h.store.RecentlyChanged(limit)
+	result := make([]RecentItem, len(components))
+	for i, c := range components {
+		result[i] = RecentItem{
+			Component: c,
+			IsNew:     isNewComponent(c),
+			Age:       humanizeAge(*lastChange(c), now),
+		}
+	}
+	return result
+}
+
+func (h *RecentHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+	defer ElapsedPrint("Recent", time.Now())
+	if strings.HasPrefix(req.URL.Path, kRecentFeed) {
+		h.recentFeed(out, req)
+	} else {
+		out.Header().Set("Cache-Control", "max-age=10")
+		h.template.Render(out, "recent.html", &RecentPage{
+			Items: h.recentItems(req),
+		})
+	}
+}
+
+// Minimal subset of Atom (RFC 4287) we need.
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	Id      string   `xml:"id"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+	Summary string   `xml:"summary"`
+}
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Link    []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func (h *RecentHandler) recentFeed(out http.ResponseWriter, r *http.Request) {
+	out.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	out.Header().Set("Cache-Control", "max-age=60")
+	items := h.recentItems(r)
+	feed := &atomFeed{
+		Title: "Recently catalogued components",
+		Id:    h.siteprefix + kRecentFeed,
+		Link: []atomLink{
+			{Href: h.siteprefix + kRecentFeed, Rel: "self"},
+			{Href: h.siteprefix + kRecentPage},
+		},
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  "stuff-org",
+		Entries: make([]atomEntry, len(items)),
+	}
+	if len(items) > 0 {
+		feed.Updated = lastChange(items[0].Component).UTC().Format(time.RFC3339)
+	}
+	for i, item := range items {
+		action := "Changed"
+		if item.IsNew {
+			action = "New"
+		}
+		title := fmt.Sprintf("%s: %s %s (ID:%d)", action,
+			item.Category, item.Value, item.Id)
+		link := fmt.Sprintf("%s/form?id=%d", h.siteprefix, item.Id)
+		updated := lastChange(item.Component).UTC()
+		feed.Entries[i] = atomEntry{
+			Title: strings.Join(strings.Fields(title), " "),
+			// Each change is its own entry.
+			Id:      fmt.Sprintf("%s#%d", link, updated.Unix()),
+			Link:    atomLink{Href: link},
+			Updated: updated.Format(time.RFC3339),
+			Summary: item.Description,
+		}
+	}
+	out.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		out.Write([]byte(err.Error()))
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHumanizeAge(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectEqual(t, humanizeAge(now.Add(-10*time.Second), now), "just now")
+	expectEqual(t, humanizeAge(now.Add(-1*time.Minute), now), "1 minute ago")
+	expectEqual(t, humanizeAge(now.Add(-5*time.Hour), now), "5 hours ago")
+	expectEqual(t, humanizeAge(now.Add(-3*24*time.Hour), now), "3 days ago")
+	expectEqual(t, humanizeAge(now.Add(-65*24*time.Hour), now), "2 months ago")
+	expectEqual(t, humanizeAge(now.Add(-800*24*time.Hour), now), "2 years ago")
+}
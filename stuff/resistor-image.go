@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"net/http"
 	"regexp"
 	"time"
@@ -75,70 +76,36 @@ func toleranceFromString(tolerance_string string, default_value int) int {
 // on precision.
 // Returns nil on error.
 func extractResistorDigits(value string, tolerance string) []int {
-	if len(value) == 0 {
+	parsed, ok := parseValue(value)
+	if !ok || (parsed.unit != "" && parsed.unit != "ohm") {
 		return nil
 	}
-
-	exp := 0
-	dot_seen := false
-	post_dot_digits := 0
-	zero_prefix := true
-	var digits [3]int
-	digit_pos := 0
-	for _, c := range value {
-		if c == '0' && zero_prefix {
-			continue // eat leading zeroes
-		}
-		zero_prefix = false
-		switch {
-		case c >= '0' && c <= '9':
-			if dot_seen {
-				post_dot_digits++
-			} else {
-				exp++
-			}
-			if digit_pos < len(digits) {
-				digits[digit_pos] = int(c - '0')
-				digit_pos++
-			}
-		case c == '.':
-			if dot_seen { // uh, multiple dots ?
-				return nil
-			}
-			dot_seen = true
-		case c == 'k' || c == 'K':
-			exp = exp + 3
-		case c == 'M':
-			exp = exp + 6
-		default:
-			return nil // invalid character.
-		}
+	if parsed.number == 0 {
+		return []int{0, 0, 0, toleranceFromString(tolerance, 10)}
 	}
 
-	// See how many relevant digits we have. Zeroes at end don't count
-	relevant_digits := 0
-	for relevant_digits = len(digits); relevant_digits > 0 && digits[relevant_digits-1] == 0; relevant_digits-- {
-		//
+	// Values with up to two significant digits get 4 bands, more
+	// precise ones 5 bands.
+	bands := 2
+	if parsed.digits > 2 {
+		bands = 3
 	}
-	if relevant_digits < 2 { // Not 100% accurate, but good enough for now
-		relevant_digits = relevant_digits + post_dot_digits
+	exp := int(math.Floor(math.Log10(parsed.number)+1e-9)) - bands + 1
+	significant := int(math.Round(parsed.number / math.Pow10(exp)))
+	if significant >= int(math.Pow10(bands)) { // Rounded up, e.g. 9.99 -> 10
+		significant /= 10
+		exp++
 	}
-	var result []int
-	var multiplier_digit int
-	if relevant_digits <= 2 {
-		multiplier_digit = expToIndex(exp - 2)
-		result = []int{digits[0], digits[1], multiplier_digit,
-			toleranceFromString(tolerance /*default 5%:*/, 10)}
-	} else {
-		multiplier_digit = expToIndex(exp - 3)
-		result = []int{digits[0], digits[1], digits[2], multiplier_digit,
-			toleranceFromString(tolerance /*default 1%:*/, 1)}
-	}
-	if multiplier_digit >= 0 {
-		return result
-	} else {
+	multiplier_digit := expToIndex(exp)
+	if multiplier_digit < 0 {
 		return nil
 	}
+	if bands == 2 {
+		return []int{significant / 10, significant % 10, multiplier_digit,
+			toleranceFromString(tolerance /*default 5%:*/, 10)}
+	}
+	return []int{significant / 100, significant / 10 % 10, significant % 10,
+		multiplier_digit, toleranceFromString(tolerance /*default 1%:*/, 1)}
 }
 
 var tolerance_regexp, _ = regexp.Compile(`((0?.)?\d+\%)`)
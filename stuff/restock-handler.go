@@ -0,0 +1,222 @@
+// Restock list: everything that dropped below its minimum stock level.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kRestockPage = "/restock"
+	kRestockCsv  = "/restock.csv"
+	kApiRestock  = "/api/restock"
+)
+
+var quantityNumber = regexp.MustCompile(`\d+`)
+
+type RestockHandler struct {
+	store    StuffStore
+	template *TemplateRenderer
+	editNets []*net.IPNet
+}
+
+func AddRestockHandler(store StuffStore, template *TemplateRenderer, editNets []*net.IPNet) {
+	handler := &RestockHandler{
+		store:    store,
+		template: template,
+		editNets: editNets,
+	}
+	http.Handle(kRestockPage, handler)
+	http.Handle(kRestockCsv, handler)
+	http.Handle(kApiRestock, handler)
+}
+
+type JsonRestockItem struct {
+	Id            int    `json:"id"`
+	Category      string `json:"category"`
+	Value         string `json:"value"`
+	Description   string `json:"description"`
+	Footprint     string `json:"footprint,omitempty"`
+	Quantity      string `json:"quantity"`
+	Available     int    `json:"available"` // Quantity as parsed number
+	Min_stock     int    `json:"min_stock"`
+	Missing       int    `json:"missing"` // Needed to get back to Min_stock
+	Datasheet_url string `json:"datasheet_url,omitempty"`
+	Link          string `json:"link"`
+}
+
+type JsonRestockVendor struct {
+	Vendor string            `json:"vendor"` // Empty if not known.
+	Items  []JsonRestockItem `json:"items"`
+}
+
+type JsonApiRestockResult struct {
+	Directlink string              `json:"link"`
+	Count      int                 `json:"count"`
+	Vendors    []JsonRestockVendor `json:"vendors"`
+}
+
+type RestockPage struct {
+	Count           int
+	Vendors         []JsonRestockVendor
+	DefaultMinStock map[string]int
+	Categories      []string
+	EditAllowed     bool
+	Msg             string
+}
+
+// Quantity is a free-form string, such as '< 50', '~100' or '20-ish'.
+// Extract the first number we find. Returns false if there is none.
+func parseQuantity(quantity string) (int, bool) {
+	// Thousands separators, e.g. 1,000
+	quantity = strings.Replace(quantity, ",", "", -1)
+	match := quantityNumber.FindString(quantity)
+	if match == "" {
+		return 0, false
+	}
+	result, err := strconv.Atoi(match)
+	if err != nil {
+		return 0, false
+	}
+	return result, true
+}
+
+// Returns the minimum stock for the component: its own setting, otherwise
+// the default for its category. Zero if there is none.
+func minStockFor(c *Component, category_defaults map[string]int) int {
+	if c.Min_stock > 0 {
+		return c.Min_stock
+	}
+	return category_defaults[c.Category]
+}
+
+// Go through all the components and collect the ones that are below their
+// minimum stock level, grouped by vendor. Vendors are sorted alphabetically,
+// with the unknown vendor last.
+func collectRestockList(store StuffStore) []JsonRestockVendor {
+	category_defaults := store.CategoryMinStock()
+	by_vendor := make(map[string][]JsonRestockItem)
+	store.IterateAll(func(c *Component) bool {
+		min_stock := minStockFor(c, category_defaults)
+		if min_stock <= 0 {
+			return true
+		}
+		if strings.Contains(strings.ToLower(c.Value), "empty") {
+			return true // Bin is not in use.
+		}
+		available, ok := parseQuantity(c.Quantity)
+		if !ok || available >= min_stock {
+			return true
+		}
+		by_vendor[c.Vendor] = append(by_vendor[c.Vendor], JsonRestockItem{
+			Id:            c.Id,
+			Category:      c.Category,
+			Value:         c.Value,
+			Description:   c.Description,
+			Footprint:     c.Footprint,
+			Quantity:      c.Quantity,
+			Available:     available,
+			Min_stock:     min_stock,
+			Missing:       min_stock - available,
+			Datasheet_url: c.Datasheet_url,
+			Link:          fmt.Sprintf("/form?id=%d", c.Id),
+		})
+		return true
+	})
+	result := make([]JsonRestockVendor, 0, len(by_vendor))
+	for vendor, items := range by_vendor {
+		result = append(result, JsonRestockVendor{
+			Vendor: vendor,
+			Items:  items,
+		})
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Vendor == "" || result[b].Vendor == "" {
+			return result[b].Vendor == ""
+		}
+		return strings.ToLower(result[a].Vendor) < strings.ToLower(result[b].Vendor)
+	})
+	return result
+}
+
+func countRestockItems(vendors []JsonRestockVendor) int {
+	count := 0
+	for _, v := range vendors {
+		count += len(v.Items)
+	}
+	return count
+}
+
+func (h *RestockHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+	defer ElapsedPrint("Restock", time.Now())
+	switch {
+	case strings.HasPrefix(req.URL.Path, kApiRestock):
+		h.apiRestock(out, req)
+	case strings.HasPrefix(req.URL.Path, kRestockCsv):
+		h.restockCsv(out, req)
+	default:
+		h.restockPage(out, req)
+	}
+}
+
+func (h *RestockHandler) restockPage(out http.ResponseWriter, r *http.Request) {
+	page := &RestockPage{
+		EditAllowed: editAllowed(r, h.editNets),
+		Categories:  available_category,
+	}
+	if r.Method == "POST" && r.FormValue("category") != "" {
+		if page.EditAllowed {
+			category := cleanString(r.FormValue("category"))
+			min_stock, _ := strconv.Atoi(r.FormValue("min_stock"))
+			h.store.SetCategoryMinStock(category, min_stock)
+			page.Msg = fmt.Sprintf("Default minimum stock for %q set to %d", category, min_stock)
+		} else {
+			page.Msg = "Not allowed to edit."
+		}
+	}
+	page.Vendors = collectRestockList(h.store)
+	page.Count = countRestockItems(page.Vendors)
+	page.DefaultMinStock = h.store.CategoryMinStock()
+	h.template.Render(out, "restock.html", page)
+}
+
+func (h *RestockHandler) apiRestock(out http.ResponseWriter, r *http.Request) {
+	out.Header().Set("Cache-Control", "max-age=10")
+	out.Header().Set("Content-Type", "application/json")
+	vendors := collectRestockList(h.store)
+	jsonResult := &JsonApiRestockResult{
+		Directlink: kRestockPage,
+		Count:      countRestockItems(vendors),
+		Vendors:    vendors,
+	}
+	json, _ := json.MarshalIndent(jsonResult, "", "  ")
+	out.Write(json)
+}
+
+func (h *RestockHandler) restockCsv(out http.ResponseWriter, r *http.Request) {
+	out.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	out.Header().Set("Content-Disposition", "attachment; filename=restock.csv")
+	records := [][]string{{"vendor", "id", "category", "value", "description",
+		"footprint", "quantity", "min_stock", "missing", "datasheet_url"}}
+	for _, vendor := range collectRestockList(h.store) {
+		for _, item := range vendor.Items {
+			records = append(records, []string{vendor.Vendor,
+				strconv.Itoa(item.Id), item.Category, item.Value,
+				item.Description, item.Footprint, item.Quantity,
+				strconv.Itoa(item.Min_stock), strconv.Itoa(item.Missing),
+				item.Datasheet_url})
+		}
+	}
+	if err := csv.NewWriter(out).WriteAll(records); err != nil {
+		log.Printf("Writing restock CSV: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func expectQuantity(t *testing.T, input string, expected int, expected_ok bool) {
+	result, ok := parseQuantity(input)
+	if ok != expected_ok || result != expected {
+		t.Errorf("'%s': expected (%d, %v) but got (%d, %v)",
+			input, expected, expected_ok, result, ok)
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	expectQuantity(t, "42", 42, true)
+	expectQuantity(t, " 42 ", 42, true)
+	expectQuantity(t, "< 50", 50, true)
+	expectQuantity(t, "~100", 100, true)
+	expectQuantity(t, "20-ish", 20, true)
+	expectQuantity(t, "1,000", 1000, true)
+	expectQuantity(t, "0", 0, true)
+
+	expectQuantity(t, "", 0, false)
+	expectQuantity(t, "lots", 0, false)
+}
+
+func TestRestockList(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "restock")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+
+	store.SetCategoryMinStock("Resistor", 20)
+	store.EditRecord(1, func(c *Component) bool {
+		c.Category = "Resistor"
+		c.Value = "10k"
+		c.Quantity = "5"
+		return true
+	})
+	store.EditRecord(2, func(c *Component) bool {
+		c.Category = "Resistor"
+		c.Value = "1k"
+		c.Quantity = "100"
+		return true
+	})
+	store.EditRecord(3, func(c *Component) bool {
+		c.Category = "Mosfet"
+		c.Value = "IRF540"
+		c.Quantity = "< 3"
+		c.Vendor = "Digikey"
+		c.Min_stock = 4
+		return true
+	})
+	store.EditRecord(4, func(c *Component) bool {
+		c.Category = "Mosfet"
+		c.Value = "2N7000"
+		c.Quantity = "2" // No threshold for this one.
+		return true
+	})
+
+	ExpectTrue(t, store.FindById(3).Min_stock == 4, "Min stock stored")
+	ExpectTrue(t, store.FindById(3).Vendor == "Digikey", "Vendor stored")
+
+	vendors := collectRestockList(store)
+	ExpectTrue(t, len(vendors) == 2, "Two vendors")
+	ExpectTrue(t, vendors[0].Vendor == "Digikey", "Known vendor first")
+	ExpectTrue(t, len(vendors[0].Items) == 1 && vendors[0].Items[0].Id == 3, "#3")
+	ExpectTrue(t, vendors[0].Items[0].Missing == 1, "missing count")
+	ExpectTrue(t, vendors[1].Vendor == "", "Unknown vendor last")
+	ExpectTrue(t, len(vendors[1].Items) == 1 && vendors[1].Items[0].Id == 1, "#1")
+
+	// Removing the category default
+	store.SetCategoryMinStock("Resistor", 0)
+	vendors = collectRestockList(store)
+	ExpectTrue(t, countRestockItems(vendors) == 1, "Only explicit threshold left")
+}
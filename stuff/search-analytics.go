@@ -0,0 +1,152 @@
+// What people search for, and what they don't find.
+//
+// The search page searches as you type, so a single search shows up as a
+// series of queries "r", "re", "res" ... "resistor 10k". These are
+// aggregated per client: a query is only recorded once the client stopped
+// refining it.
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// A query is recorded if it has not been refined for this long.
+	kSearchSettleTime = 5 * time.Second
+
+	// Longer queries are cut, they are most likely not typed by hand.
+	kMaxRecordedQueryLen = 200
+)
+
+var (
+	searchRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stuff_search_requests_total",
+		Help: "Search requests, including each keystroke of search-as-you-type.",
+	}, []string{"api"})
+	searchQueries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "stuff_search_queries_total",
+		Help: "Searches, after aggregating the refinements while typing.",
+	})
+	zeroResultQueries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "stuff_search_zero_result_queries_total",
+		Help: "Searches that did not find anything.",
+	})
+)
+
+type pendingSearch struct {
+	query   string
+	results int
+	last    time.Time
+}
+
+type SearchAnalytics struct {
+	store   StuffStore
+	lock    sync.Mutex
+	pending map[string]*pendingSearch // client -> query being typed.
+}
+
+func NewSearchAnalytics(store StuffStore) *SearchAnalytics {
+	return &SearchAnalytics{
+		store:   store,
+		pending: make(map[string]*pendingSearch),
+	}
+}
+
+// Queries differing only in case or spacing are the same.
+func normalizeRecordedQuery(query string) string {
+	query = strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	if len(query) > kMaxRecordedQueryLen {
+		query = strings.ToValidUTF8(query[:kMaxRecordedQueryLen], "")
+	}
+	return query
+}
+
+// If "query" is the next step of typing (or deleting) "previous".
+func isRefinement(previous, query string) bool {
+	return strings.HasPrefix(query, previous) || strings.HasPrefix(previous, query)
+}
+
+// Reverse proxies whose X-Forwarded-For header is trusted for the search
+// statistics, from --trusted-proxies. Anyone else could claim to be any
+// client.
+var trustedProxies []*net.IPNet
+
+// The client address of the request, the original one if forwarded by
+// trusted proxies. Empty if not known.
+func clientAddress(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	// Each proxy appends the address it got the request from; follow the
+	// chain back as long as that was one of ours.
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && ipInNetworks(net.ParseIP(addr), trustedProxies); i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			break
+		}
+		addr = hop
+	}
+	return addr
+}
+
+// A search from the given client, with the number of results, using the
+// given api. The query is recorded once the client is done refining it.
+func (a *SearchAnalytics) Add(client string, api string, query string, results int, now time.Time) {
+	if a == nil {
+		return
+	}
+	searchRequests.WithLabelValues(api).Inc()
+	query = normalizeRecordedQuery(query)
+	if query == "" {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	pending := a.pending[client]
+	if pending != nil && (now.Sub(pending.last) >= kSearchSettleTime ||
+		!isRefinement(pending.query, query)) {
+		a.record(pending)
+		pending = nil
+	}
+	if pending == nil {
+		pending = &pendingSearch{}
+		a.pending[client] = pending
+	}
+	pending.query, pending.results, pending.last = query, results, now
+}
+
+// Record all queries that have not been refined in a while.
+func (a *SearchAnalytics) Flush(now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for client, pending := range a.pending {
+		if now.Sub(pending.last) >= kSearchSettleTime {
+			a.record(pending)
+			delete(a.pending, client)
+		}
+	}
+}
+
+// Flush periodically. Does not return.
+func (a *SearchAnalytics) Run() {
+	for now := range time.Tick(kSearchSettleTime) {
+		a.Flush(now)
+	}
+}
+
+func (a *SearchAnalytics) record(search *pendingSearch) {
+	searchQueries.Inc()
+	if search.results == 0 {
+		zeroResultQueries.Inc()
+	}
+	a.store.RecordSearch(search.query, search.results)
+}
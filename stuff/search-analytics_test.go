@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRecordSearches(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "search-stats")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+	analytics := NewSearchAnalytics(store)
+
+	now := time.Now()
+	second := func(s int) time.Time {
+		return now.Add(time.Duration(s) * time.Second)
+	}
+	// Typing, with a typo fixed. Only the final query counts.
+	for i, q := range []string{"r", "re", "res", "resu", "res", "resi", "Resistor "} {
+		analytics.Add("1.2.3.4", "search-formatted", q, 10-i, second(0))
+	}
+	// Another client, in parallel.
+	analytics.Add("5.6.7.8", "search", "flux capacitor", 0, second(1))
+	analytics.Flush(second(3))
+	ExpectTrue(t, len(store.TopSearches(10, false)) == 0, "Still typing")
+
+	// Something else: the previous query is done.
+	analytics.Add("1.2.3.4", "search-formatted", "potentiometer", 5, second(4))
+	stats := store.TopSearches(10, false)
+	ExpectTrue(t, len(stats) == 1, "Only resistor")
+	expectEqual(t, stats[0].Query, "resistor")
+	ExpectTrue(t, stats[0].Results == 4, "Result count")
+
+	analytics.Flush(second(10))
+	ExpectTrue(t, len(store.TopSearches(10, false)) == 3, "Settled")
+	ExpectTrue(t, len(store.TopSearches(2, false)) == 2, "Limit")
+
+	// The same again, after a while, is a new search.
+	analytics.Add("1.2.3.4", "search", "resistor", 4, second(20))
+	analytics.Add("1.2.3.4", "search", "resistor", 4, second(30))
+	analytics.Flush(second(40))
+	stats = store.TopSearches(10, false)
+	expectEqual(t, stats[0].Query, "resistor")
+	ExpectTrue(t, stats[0].Count == 3, "Counted again")
+
+	stats = store.TopSearches(10, true)
+	ExpectTrue(t, len(stats) == 1, "Zero results")
+	expectEqual(t, stats[0].Query, "flux capacitor")
+	ExpectTrue(t, stats[0].ZeroResults == 1, "Once")
+
+	// Now we have it: not on the not-found list anymore.
+	analytics.Add("5.6.7.8", "search", "flux capacitor", 1, second(50))
+	analytics.Flush(second(60))
+	ExpectTrue(t, len(store.TopSearches(10, true)) == 0, "Found now")
+}
+
+func TestClientAddress(t *testing.T) {
+	defer func(saved []*net.IPNet) { trustedProxies = saved }(trustedProxies)
+	trustedProxies = parseAllowedEditorCIDR("trusted-proxies", "127.0.0.1/32,10.0.0.0/8")
+	request := func(remote string, forwarded ...string) *http.Request {
+		r := httptest.NewRequest("GET", "/search", nil)
+		r.RemoteAddr = remote
+		for _, f := range forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		return r
+	}
+	expectEqual(t, clientAddress(request("192.168.1.2:1234")), "192.168.1.2")
+	expectEqual(t, clientAddress(request("192.168.1.2:1234", "1.2.3.4")), "192.168.1.2") // Not a proxy.
+	expectEqual(t, clientAddress(request("127.0.0.1:1234", "1.2.3.4")), "1.2.3.4")
+	expectEqual(t, clientAddress(request("127.0.0.1:1234")), "127.0.0.1")
+	// Faked by the client, then forwarded by two proxies.
+	expectEqual(t, clientAddress(request("127.0.0.1:1234", "6.6.6.6, 1.2.3.4", "10.1.1.1")), "1.2.3.4")
+}
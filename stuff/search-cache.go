@@ -0,0 +1,168 @@
+// Cache of search results. The search page searches while typing, and
+// kiosk tablets run the same popular queries all day, so many searches are
+// repeated. Any change of the searched components invalidates the cache.
+package main
+
+import (
+	"container/list"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	kSearchCacheSize = 1000 // Number of queries to keep.
+
+	// Images are not tracked, so results are only kept so long.
+	kSearchCacheTTL = time.Minute
+)
+
+var (
+	searchCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "stuff_search_cache_hits_total",
+		Help: "Searches answered from the cache.",
+	})
+	searchCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "stuff_search_cache_misses_total",
+		Help: "Searches that had to be evaluated.",
+	})
+	searchCacheInvalidations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "stuff_search_cache_invalidations_total",
+		Help: "Times the search cache was cleared because something changed.",
+	})
+	searchCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "stuff_search_cache_entries",
+		Help: "Number of queries in the search cache.",
+	})
+)
+
+type searchCacheEntry struct {
+	key     string
+	result  *SearchResult
+	created time.Time
+}
+
+// Least recently used cache of search results.
+type searchCache struct {
+	lock       sync.Mutex
+	capacity   int
+	entries    map[string]*list.Element // key -> element with *searchCacheEntry
+	order      *list.List               // Most recently used first.
+	generation int                      // Incremented when invalidated.
+}
+
+func newSearchCache(capacity int) *searchCache {
+	return &searchCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Values whose meaning depends on case, such as 10m and 10M.
+var caseSensitiveValue = regexp.MustCompile(`\d[.,]?[MmGg]`)
+
+// Key of a query, normalized like recorded queries: the result does not
+// depend on case or spacing, only on the words. Quoted phrases are kept
+// as they are, their spacing is part of the match. The synonym generation
+// is part of the key, as the synonyms can change without a call to
+// SetSynonyms().
+func searchCacheKey(query string, synonyms int) string {
+	parts := strings.Split(query, "\"")
+	for i := 0; i < len(parts); i += 2 { // Odd parts are quoted.
+		parts[i] = normalizeQueryWords(parts[i])
+	}
+	parts[0] = strings.TrimLeft(parts[0], " ")
+	if last := len(parts) - 1; last%2 == 0 {
+		parts[last] = strings.TrimRight(parts[last], " ")
+	}
+	return strconv.Itoa(synonyms) + "\x00" + strings.Join(parts, "\"")
+}
+
+// Lowercase words and collapse the space around and between them to a
+// single blank.
+func normalizeQueryWords(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		if !caseSensitiveValue.MatchString(word) {
+			words[i] = strings.ToLower(word)
+		}
+	}
+	result := strings.Join(words, " ")
+	if text != strings.TrimLeftFunc(text, unicode.IsSpace) {
+		result = " " + result
+	}
+	if result != " " && text != strings.TrimRightFunc(text, unicode.IsSpace) {
+		result += " "
+	}
+	return result
+}
+
+// The current generation, to be passed to put() once the result for a
+// query is computed.
+func (c *searchCache) currentGeneration() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.generation
+}
+
+func (c *searchCache) get(key string, now time.Time) *SearchResult {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, found := c.entries[key]
+	if found && now.Sub(element.Value.(*searchCacheEntry).created) > kSearchCacheTTL {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		searchCacheEntries.Set(float64(c.order.Len()))
+		found = false
+	}
+	if !found {
+		searchCacheMisses.Inc()
+		return nil
+	}
+	searchCacheHits.Inc()
+	c.order.MoveToFront(element)
+	return element.Value.(*searchCacheEntry).result
+}
+
+// Store the result, unless the cache has been invalidated since the given
+// generation: then the result might be outdated already.
+func (c *searchCache) put(key string, result *SearchResult, generation int, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.capacity <= 0 || generation != c.generation {
+		return
+	}
+	if element, found := c.entries[key]; found {
+		element.Value = &searchCacheEntry{key: key, result: result, created: now}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&searchCacheEntry{key: key, result: result, created: now})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*searchCacheEntry).key)
+	}
+	searchCacheEntries.Set(float64(c.order.Len()))
+}
+
+// Forget everything, as the results might have changed.
+func (c *searchCache) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	if c.order.Len() == 0 {
+		return
+	}
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	searchCacheInvalidations.Inc()
+	searchCacheEntries.Set(0)
+}
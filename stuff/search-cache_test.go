@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSearchCacheEviction(t *testing.T) {
+	cache := newSearchCache(2)
+	now := time.Now()
+	a, b, c := &SearchResult{}, &SearchResult{}, &SearchResult{}
+	cache.put("a", a, 0, now)
+	cache.put("b", b, 0, now)
+	ExpectTrue(t, cache.get("a", now) == a, "Cached")
+	cache.put("c", c, 0, now)
+	ExpectTrue(t, cache.get("b", now) == nil, "Least recently used evicted")
+	ExpectTrue(t, cache.get("a", now) == a, "Recently used kept")
+	ExpectTrue(t, cache.get("c", now) == c, "Newest kept")
+
+	ExpectTrue(t, cache.get("a", now.Add(kSearchCacheTTL+time.Second)) == nil, "Expired")
+
+	cache.invalidate()
+	ExpectTrue(t, cache.get("c", now) == nil, "Invalidated")
+	cache.put("a", a, 0, now)
+	ExpectTrue(t, cache.get("a", now) == nil, "Computed before invalidation not kept")
+}
+
+func TestSearchCacheKey(t *testing.T) {
+	key := searchCacheKey("lm358", 0)
+	for _, query := range []string{"LM358", " lm358", "lm358 ", "Lm358\t"} {
+		expectEqual(t, searchCacheKey(query, 0), key)
+	}
+	expectEqual(t, searchCacheKey("10k  Resistor", 0), searchCacheKey("10K resistor", 0))
+	expectEqual(t, searchCacheKey("M3 screw", 0), searchCacheKey("m3 screw", 0))
+	ExpectTrue(t, searchCacheKey("10m", 0) != searchCacheKey("10M", 0), "milli vs. mega")
+	ExpectTrue(t, searchCacheKey("<4M7", 0) != searchCacheKey("<4m7", 0), "milli vs. mega")
+	ExpectTrue(t, searchCacheKey("lm358", 1) != key, "Other synonyms")
+
+	// Quoted phrases are matched as they are.
+	expectEqual(t, searchCacheKey(" Dual  \"Op Amp\"  LM358 ", 0), searchCacheKey("dual \"Op Amp\" lm358", 0))
+	ExpectTrue(t, searchCacheKey("\"10 k\"", 0) != searchCacheKey("\"10  k\"", 0), "Quoted spacing")
+	ExpectTrue(t, searchCacheKey("\"Op Amp\"", 0) != searchCacheKey("\"op amp\"", 0), "Quoted case")
+	ExpectTrue(t, searchCacheKey("lm358 \"rail ", 0) != searchCacheKey("lm358 \"rail", 0), "Unclosed quote")
+	ExpectTrue(t, searchCacheKey("a \"b\"", 0) != searchCacheKey("a\"b\"", 0), "Space before quote")
+}
+
+func TestSearchCacheInvalidation(t *testing.T) {
+	fts := NewFulltextSearch()
+	fts.Update(&Component{Id: 1, Equiv_set: 1, Category: "IC", Value: "LM358"})
+	first := fts.Search("lm358")
+	ExpectTrue(t, len(first.Results) == 1, "Found")
+	second := fts.Search("lm358 ")
+	ExpectTrue(t, fmt.Sprint(second.Results) == fmt.Sprint(first.Results), "Same result")
+	expectEqual(t, second.OrignialQuery, "lm358 ")
+	expectEqual(t, first.OrignialQuery, "lm358") // Cached one unchanged.
+	third := fts.Search("LM358 \"rail")
+	ExpectTrue(t, len(third.Errors) == 1 && third.Errors[0].Pos == 6, "Errors")
+	fourth := fts.Search("lm358  \"rail")
+	ExpectTrue(t, len(fourth.Errors) == 1 && fourth.Errors[0].Pos == 7, "Errors of this query")
+	expectEqual(t, fourth.RewrittenQuery, queryRewrite("lm358  \"rail"))
+
+	fts.Update(&Component{Id: 2, Equiv_set: 2, Category: "IC", Value: "LM358"})
+	ExpectTrue(t, len(fts.Search("lm358").Results) == 2, "Update seen")
+	fts.Remove(1)
+	ExpectTrue(t, len(fts.Search("lm358").Results) == 1, "Remove seen")
+
+	fts.SetSubstitutes([]*PartSubstitute{{Part: "LM2904", Substitute: "LM358"}})
+	ExpectTrue(t, len(fts.Search("lm2904").Results) == 1, "Substitutes seen")
+
+	// Synonyms can change without the search knowing.
+	d := NewSynonymDictionary("")
+	fts.SetSynonyms(d)
+	ExpectTrue(t, len(fts.Search("opamp").Results) == 0, "No synonyms")
+	groups, _ := parseSynonyms("opamp=lm358")
+	d.set("", groups)
+	ExpectTrue(t, len(fts.Search("opamp").Results) == 1, "Synonyms seen")
+}
@@ -0,0 +1,130 @@
+// Facets of search results: how many of the results are in each category,
+// footprint, drawer size etc., so that the query can be refined.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Only the most common values of each facet are shown.
+const kMaxFacetValues = 10
+
+var hashTag = regexp.MustCompile(`(?:^|[\s,;(])#([\p{L}\p{N}_-]+)`)
+
+// Names of the Drawersize values, as used in the drawer: qualifier.
+var drawerSizeNames = []string{"regular", "medium", "large"}
+
+type JsonFacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+	Query string `json:"query"` // Term to add to the query to filter by it.
+}
+
+type JsonFacets struct {
+	Category   []JsonFacetValue `json:"category"`
+	Footprint  []JsonFacetValue `json:"footprint"`
+	Drawersize []JsonFacetValue `json:"drawersize"`
+	Image      []JsonFacetValue `json:"image"`
+	Tag        []JsonFacetValue `json:"tag"`
+}
+
+// Hashtags in the notes, lowercased.
+func componentTags(c *Component) []string {
+	result := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range hashTag.FindAllStringSubmatch(c.Notes, -1) {
+		tag := strings.ToLower(match[1])
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// Counts values of one facet.
+type facetCounter struct {
+	counts  map[string]int
+	queries map[string]string
+}
+
+func newFacetCounter() *facetCounter {
+	return &facetCounter{
+		counts:  make(map[string]int),
+		queries: make(map[string]string),
+	}
+}
+
+func (f *facetCounter) add(value string, query string) {
+	if value == "" {
+		return
+	}
+	f.counts[value]++
+	f.queries[value] = query
+}
+
+// The most common values, most common first.
+func (f *facetCounter) values() []JsonFacetValue {
+	result := make([]JsonFacetValue, 0, len(f.counts))
+	for value, count := range f.counts {
+		result = append(result, JsonFacetValue{
+			Value: value,
+			Count: count,
+			Query: f.queries[value],
+		})
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Count != result[b].Count {
+			return result[a].Count > result[b].Count
+		}
+		return result[a].Value < result[b].Value
+	})
+	if len(result) > kMaxFacetValues {
+		result = result[:kMaxFacetValues]
+	}
+	return result
+}
+
+// A qualified query term, quoted if needed.
+func facetQuery(qualifier string, value string) string {
+	if strings.ContainsAny(value, " ()|!\"") {
+		return fmt.Sprintf(`%s:"%s"`, qualifier, strings.Replace(value, `"`, "", -1))
+	}
+	return qualifier + ":" + value
+}
+
+// Count the facets over all the results. The has_image function tells
+// if there is an image for a component, as the has:image qualifier does.
+func searchFacets(results []*Component, has_image func(id int) bool) *JsonFacets {
+	category := newFacetCounter()
+	footprint := newFacetCounter()
+	drawer := newFacetCounter()
+	image := newFacetCounter()
+	tag := newFacetCounter()
+	for _, c := range results {
+		category.add(c.Category, facetQuery("category", c.Category))
+		footprint.add(c.Footprint, facetQuery("footprint", c.Footprint))
+		if c.Drawersize >= 0 && c.Drawersize < len(drawerSizeNames) {
+			name := drawerSizeNames[c.Drawersize]
+			drawer.add(name, facetQuery("drawer", name))
+		}
+		if has_image != nil && has_image(c.Id) {
+			image.add("with image", "has:image")
+		} else {
+			image.add("without image", "!has:image")
+		}
+		for _, t := range componentTags(c) {
+			tag.add("#"+t, facetQuery("tag", t))
+		}
+	}
+	return &JsonFacets{
+		Category:   category.values(),
+		Footprint:  footprint.values(),
+		Drawersize: drawer.values(),
+		Image:      image.values(),
+		Tag:        tag.values(),
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSearchFacets(t *testing.T) {
+	components := []*Component{
+		{Id: 1, Category: "Resistor", Value: "10k", Notes: "#smd #0805"},
+		{Id: 2, Category: "Resistor", Value: "10k 1%", Drawersize: 1},
+		{Id: 3, Category: "Potentiometer", Value: "10k", Footprint: "TO-220"},
+		{Id: 4, Category: "Capacitor (C)", Value: "10kpF", Notes: "#SMD, also see 5"},
+		{Id: 5, Category: "Resistor", Value: "10k", Footprint: "TO-220", Drawersize: 2},
+	}
+	has_image := func(id int) bool { return id == 3 }
+	facets := searchFacets(components, has_image)
+
+	expectFacet := func(values []JsonFacetValue, expected string) {
+		actual := ""
+		for _, v := range values {
+			actual += fmt.Sprintf("%s=%d;", v.Value, v.Count)
+		}
+		expectEqual(t, actual, expected)
+	}
+	expectFacet(facets.Category, "Resistor=3;Capacitor (C)=1;Potentiometer=1;")
+	expectFacet(facets.Footprint, "TO-220=2;")
+	expectFacet(facets.Drawersize, "regular=3;large=1;medium=1;")
+	expectFacet(facets.Image, "without image=4;with image=1;")
+	expectFacet(facets.Tag, "#smd=2;#0805=1;")
+
+	// Adding the query of a facet value filters to exactly these.
+	fts := NewFulltextSearch()
+	fts.SetImageChecker(has_image)
+	for _, c := range components {
+		c.Equiv_set = c.Id
+		fts.Update(c)
+	}
+	for _, values := range [][]JsonFacetValue{facets.Category, facets.Footprint,
+		facets.Drawersize, facets.Image, facets.Tag} {
+		for _, v := range values {
+			query := "10k " + v.Query
+			ExpectTrue(t, len(fts.Search(query).Results) == v.Count,
+				fmt.Sprintf("%s: %d results", query, len(fts.Search(query).Results)))
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Typo tolerant search: words of a query that don't appear in any component
+// are matched against similar words from the vocabulary of all components.
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Fuzzy matches score lower than exact ones.
+const kFuzzyWeight = 0.3
+
+// Shorter words are too likely to be close to something unrelated.
+const kMinFuzzyLen = 4
+
+// Maximum number of similar words a term is matched with.
+const kMaxFuzzyAlternatives = 5
+
+// All the words in the searchable fields of the components, with the number
+// of components they appear in.
+type vocabulary struct {
+	words map[string]int
+}
+
+func newVocabulary() *vocabulary {
+	return &vocabulary{words: make(map[string]int)}
+}
+
+// Words of the preprocessed fields of a component.
+func componentWords(c *SearchComponent) map[string]bool {
+	result := make(map[string]bool)
+	for _, field := range []string{
+		c.preprocessed.Category,
+		c.preprocessed.Value,
+		c.preprocessed.Description,
+		c.preprocessed.Notes,
+		c.preprocessed.Footprint,
+	} {
+		for _, word := range strings.FieldsFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			result[word] = true
+		}
+	}
+	return result
+}
+
+func (v *vocabulary) add(words map[string]bool) {
+	for word := range words {
+		v.words[word]++
+	}
+}
+
+func (v *vocabulary) remove(words map[string]bool) {
+	for word := range words {
+		if v.words[word] <= 1 {
+			delete(v.words, word)
+		} else {
+			v.words[word]--
+		}
+	}
+}
+
+// Returns true if the text is found somewhere in the vocabulary, i.e. it
+// can match exactly.
+func (v *vocabulary) known(text string) bool {
+	if _, found := v.words[text]; found {
+		return true
+	}
+	for word := range v.words {
+		if strings.Contains(word, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// The number of typos we tolerate in a word.
+func maxEditDistance(word string) int {
+	switch length := len([]rune(word)); {
+	case length < kMinFuzzyLen:
+		return 0
+	case length < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Levenshtein distance between a and b. Gives up early and returns
+// max+1 if it is larger than max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra)-len(rb) > max || len(rb)-len(ra) > max {
+		return max + 1
+	}
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		row_min := current[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if current[j] < row_min {
+				row_min = current[j]
+			}
+		}
+		if row_min > max {
+			return max + 1
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// Words of the vocabulary that are within the tolerated edit distance of
+// the given word. The closest and most common first.
+func (v *vocabulary) similar(text string) []string {
+	max := maxEditDistance(text)
+	if max == 0 {
+		return nil
+	}
+	distance := make(map[string]int)
+	result := make([]string, 0)
+	for word := range v.words {
+		if d := editDistance(text, word, max); d <= max {
+			distance[word] = d
+			result = append(result, word)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		word_a, word_b := result[a], result[b]
+		if distance[word_a] != distance[word_b] {
+			return distance[word_a] < distance[word_b]
+		}
+		if v.words[word_a] != v.words[word_b] {
+			return v.words[word_a] > v.words[word_b]
+		}
+		return word_a < word_b
+	})
+	if len(result) > kMaxFuzzyAlternatives {
+		result = result[:kMaxFuzzyAlternatives]
+	}
+	return result
+}
+
+// Returns true if the term is a plain word we'd consider misspelled if
+// it is not in the vocabulary.
+func isFuzzyCandidate(term *queryTerm) bool {
+	if term.numeric != nil || term.predicate != nil || term.field == "set" ||
+		len([]rune(term.text)) < kMinFuzzyLen {
+		return false
+	}
+	for _, r := range term.text {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Set the similar words for all the terms that are not in the vocabulary.
+// Returns a map of the corrected terms to their most likely correction.
+func (v *vocabulary) addFuzzyAlternatives(terms []*queryTerm) map[string]string {
+	corrections := make(map[string]string)
+	for _, term := range terms {
+		if !isFuzzyCandidate(term) || v.known(term.text) {
+			continue
+		}
+		term.fuzzy = v.similar(term.text)
+		if len(term.fuzzy) > 0 {
+			corrections[term.text] = term.fuzzy[0]
+		}
+	}
+	return corrections
+}
+
+// Score of the best similar word of the term. Zero if there is none.
+func (c *SearchComponent) fuzzyScore(term *queryTerm) float32 {
+	var best float32
+	for _, word := range term.fuzzy {
+		alternative := queryTerm{text: word, field: term.field}
+		best = maxlist(best, c.termScore(&alternative))
+	}
+	return kFuzzyWeight * best
+}
+
+// The query with all the corrected words replaced. Empty if nothing could
+// be replaced.
+func suggestQuery(query string, corrections map[string]string) string {
+	result := query
+	for misspelled, correction := range corrections {
+		word := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(misspelled) + `\b`)
+		result = word.ReplaceAllString(result, correction)
+	}
+	if result == query {
+		return ""
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		max      int
+		expected int
+	}{
+		{"mosfet", "mosfet", 2, 0},
+		{"mosfett", "mosfet", 2, 1},
+		{"potentiomter", "potentiometer", 2, 1},
+		{"capcaitor", "capacitor", 2, 2},
+		{"diode", "anode", 2, 2},
+		{"resistor", "transistor", 2, 3}, // Gives up
+		{"µfarad", "ufarad", 2, 1},
+		{"", "abc", 5, 3},
+	} {
+		ExpectTrue(t, editDistance(tc.a, tc.b, tc.max) == tc.expected,
+			fmt.Sprintf("%s/%s: %d", tc.a, tc.b, editDistance(tc.a, tc.b, tc.max)))
+	}
+}
+
+func TestFuzzySearch(t *testing.T) {
+	fts := NewFulltextSearch()
+	fts.Update(&Component{Id: 1, Equiv_set: 1, Category: "Potentiometer", Value: "10k"})
+	fts.Update(&Component{Id: 2, Equiv_set: 2, Category: "Mosfet", Value: "IRF540", Footprint: "TO-220"})
+	fts.Update(&Component{Id: 3, Equiv_set: 3, Category: "Resistor", Value: "10k",
+		Description: "potentiometer replacement"})
+
+	result := fts.Search("potentiomter")
+	ExpectTrue(t, len(result.Results) == 2, "Both found")
+	expectEqual(t, result.Suggestion, "potentiometer")
+
+	result = fts.Search("Mosfett TO220")
+	ExpectTrue(t, len(result.Results) == 1 && result.Results[0].Id == 2, "Mosfet")
+	expectEqual(t, result.Suggestion, "mosfet TO220")
+
+	// Exact hits score higher.
+	fts.Update(&Component{Id: 4, Equiv_set: 4, Category: "Mosfett", Value: "IRF540"})
+	result = fts.Search("mosfett")
+	ExpectTrue(t, len(result.Results) == 1, "Only exact one")
+	expectEqual(t, result.Suggestion, "")
+	fts.Remove(4)
+
+	result = fts.Search("category:mosfett")
+	ExpectTrue(t, len(result.Results) == 1 && result.Results[0].Id == 2, "Qualified")
+	fts.Update(&Component{Id: 5, Equiv_set: 5, Category: "Capacitor", Value: "100n",
+		Description: "like a mosfet, but not"})
+	result = fts.Search("mosfett")
+	ExpectTrue(t, len(result.Results) == 2 && result.Results[0].Id == 2, "Category first")
+
+	// Substrings of words are not misspelled.
+	result = fts.Search("potent")
+	ExpectTrue(t, len(result.Results) == 2, "Prefix")
+	expectEqual(t, result.Suggestion, "")
+
+	// Short words and numbers are not corrected.
+	ExpectTrue(t, len(fts.Search("10m").Results) == 0, "Number")
+	ExpectTrue(t, len(fts.Search("irf541").Results) == 0, "Part number")
+	ExpectTrue(t, len(fts.Search("irg").Results) == 0, "Short")
+
+	// Nothing similar, nothing suggested.
+	result = fts.Search("xyzzy")
+	ExpectTrue(t, len(result.Results) == 0, "No result")
+	expectEqual(t, result.Suggestion, "")
+
+	// Removed words are not suggested anymore.
+	fts.Remove(2)
+	fts.Remove(5)
+	expectEqual(t, fts.Search("mosfett").Suggestion, "")
+}
@@ -18,30 +18,36 @@ const (
 	kSearchPage         = "/search"
 	kApiSearchFormatted = "/api/search-formatted"
 	kApiSearch          = "/api/search"
+	kApiSimilar         = "/api/similar"
 )
 
 type SearchHandler struct {
 	store        StuffStore
 	template     *TemplateRenderer
 	imagehandler *ImageHandler
+	analytics    *SearchAnalytics
 }
 
-func AddSearchHandler(store StuffStore, template *TemplateRenderer, imagehandler *ImageHandler) {
+func AddSearchHandler(store StuffStore, template *TemplateRenderer, imagehandler *ImageHandler, analytics *SearchAnalytics) {
 	handler := &SearchHandler{
 		store:        store,
 		template:     template,
 		imagehandler: imagehandler,
+		analytics:    analytics,
 	}
 	http.Handle(kSearchPage, handler)
 	http.Handle("/", handler)
 	http.Handle(kApiSearchFormatted, handler)
 	http.Handle(kApiSearch, handler)
+	http.Handle(kApiSimilar, handler)
 }
 
 func (h *SearchHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
 	switch {
 	case strings.HasPrefix(req.URL.Path, kApiSearchFormatted):
 		h.apiSearchPageItem(out, req)
+	case strings.HasPrefix(req.URL.Path, kApiSimilar):
+		h.apiSimilar(out, req)
 	case strings.HasPrefix(req.URL.Path, kApiSearch):
 		h.apiSearch(out, req)
 	default:
@@ -58,10 +64,17 @@ func (h *SearchHandler) showSearchPage(out http.ResponseWriter, r *http.Request)
 
 type JsonComponent struct {
 	Component
-	Image string `json:"img"`
+	Image      string            `json:"img"`
+	Substitute string            `json:"substitute_for,omitempty"` // Part this substitutes.
+	Matches    []TermMatch       `json:"matches,omitempty"`
+	Highlights map[string]string `json:"highlights,omitempty"` // field -> HTML
 }
 type JsonApiSearchResult struct {
 	Directlink string          `json:"link"`
+	Total      int             `json:"total"`          // Number of all results.
+	Offset     int             `json:"offset"`         // Position of the first item.
+	Next       string          `json:"next,omitempty"` // Cursor of next page.
+	Errors     []QueryError    `json:"errors,omitempty"`
 	Items      []JsonComponent `json:"components"`
 }
 
@@ -72,6 +85,18 @@ func encodeUriComponent(str string) string {
 	}
 	return u.String()
 }
+
+// The results in the requested sort order. Sorts a copy, so that the
+// search result stays ordered by relevance.
+func sortedResults(searchResults *SearchResult, order string) ([]*Component, error) {
+	if order == "" || order == "relevance" {
+		return searchResults.Results, nil
+	}
+	results := make([]*Component, len(searchResults.Results))
+	copy(results, searchResults.Results)
+	return results, sortResults(results, order)
+}
+
 func (h *SearchHandler) apiSearch(out http.ResponseWriter, r *http.Request) {
 	// Allow very brief caching, so that editing the query does not
 	// necessarily has to trigger a new server roundtrip.
@@ -80,47 +105,111 @@ func (h *SearchHandler) apiSearch(out http.ResponseWriter, r *http.Request) {
 	defaultOutLen := 20
 	maxOutLen := 100 // Limit max output
 	query := r.FormValue("q")
-	limit, _ := strconv.Atoi(r.FormValue("count"))
-	if limit <= 0 {
-		limit = defaultOutLen
-	}
-	if limit > maxOutLen {
-		limit = maxOutLen
-	}
-	var searchResults *SearchResult
+	order := r.FormValue("sort")
+	searchResults := &SearchResult{}
 	if query != "" {
-		searchResults = h.store.Search(query)
+		// In relevance order, only the results up to the page are needed.
+		limit := pageLimit(r, defaultOutLen, maxOutLen)
+		if limit > 0 && (order == "" || order == "relevance") {
+			searchResults = h.store.SearchTop(query, limit)
+		} else {
+			searchResults = h.store.Search(query)
+		}
 	}
-	outlen := limit
-	if len(searchResults.Results) < limit {
-		outlen = len(searchResults.Results)
+	results, err := sortedResults(searchResults, order)
+	if err != nil {
+		http.Error(out, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := paginateTop(results, searchResults.Total, r, defaultOutLen, maxOutLen)
+	if page.Offset == 0 { // Following pages are the same search.
+		h.analytics.Add(clientAddress(r), "search", query, page.Total, time.Now())
 	}
 	jsonResult := &JsonApiSearchResult{
 		Directlink: encodeUriComponent("/search#" + query),
-		Items:      make([]JsonComponent, outlen),
+		Total:      page.Total,
+		Offset:     page.Offset,
+		Next:       page.Next,
+		Errors:     searchResults.Errors,
+		Items:      make([]JsonComponent, len(page.Items)),
 	}
 
-	for i := 0; i < outlen; i++ {
-		var c = searchResults.Results[i]
+	for i, c := range page.Items {
 		jsonResult.Items[i].Component = *c
 		jsonResult.Items[i].Image = fmt.Sprintf("/img/%d", c.Id)
+		jsonResult.Items[i].Substitute = searchResults.Substitutes[c.Id]
+		jsonResult.Items[i].Matches = searchResults.Matches(c)
+		jsonResult.Items[i].Highlights = highlightFields(c, jsonResult.Items[i].Matches)
 	}
 
 	json, _ := json.MarshalIndent(jsonResult, "", "  ")
 	out.Write(json)
 }
 
+type JsonSimilarComponent struct {
+	Component
+	Image      string  `json:"img"`
+	Similarity float32 `json:"similarity"` // 0..1
+}
+type JsonApiSimilarResult struct {
+	Directlink string                 `json:"link"`
+	Id         int                    `json:"id"`
+	Total      int                    `json:"total"`
+	Items      []JsonSimilarComponent `json:"components"`
+}
+
+func (h *SearchHandler) apiSimilar(out http.ResponseWriter, r *http.Request) {
+	defer ElapsedPrint("Similar", time.Now())
+	out.Header().Set("Cache-Control", "max-age=10")
+	out.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(out, "Need id", http.StatusBadRequest)
+		return
+	}
+	similar := h.store.SimilarComponents(id)
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil || count <= 0 {
+		count = 20
+	}
+	if count > 100 {
+		count = 100
+	}
+	jsonResult := &JsonApiSimilarResult{
+		Directlink: fmt.Sprintf("/search#like:%d", id),
+		Id:         id,
+		Total:      len(similar),
+		Items:      make([]JsonSimilarComponent, 0, count),
+	}
+	for i := 0; i < len(similar) && i < count; i++ {
+		jsonResult.Items = append(jsonResult.Items, JsonSimilarComponent{
+			Component:  *similar[i].Component,
+			Image:      fmt.Sprintf("/img/%d", similar[i].Component.Id),
+			Similarity: similar[i].Similarity,
+		})
+	}
+	json, _ := json.MarshalIndent(jsonResult, "", "  ")
+	out.Write(json)
+}
+
 // Pre-formatted search for quick div replacements.
 type JsonHtmlSearchResultRecord struct {
-	Id     int    `json:"id"`
-	Label  string `json:"txt"`
-	ImgUrl string `json:"img"`
+	Id         int    `json:"id"`
+	Label      string `json:"txt"`
+	Snippet    string `json:"snippet,omitempty"`    // Matching excerpt of the notes
+	Substitute string `json:"substitute,omitempty"` // Part this substitutes.
+	ImgUrl     string `json:"img"`
 }
 
 type JsonHtmlSearchResult struct {
 	Count      int                          `json:"count"`
 	QueryInfo  string                       `json:"queryinfo"`
 	ResultInfo string                       `json:"resultinfo"`
+	Suggestion string                       `json:"suggestion,omitempty"` // "Did you mean"
+	Facets     *JsonFacets                  `json:"facets,omitempty"`
+	Offset     int                          `json:"offset"`
+	Next       string                       `json:"next,omitempty"` // Cursor of next page.
+	Errors     []QueryError                 `json:"errors,omitempty"`
 	Items      []JsonHtmlSearchResultRecord `json:"items"`
 }
 
@@ -131,36 +220,50 @@ func (h *SearchHandler) apiSearchPageItem(out http.ResponseWriter, r *http.Reque
 	out.Header().Set("Cache-Control", "max-age=10")
 	query := r.FormValue("q")
 	if query == "" {
-		out.Write([]byte(`{"count":0, "queryinfo":"", "resultinfo":"", "items":[]}`))
+		out.Write([]byte(`{"count":0, "queryinfo":"", "resultinfo":"", "offset":0, "items":[]}`))
 		return
 	}
 	start := time.Now()
 	searchResults := h.store.Search(query)
+	results, err := sortedResults(searchResults, r.FormValue("sort"))
+	if err != nil {
+		http.Error(out, err.Error(), http.StatusBadRequest)
+		return
+	}
 	elapsed := time.Since(start)
 	elapsed = time.Microsecond * ((elapsed + time.Microsecond/2) / time.Microsecond)
 
 	// We only want to output a query info if it actually has been
-	// rewritten, e.g. 0.1u becomes (100n | 0.1u)
+	// rewritten, e.g. 0.1u becomes (100n | 0.1u); not if only the
+	// spacing is different.
 	queryInfo := ""
-	if searchResults.RewrittenQuery != searchResults.OrignialQuery {
+	if strings.Replace(searchResults.RewrittenQuery, " ", "", -1) !=
+		strings.Replace(searchResults.OrignialQuery, " ", "", -1) {
 		queryInfo = searchResults.RewrittenQuery
 	}
 
-	outlen := 24 // Limit max output
-	if len(searchResults.Results) < outlen {
-		outlen = len(searchResults.Results)
+	page := paginate(results, r, 24, 100)
+	if page.Offset == 0 {
+		h.analytics.Add(clientAddress(r), "search-formatted", query, page.Total, time.Now())
 	}
 	jsonResult := &JsonHtmlSearchResult{
-		Count:      len(searchResults.Results),
-		ResultInfo: fmt.Sprintf("%d results (%s)", len(searchResults.Results), elapsed),
+		Count:      page.Total,
+		ResultInfo: fmt.Sprintf("%d results (%s)", page.Total, elapsed),
 		QueryInfo:  queryInfo,
-		Items:      make([]JsonHtmlSearchResultRecord, outlen),
+		Suggestion: searchResults.Suggestion,
+		Offset:     page.Offset,
+		Next:       page.Next,
+		Errors:     searchResults.Errors,
+		Items:      make([]JsonHtmlSearchResultRecord, len(page.Items)),
+	}
+	if page.Offset == 0 {
+		// Following pages are only appended, facets stay as they are.
+		jsonResult.Facets = searchFacets(searchResults.Results, h.imagehandler.hasPhoto)
 	}
 
 	pusher, _ := out.(http.Pusher) // HTTP/2 pushing if available.
 
-	for i := 0; i < outlen; i++ {
-		var c = searchResults.Results[i]
+	for i, c := range page.Items {
 		jsonResult.Items[i].Id = c.Id
 		if h.imagehandler.hasComponentImage(c) {
 			imgUrl := fmt.Sprintf("/img/%d", c.Id)
@@ -178,9 +281,16 @@ func (h *SearchHandler) apiSearchPageItem(out http.ResponseWriter, r *http.Reque
 		} else {
 			jsonResult.Items[i].ImgUrl = "/static/fallback.png"
 		}
-		jsonResult.Items[i].Label = "<b>" + html.EscapeString(c.Value) + "</b> " +
-			html.EscapeString(c.Description) +
+		matches := searchResults.Matches(c)
+		jsonResult.Items[i].Label = "<b>" + highlightHTML(c.Value, matches, "value", 0) + "</b> " +
+			highlightHTML(c.Description, matches, "description", 0) +
 			fmt.Sprintf(" <span class='idtxt'>(ID:%d)</span>", c.Id)
+		jsonResult.Items[i].Snippet = notesSnippet(c, matches)
+		if part, found := searchResults.Substitutes[c.Id]; found {
+			jsonResult.Items[i].Substitute = part
+			jsonResult.Items[i].Label = "<span class='substitute'>Substitute for " +
+				html.EscapeString(part) + "</span> " + jsonResult.Items[i].Label
+		}
 	}
 
 	json, _ := json.Marshal(jsonResult)
@@ -0,0 +1,222 @@
+// Reporting where the terms of a query matched a component, to highlight
+// them in the search results and show excerpts of long fields such as the
+// notes.
+package main
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Maximum length of the excerpt of a long field around the first match.
+const kSnippetLen = 80
+
+// Fields of a component that are searched as text.
+var kTextFields = []string{"category", "value", "description", "notes", "footprint"}
+
+// A term of the query found in a field of the component.
+type TermMatch struct {
+	Term  string `json:"term"`
+	Field string `json:"field"`
+	Start int    `json:"start"` // Byte offsets in the original field text.
+	End   int    `json:"end"`
+}
+
+func componentField(c *Component, field string) string {
+	switch field {
+	case "category":
+		return c.Category
+	case "value":
+		return c.Value
+	case "description":
+		return c.Description
+	case "notes":
+		return c.Notes
+	case "footprint":
+		return c.Footprint
+	}
+	return ""
+}
+
+// Find the preprocessed needle in the original text. Returns the byte
+// offsets of the match in the text.
+func locateMatch(needle string, text string) (int, int, bool) {
+	// Do the same as preprocessTerm(), but remember for each byte of the
+	// result where it came from.
+	var normalized strings.Builder
+	var from, to []int
+	emit := func(s string, start, end int) {
+		normalized.WriteString(s)
+		for i := 0; i < len(s); i++ {
+			from = append(from, start)
+			to = append(to, end)
+		}
+	}
+	previous := ' '
+	for i, r := range text {
+		end := i + utf8.RuneLen(r)
+		joining_dash := r == '-' && isWordRune(previous)
+		previous = unicode.ToLower(r)
+		if joining_dash {
+			continue
+		}
+		switch r {
+		case '(', ')', '|':
+			emit(" ", i, i)
+			emit(string(r), i, end)
+			emit(" ", end, end)
+		default:
+			emit(strings.ToLower(string(r)), i, end)
+		}
+	}
+	pos := strings.Index(normalized.String(), needle)
+	if pos < 0 || needle == "" {
+		return 0, 0, false
+	}
+	return from[pos], to[pos+len(needle)-1], true
+}
+
+// Where the text of a term is found in the component, one match per field.
+func (c *SearchComponent) textMatches(term string, field string) []TermMatch {
+	result := make([]TermMatch, 0)
+	for _, name := range kTextFields {
+		if field != "" && field != name {
+			continue
+		}
+		if !strings.Contains(componentField(c.preprocessed, name), term) {
+			continue
+		}
+		if start, end, found := locateMatch(term, componentField(c.orig, name)); found {
+			result = append(result, TermMatch{Term: term, Field: name, Start: start, End: end})
+		}
+	}
+	return result
+}
+
+// Where a single term matched the component.
+func (c *SearchComponent) termMatches(term *queryTerm) []TermMatch {
+	switch {
+	case term.numeric != nil:
+		for _, v := range c.values {
+			if v.main && term.numeric.valueScore(v) > 0 {
+				return []TermMatch{{Term: term.text, Field: "value", End: len(c.orig.Value)}}
+			}
+		}
+		return nil
+	case term.predicate != nil, term.field == "like", term.text == "":
+		return nil
+	}
+	result := c.textMatches(term.text, term.field)
+	if len(result) > 0 {
+		return result
+	}
+	for _, word := range term.fuzzy {
+		result = append(result, c.textMatches(word, term.field)...)
+	}
+	return result
+}
+
+// Where the terms of the query matched the component. Negated terms are
+// not reported, as they only match components that don't contain them.
+func (c *SearchComponent) collectMatches(n *queryNode) []TermMatch {
+	if n == nil || n.kind == kNotNode {
+		return nil
+	}
+	if n.kind == kTermNode {
+		return c.termMatches(n.term)
+	}
+	result := make([]TermMatch, 0)
+	for _, child := range n.children {
+		result = append(result, c.collectMatches(child)...)
+	}
+	return result
+}
+
+// HTML of the text with the matches in the given field marked. If maxLen is
+// not zero and the text is longer, only an excerpt around the first match
+// is returned.
+func highlightHTML(text string, matches []TermMatch, field string, maxLen int) string {
+	ranges := make([]TermMatch, 0)
+	for _, m := range matches {
+		if m.Field == field && m.End <= len(text) {
+			ranges = append(ranges, m)
+		}
+	}
+	sort.Slice(ranges, func(a, b int) bool {
+		return ranges[a].Start < ranges[b].Start
+	})
+
+	start, end := 0, len(text)
+	if maxLen > 0 && len(text) > maxLen {
+		if len(ranges) > 0 {
+			start = ranges[0].Start - maxLen/4
+		}
+		if start+maxLen > len(text) {
+			start = len(text) - maxLen
+		}
+		if start < 0 {
+			start = 0
+		}
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+		end = start + maxLen
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+
+	var result strings.Builder
+	if start > 0 {
+		result.WriteString("…")
+	}
+	pos := start
+	for _, r := range ranges {
+		if r.End <= pos || r.Start >= end {
+			continue // Overlapping an earlier one or outside the excerpt.
+		}
+		if r.Start > pos {
+			result.WriteString(html.EscapeString(text[pos:r.Start]))
+		} else {
+			r.Start = pos
+		}
+		if r.End > end {
+			r.End = end
+		}
+		result.WriteString("<mark>" + html.EscapeString(text[r.Start:r.End]) + "</mark>")
+		pos = r.End
+	}
+	result.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		result.WriteString("…")
+	}
+	return result.String()
+}
+
+// Highlighted HTML of all the fields that matched; long fields shortened to
+// an excerpt.
+func highlightFields(c *Component, matches []TermMatch) map[string]string {
+	if len(matches) == 0 {
+		return nil
+	}
+	result := make(map[string]string)
+	for _, m := range matches {
+		if _, done := result[m.Field]; !done {
+			result[m.Field] = highlightHTML(componentField(c, m.Field), matches, m.Field, kSnippetLen)
+		}
+	}
+	return result
+}
+
+// Excerpt of the notes if the query matched there. Empty otherwise.
+func notesSnippet(c *Component, matches []TermMatch) string {
+	for _, m := range matches {
+		if m.Field == "notes" {
+			return highlightHTML(c.Notes, matches, "notes", kSnippetLen)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLocateMatch(t *testing.T) {
+	expectLocated := func(needle, text, expected string) {
+		start, end, found := locateMatch(needle, text)
+		actual := "<none>"
+		if found {
+			actual = text[start:end]
+		}
+		expectEqual(t, actual, expected)
+	}
+	expectLocated("lm358", "Dual OpAmp LM-358", "LM-358")
+	expectLocated("opamp", "Dual OpAmp LM-358", "OpAmp")
+	expectLocated("µf", "100µF", "µF")
+	expectLocated("(c)", "Capacitor (C)", "<none>") // Spaced in preprocessing
+	expectLocated("( c )", "Capacitor (C)", "(C)")
+	expectLocated("foo", "Dual OpAmp", "<none>")
+}
+
+func searchMatches(c *Component, query string) string {
+	fts := NewFulltextSearch()
+	fts.Update(c)
+	result := fts.Search(query)
+	if len(result.Results) == 0 {
+		return "<no result>"
+	}
+	matches := make([]string, 0)
+	for _, m := range result.Matches(result.Results[0]) {
+		text := componentField(result.Results[0], m.Field)[m.Start:m.End]
+		matches = append(matches, fmt.Sprintf("%s:%s", m.Field, text))
+	}
+	return strings.Join(matches, " ")
+}
+
+func TestSearchMatches(t *testing.T) {
+	c := &Component{
+		Id:          1,
+		Equiv_set:   1,
+		Category:    "Resistor",
+		Value:       "10k",
+		Description: "Metal film resistor, 1%",
+		Notes:       "Used in the LED-driver of the door sign.",
+	}
+	expectEqual(t, searchMatches(c, "resistor"),
+		"category:Resistor description:resistor")
+	expectEqual(t, searchMatches(c, "leddriver"), "notes:LED-driver")
+	expectEqual(t, searchMatches(c, "description:resistor"), "description:resistor")
+	expectEqual(t, searchMatches(c, "film -capacitor"), "description:film")
+	expectEqual(t, searchMatches(c, "foo | sign"), "notes:sign")
+	expectEqual(t, searchMatches(c, "value:5k..20k"), "value:10k")
+	expectEqual(t, searchMatches(c, "metall"), "description:Metal") // Typo
+	expectEqual(t, searchMatches(c, "id:1"), "")
+}
+
+func TestHighlightHTML(t *testing.T) {
+	text := "Use <b> & driver"
+	matches := []TermMatch{
+		{Field: "notes", Start: 10, End: 16},
+		{Field: "notes", Start: 4, End: 7}, // Out of order
+		{Field: "notes", Start: 5, End: 6}, // Overlapping
+		{Field: "value", Start: 0, End: 3}, // Other field
+	}
+	expectEqual(t, highlightHTML(text, matches, "notes", 0),
+		"Use <mark>&lt;b&gt;</mark> &amp; <mark>driver</mark>")
+	expectEqual(t, highlightHTML(text, nil, "notes", 0), "Use &lt;b&gt; &amp; driver")
+
+	// Long text: excerpt around the first match.
+	long := strings.Repeat("a", 100) + " match " + strings.Repeat("b", 100)
+	excerpt := highlightHTML(long, []TermMatch{{Field: "notes", Start: 101, End: 106}}, "notes", 40)
+	expectEqual(t, excerpt, "…"+strings.Repeat("a", 9)+" <mark>match</mark> "+
+		strings.Repeat("b", 24)+"…")
+	excerpt = highlightHTML(long, nil, "notes", 40)
+	expectEqual(t, excerpt, strings.Repeat("a", 40)+"…")
+}
@@ -0,0 +1,280 @@
+// Trigram inverted index to narrow down the components that need to be
+// scored in a search, and persistence of the full text search state.
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Terms shorter than this can't be looked up in the index.
+const kTrigramLen = 3
+
+// Bump if the preprocessing or the format of the persisted index changes.
+const kSearchIndexVersion = 2
+
+// Maps trigrams to the sorted IDs of the components that contain them in
+// any of the searched fields.
+type trigramIndex struct {
+	postings map[string][]int
+}
+
+func newTrigramIndex() *trigramIndex {
+	return &trigramIndex{
+		postings: make(map[string][]int),
+	}
+}
+
+// All the trigrams found in the searchable fields of the component.
+func componentTrigrams(c *SearchComponent) map[string]bool {
+	result := make(map[string]bool)
+	for _, field := range []string{
+		c.preprocessed.Category,
+		c.preprocessed.Value,
+		c.preprocessed.Description,
+		c.preprocessed.Notes,
+		c.preprocessed.Footprint,
+	} {
+		for i := 0; i+kTrigramLen <= len(field); i++ {
+			result[field[i:i+kTrigramLen]] = true
+		}
+	}
+	return result
+}
+
+func (t *trigramIndex) add(id int, grams map[string]bool) {
+	for gram := range grams {
+		ids := t.postings[gram]
+		pos := sort.SearchInts(ids, id)
+		if pos < len(ids) && ids[pos] == id {
+			continue
+		}
+		if pos == len(ids) {
+			t.postings[gram] = append(ids, id) // Common while loading.
+			continue
+		}
+		ids = append(ids, 0)
+		copy(ids[pos+1:], ids[pos:])
+		ids[pos] = id
+		t.postings[gram] = ids
+	}
+}
+
+func (t *trigramIndex) remove(id int, grams map[string]bool) {
+	for gram := range grams {
+		ids := t.postings[gram]
+		pos := sort.SearchInts(ids, id)
+		if pos == len(ids) || ids[pos] != id {
+			continue
+		}
+		if len(ids) == 1 {
+			delete(t.postings, gram)
+			continue
+		}
+		t.postings[gram] = append(ids[:pos], ids[pos+1:]...)
+	}
+}
+
+// A set of candidate component IDs. If 'all' is set, the index can't tell
+// and every component has to be considered.
+type candidateSet struct {
+	all bool
+	ids []int // sorted
+}
+
+func intersectCandidates(a, b candidateSet) candidateSet {
+	if a.all {
+		return b
+	}
+	if b.all {
+		return a
+	}
+	result := make([]int, 0, len(a.ids))
+	for i, j := 0, 0; i < len(a.ids) && j < len(b.ids); {
+		switch {
+		case a.ids[i] < b.ids[j]:
+			i++
+		case a.ids[i] > b.ids[j]:
+			j++
+		default:
+			result = append(result, a.ids[i])
+			i++
+			j++
+		}
+	}
+	return candidateSet{ids: result}
+}
+
+func unionCandidates(a, b candidateSet) candidateSet {
+	if a.all || b.all {
+		return candidateSet{all: true}
+	}
+	result := make([]int, 0, len(a.ids)+len(b.ids))
+	i, j := 0, 0
+	for i < len(a.ids) && j < len(b.ids) {
+		switch {
+		case a.ids[i] < b.ids[j]:
+			result = append(result, a.ids[i])
+			i++
+		case a.ids[i] > b.ids[j]:
+			result = append(result, b.ids[j])
+			j++
+		default:
+			result = append(result, a.ids[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a.ids[i:]...)
+	return candidateSet{ids: append(result, b.ids[j:]...)}
+}
+
+// Components that can possibly match a single term: the ones that contain
+// all the trigrams of it or of one of its similar words.
+func (t *trigramIndex) termCandidates(query_term *queryTerm) candidateSet {
+	if query_term.field == "like" {
+		ids := make([]int, 0, len(query_term.similar))
+		for id := range query_term.similar {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		return candidateSet{ids: ids}
+	}
+	if query_term.numeric != nil || query_term.predicate != nil ||
+		query_term.field == "set" || query_term.field == "datasheet" {
+		// A term not matched in the indexed fields.
+		return candidateSet{all: true}
+	}
+	result := t.textCandidates(query_term.text)
+	for _, word := range query_term.fuzzy {
+		result = unionCandidates(result, t.textCandidates(word))
+	}
+	return result
+}
+
+func (t *trigramIndex) textCandidates(term string) candidateSet {
+	if len(term) < kTrigramLen {
+		return candidateSet{all: true} // Too short to know.
+	}
+	result := candidateSet{all: true}
+	for i := 0; i+kTrigramLen <= len(term); i++ {
+		result = intersectCandidates(result,
+			candidateSet{ids: t.postings[term[i:i+kTrigramLen]]})
+		if len(result.ids) == 0 {
+			break
+		}
+	}
+	return result
+}
+
+// Candidates for the query, following the structure of score(): AND
+// being the intersection and OR the union of candidates; negated terms
+// can't narrow down anything.
+func (t *trigramIndex) candidates(n *queryNode) candidateSet {
+	if n == nil {
+		return candidateSet{}
+	}
+	switch n.kind {
+	case kAndNode:
+		result := candidateSet{all: true}
+		for _, child := range n.children {
+			result = intersectCandidates(result, t.candidates(child))
+		}
+		return result
+	case kOrNode:
+		result := candidateSet{}
+		for _, child := range n.children {
+			result = unionCandidates(result, t.candidates(child))
+		}
+		return result
+	case kNotNode:
+		// Anything not containing the operand can match.
+		return candidateSet{all: true}
+	case kGroupNode:
+		return t.candidates(n.children[0])
+	}
+	return t.termCandidates(n.term)
+}
+
+// What we persist of the full text search, so that startup does not need
+// to read and preprocess every component from the database.
+type searchIndexFile struct {
+	Version    int
+	Changes    int64 // Change counter of the database the index is valid for.
+	Components []*Component
+	Postings   map[string][]int
+}
+
+// Write the current state of the search to the given file. The caller has
+// to make sure there are no modifications in the meantime, so that it
+// matches the 'changes' counter of the database.
+func (s *FulltextSearch) Save(filename string, changes int64) error {
+	s.lock.RLock()
+	content := &searchIndexFile{
+		Version:    kSearchIndexVersion,
+		Changes:    changes,
+		Components: make([]*Component, 0, len(s.id2Component)),
+		Postings:   s.index.postings,
+	}
+	for _, c := range s.id2Component {
+		content.Components = append(content.Components, c.orig)
+	}
+	tmpfile, err := os.CreateTemp(filepath.Dir(filename), ".search-index")
+	if err == nil {
+		err = gob.NewEncoder(tmpfile).Encode(content)
+	}
+	s.lock.RUnlock()
+	if err != nil {
+		if tmpfile != nil {
+			tmpfile.Close()
+			_ = os.Remove(tmpfile.Name())
+		}
+		return err
+	}
+	if err = tmpfile.Close(); err != nil {
+		_ = os.Remove(tmpfile.Name())
+		return err
+	}
+	return os.Rename(tmpfile.Name(), filename)
+}
+
+// Load the search state from the given file, but only if it was created
+// for the given database change counter. On error, the search is unchanged.
+func (s *FulltextSearch) Load(filename string, changes int64) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var content searchIndexFile
+	if err = gob.NewDecoder(f).Decode(&content); err != nil {
+		return err
+	}
+	if content.Version != kSearchIndexVersion {
+		return fmt.Errorf("index version %d, expected %d", content.Version, kSearchIndexVersion)
+	}
+	if content.Changes != changes {
+		return fmt.Errorf("index is outdated (changes %d, database at %d)", content.Changes, changes)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.id2Component = make(map[int]*SearchComponent, len(content.Components))
+	s.vocabulary = newVocabulary()
+	s.termDocs = make(map[string]int)
+	for _, c := range content.Components {
+		search_comp := s.newSearchComponent(c)
+		s.id2Component[c.Id] = search_comp
+		s.vocabulary.add(componentWords(search_comp))
+		addTermDocs(s.termDocs, search_comp.terms, 1)
+	}
+	s.index = &trigramIndex{postings: content.Postings}
+	if s.index.postings == nil {
+		s.index.postings = make(map[string][]int)
+	}
+	s.resolveDatasheets()
+	s.cache.invalidate()
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var searchTestWords = []string{
+	"npn", "pnp", "smd", "through-hole", "precision", "low noise", "opamp",
+	"regulator", "ceramic", "electrolytic", "tantalum", "schottky", "zener",
+	"logic", "buffer", "dual", "quad", "high voltage", "#audio", "#power",
+}
+var searchTestFootprints = []string{
+	"TO-92", "TO-220", "DIP-8", "DIP-14", "SOT-23", "0805", "1206", "SOIC-8",
+}
+
+// Deterministic, somewhat realistic looking components.
+func makeSearchTestComponents(count int) []*Component {
+	r := rand.New(rand.NewSource(42))
+	word := func() string { return searchTestWords[r.Intn(len(searchTestWords))] }
+	result := make([]*Component, count)
+	for i := range result {
+		c := &Component{
+			Id:          i + 1,
+			Equiv_set:   i + 1,
+			Category:    available_category[r.Intn(len(available_category))],
+			Footprint:   searchTestFootprints[r.Intn(len(searchTestFootprints))],
+			Description: word() + " " + word(),
+		}
+		switch r.Intn(3) {
+		case 0:
+			c.Value = fmt.Sprintf("%dk", r.Intn(1000))
+		case 1:
+			c.Value = fmt.Sprintf("%dnF", r.Intn(1000))
+		default:
+			c.Value = fmt.Sprintf("LM%d", r.Intn(10000))
+		}
+		if r.Intn(4) == 0 {
+			c.Notes = word()
+		}
+		result[i] = c
+	}
+	return result
+}
+
+func newSearchTestIndex(components []*Component) *FulltextSearch {
+	fts := NewFulltextSearch()
+	for _, c := range components {
+		fts.Update(c)
+	}
+	return fts
+}
+
+var searchTestQueries = []string{
+	"lm31", "100k", "to-220", "resistor 10k", "dual opamp", "dip-8 (opamp|buffer)",
+	"(zener|schottky) sot23", "#audio", "10", "x", "capacitor ceramic | tantalum",
+	"like:42", "((precision resistor) | (low noise)) smd", "nothingmatches",
+	"resistor -smd", "opamp not (dip-8 | #audio)", "!capacitor", "10k !!resistor",
+	"resitor 10k", "capcitor | opamq", "!resitor",
+}
+
+func expectSameResults(t *testing.T, query string, a, b *SearchResult) {
+	if len(a.Results) != len(b.Results) {
+		t.Errorf("%q: %d vs. %d results", query, len(a.Results), len(b.Results))
+		return
+	}
+	for i := range a.Results {
+		if a.Results[i].Id != b.Results[i].Id {
+			t.Errorf("%q: result %d differs", query, i)
+			return
+		}
+	}
+}
+
+func TestIndexedSearchSameAsLinear(t *testing.T) {
+	components := makeSearchTestComponents(2000)
+	indexed := newSearchTestIndex(components)
+	linear := newSearchTestIndex(components)
+	linear.linearScan = true
+
+	check := func() {
+		for _, q := range searchTestQueries {
+			expectSameResults(t, q, indexed.Search(q), linear.Search(q))
+		}
+	}
+	check()
+
+	// Incremental updates are reflected.
+	for _, fts := range []*FulltextSearch{indexed, linear} {
+		fts.Update(&Component{Id: 7, Equiv_set: 7, Value: "nothingmatches"})
+		fts.Remove(8)
+	}
+	ExpectTrue(t, len(indexed.Search("nothingmatches").Results) == 1, "Updated")
+	check()
+}
+
+func TestCandidates(t *testing.T) {
+	index := newTrigramIndex()
+	index.add(1, map[string]bool{"foo": true, "oob": true})
+	index.add(2, map[string]bool{"foo": true})
+	index.add(3, map[string]bool{"bar": true})
+
+	expectIds := func(query string, all bool, expected ...int) {
+		c := index.candidates(compileQuery(query, nil))
+		ExpectTrue(t, c.all == all, query+": all")
+		ExpectTrue(t, fmt.Sprint(c.ids) == fmt.Sprint(expected),
+			fmt.Sprintf("%s: %v vs. %v", query, c.ids, expected))
+	}
+	expectIds("foo", false, 1, 2)
+	expectIds("foob", false, 1)
+	expectIds("foo bar", false)
+	expectIds("foo | bar", false, 1, 2, 3)
+	expectIds("bar | (foob foo)", false, 1, 3)
+	expectIds("fo", true) // Too short to know.
+	expectIds("foo fo", false, 1, 2)
+	expectIds("foo | fo", true)
+	expectIds("set:foo", true)
+	expectIds("!foo", true)
+	expectIds("foo !bar", false, 1, 2)
+	expectIds("foo !(bar | oob) bar", false)
+
+	index.remove(1, map[string]bool{"foo": true, "oob": true})
+	expectIds("foo", false, 2)
+	expectIds("foob", false)
+}
+
+func TestSaveLoadSearchIndex(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "search-index")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "index")
+
+	fts := newSearchTestIndex(makeSearchTestComponents(500))
+	ExpectTrue(t, fts.Save(file, 42) == nil, "Save")
+
+	loaded := NewFulltextSearch()
+	ExpectTrue(t, loaded.Load(file, 43) != nil, "Outdated index not used")
+	ExpectTrue(t, len(loaded.id2Component) == 0, "Nothing loaded")
+	ExpectTrue(t, loaded.Load(file, 42) == nil, "Load")
+	for _, q := range searchTestQueries {
+		expectSameResults(t, q, fts.Search(q), loaded.Search(q))
+	}
+}
+
+func benchmarkSearch(b *testing.B, linear bool) {
+	fts := newSearchTestIndex(makeSearchTestComponents(50000))
+	fts.linearScan = linear
+	fts.cache = newSearchCache(0) // Measure the search, not the cache.
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fts.Search(searchTestQueries[i%len(searchTestQueries)])
+	}
+}
+
+func BenchmarkSearchLinear(b *testing.B)  { benchmarkSearch(b, true) }
+func BenchmarkSearchIndexed(b *testing.B) { benchmarkSearch(b, false) }
@@ -0,0 +1,111 @@
+// Paging through search results. Pages can be requested by offset or
+// with the cursor returned with the previous page; the cursor stays valid
+// while the inventory is edited in between.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type SearchPage struct {
+	Items  []*Component
+	Offset int    // Position of the first item in all results.
+	Total  int    // Number of all results.
+	Next   string // Cursor of the following page. Empty on the last page.
+}
+
+// The cursor names the last component of a page and its position.
+func pageCursor(offset int, c *Component) string {
+	return fmt.Sprintf("%d-%d", offset, c.Id)
+}
+
+// Where the page after the cursor starts. Right after the component of the
+// cursor if it is still in the results, so that components added or
+// removed in front of it don't shift the page. If it is gone, the ones
+// after it moved up to its position.
+func cursorOffset(results []*Component, cursor string) (int, bool) {
+	offset_str, id_str, found := strings.Cut(cursor, "-")
+	if !found {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(offset_str)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(id_str)
+	if err != nil {
+		return 0, false
+	}
+	for i, c := range results {
+		if c.Id == id {
+			return i + 1, true
+		}
+	}
+	return offset, true
+}
+
+// Number of items requested per page with "count": defaultCount if not
+// given, never more than maxCount.
+func pageCount(r *http.Request, defaultCount, maxCount int) int {
+	count, _ := strconv.Atoi(r.FormValue("count"))
+	if count <= 0 {
+		count = defaultCount
+	}
+	if count > maxCount {
+		count = maxCount
+	}
+	return count
+}
+
+// How many of the first results are needed for the requested page; zero
+// if that is only known with all results, as the page is requested with a
+// cursor.
+func pageLimit(r *http.Request, defaultCount, maxCount int) int {
+	if r.FormValue("cursor") != "" {
+		return 0
+	}
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	return offset + pageCount(r, defaultCount, maxCount)
+}
+
+// Get the page of the results requested with the "cursor" or "offset" and
+// "count" parameters. Without count, defaultCount items are returned; never
+// more than maxCount.
+func paginate(results []*Component, r *http.Request, defaultCount, maxCount int) *SearchPage {
+	return paginateTop(results, len(results), r, defaultCount, maxCount)
+}
+
+// Like paginate(), but results are only the first of total results, as
+// many as pageLimit() asked for.
+func paginateTop(results []*Component, total int, r *http.Request, defaultCount, maxCount int) *SearchPage {
+	count := pageCount(r, defaultCount, maxCount)
+	offset, found := cursorOffset(results, r.FormValue("cursor"))
+	if !found {
+		offset, _ = strconv.Atoi(r.FormValue("offset"))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(results) {
+		offset = len(results)
+	}
+	end := offset + count
+	if end > len(results) {
+		end = len(results)
+	}
+	page := &SearchPage{
+		Items:  results[offset:end],
+		Offset: offset,
+		Total:  total,
+	}
+	if end < total && end > 0 {
+		page.Next = pageCursor(end-1, results[end-1])
+	}
+	return page
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func pageIds(page *SearchPage) string {
+	result := ""
+	for _, c := range page.Items {
+		result += fmt.Sprintf("%d ", c.Id)
+	}
+	return result
+}
+
+func TestPaginate(t *testing.T) {
+	results := make([]*Component, 0)
+	for id := 1; id <= 7; id++ {
+		results = append(results, &Component{Id: id})
+	}
+	request := func(params string) *SearchPage {
+		return paginate(results, httptest.NewRequest("GET", "/api/search?"+params, nil), 3, 5)
+	}
+
+	page := request("")
+	expectEqual(t, pageIds(page), "1 2 3 ")
+	ExpectTrue(t, page.Total == 7 && page.Offset == 0, "first page")
+	expectEqual(t, page.Next, "2-3")
+
+	page = request("cursor=" + page.Next)
+	expectEqual(t, pageIds(page), "4 5 6 ")
+	ExpectTrue(t, page.Offset == 3, "second page offset")
+
+	page = request("cursor=" + page.Next)
+	expectEqual(t, pageIds(page), "7 ")
+	expectEqual(t, page.Next, "") // Last page.
+
+	expectEqual(t, pageIds(request("offset=2&count=2")), "3 4 ")
+	expectEqual(t, pageIds(request("count=100")), "1 2 3 4 5 ") // Max count
+	expectEqual(t, pageIds(request("offset=10")), "")
+	expectEqual(t, pageIds(request("offset=-1")), "1 2 3 ")
+	expectEqual(t, pageIds(request("cursor=garbage&offset=1")), "2 3 4 ")
+}
+
+func TestPaginateCursorIsStable(t *testing.T) {
+	results := make([]*Component, 0)
+	for id := 1; id <= 7; id++ {
+		results = append(results, &Component{Id: id})
+	}
+	first := paginate(results, httptest.NewRequest("GET", "/?count=3", nil), 3, 5)
+
+	// A component in front of the cursor disappears: we still continue
+	// right after the last component we have seen.
+	shrunk := append(append([]*Component{}, results[:1]...), results[2:]...)
+	page := paginate(shrunk, httptest.NewRequest("GET", "/?cursor="+first.Next, nil), 3, 5)
+	expectEqual(t, pageIds(page), "4 5 6 ")
+
+	// The last component of the page itself is gone: continue with the
+	// one that took its position.
+	shrunk = append(append([]*Component{}, results[:2]...), results[3:]...)
+	page = paginate(shrunk, httptest.NewRequest("GET", "/?cursor="+first.Next, nil), 3, 5)
+	expectEqual(t, pageIds(page), "4 5 6 ")
+}
@@ -0,0 +1,103 @@
+// Scoring large inventories: the components are split into shards that are
+// scored concurrently, each keeping only the best results if not all of them
+// are needed. The order is the one of ScoreList, so the result is the same
+// as scoring everything in one go.
+package main
+
+import (
+	"container/heap"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Fewer components than that are scored in one go: starting goroutines
+// would cost more than it saves.
+const kMinShardSize = 2000
+
+// Collects the best scored components; all of them if the limit is zero.
+type topScores struct {
+	list  ScoreList // With a limit: heap, the worst result on top.
+	limit int
+	count int // Number of all components added.
+}
+
+func newTopScores(limit int) *topScores {
+	return &topScores{list: make(ScoreList, 0, 10), limit: limit}
+}
+
+// heap.Interface, ordered so that the worst result is first.
+func (t *topScores) Len() int           { return len(t.list) }
+func (t *topScores) Swap(i, j int)      { t.list.Swap(i, j) }
+func (t *topScores) Less(a, b int) bool { return t.list.Less(b, a) }
+func (t *topScores) Push(x interface{}) { t.list = append(t.list, x.(*ScoredComponent)) }
+func (t *topScores) Pop() interface{} {
+	last := t.list[len(t.list)-1]
+	t.list = t.list[:len(t.list)-1]
+	return last
+}
+
+func (t *topScores) add(c *ScoredComponent) {
+	t.count++
+	switch {
+	case t.limit <= 0:
+		t.list = append(t.list, c)
+	case len(t.list) < t.limit:
+		heap.Push(t, c)
+	case ScoreList([]*ScoredComponent{c, t.list[0]}).Less(0, 1):
+		t.list[0] = c // Better than the worst one.
+		heap.Fix(t, 0)
+	}
+}
+
+// The collected components, best first.
+func (t *topScores) sorted() ScoreList {
+	sort.Sort(t.list)
+	return t.list
+}
+
+// Score the components, in parallel if there are enough of them. The score
+// function returns nil for the ones that don't match. Returns the best
+// limit results (all with limit 0), ordered, and the number of all matches.
+func scoreShards(components []*SearchComponent, limit int,
+	score func(c *SearchComponent) *ScoredComponent) (ScoreList, int) {
+	shards := runtime.GOMAXPROCS(0)
+	if max_shards := len(components) / kMinShardSize; shards > max_shards {
+		shards = max_shards
+	}
+	if shards <= 1 {
+		result := newTopScores(limit)
+		for _, c := range components {
+			if scored := score(c); scored != nil {
+				result.add(scored)
+			}
+		}
+		return result.sorted(), result.count
+	}
+
+	shard_results := make([]*topScores, shards)
+	var wg sync.WaitGroup
+	for i := 0; i < shards; i++ {
+		shard_results[i] = newTopScores(limit)
+		wg.Add(1)
+		go func(shard *topScores, part []*SearchComponent) {
+			defer wg.Done()
+			for _, c := range part {
+				if scored := score(c); scored != nil {
+					shard.add(scored)
+				}
+			}
+		}(shard_results[i], components[i*len(components)/shards:(i+1)*len(components)/shards])
+	}
+	wg.Wait()
+
+	result := newTopScores(limit)
+	count := 0
+	for _, shard := range shard_results {
+		count += shard.count
+		for _, scored := range shard.list {
+			result.add(scored)
+		}
+	}
+	return result.sorted(), count
+}
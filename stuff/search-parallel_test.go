@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestTopScores(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	all := make(ScoreList, 0)
+	for id := 1; id <= 200; id++ {
+		all = append(all, &ScoredComponent{
+			score: float32(r.Intn(10)), // Plenty of ties.
+			comp:  &Component{Id: id, Value: fmt.Sprintf("%d", r.Intn(3))},
+		})
+	}
+	expected := append(ScoreList{}, all...)
+	sort.Sort(expected)
+	for _, limit := range []int{0, 1, 7, 200, 300} {
+		top := newTopScores(limit)
+		for _, c := range all {
+			top.add(c)
+		}
+		ExpectTrue(t, top.count == len(all), "All counted")
+		want := expected
+		if limit > 0 && limit < len(want) {
+			want = want[:limit]
+		}
+		ExpectTrue(t, fmt.Sprint(top.sorted()) == fmt.Sprint(want),
+			fmt.Sprintf("limit %d: same as sorting all", limit))
+	}
+}
+
+func TestParallelSearchSameAsSequential(t *testing.T) {
+	fts := newSearchTestIndex(makeSearchTestComponents(5 * kMinShardSize))
+	fts.cache = newSearchCache(0)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	sequential := make(map[string]*SearchResult)
+	for _, q := range searchTestQueries {
+		sequential[q] = fts.Search(q)
+	}
+	runtime.GOMAXPROCS(4)
+	for _, q := range searchTestQueries {
+		expectSameResults(t, q, sequential[q], fts.Search(q))
+
+		top := fts.SearchTop(q, 10)
+		ExpectTrue(t, top.Total == len(sequential[q].Results), q+": total")
+		want := sequential[q].Results
+		if len(want) > 10 {
+			want = want[:10]
+		}
+		expectSameResults(t, q, &SearchResult{Results: want}, top)
+	}
+}
+
+// A large inventory, searched for all results or only the first page. Run
+// with e.g. -cpu 1,4 to compare sequential and parallel scoring.
+func benchmarkLargeSearch(b *testing.B, limit int) {
+	fts := newSearchTestIndex(makeSearchTestComponents(100000))
+	fts.cache = newSearchCache(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fts.SearchTop(searchTestQueries[i%len(searchTestQueries)], limit)
+	}
+}
+
+func BenchmarkSearch100k(b *testing.B)    { benchmarkLargeSearch(b, 0) }
+func BenchmarkSearchTop100k(b *testing.B) { benchmarkLargeSearch(b, 20) }
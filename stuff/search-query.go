@@ -0,0 +1,534 @@
+// Parsing search queries: a tokenizer and a parser that builds the syntax
+// tree the components are scored with. The syntax is forgiving, as the
+// query is evaluated while it is typed: syntax errors are reported with
+// their position, but the parser recovers from them and evaluates what
+// makes sense.
+//
+//	query   := and ( ("|" | "OR") and )*
+//	and     := unary ( ["AND"] unary )*
+//	unary   := ("!" | "-" | "NOT") unary | "(" query ")" | term
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	possibleResistor = regexp.MustCompile(`(?i)^([0-9][0-9.,]*[kMR]?[0-9]*)(\s*(?:Ohms?|Oh|Ω))$`)
+	ohmUnit          = regexp.MustCompile(`(?i)^(Ohms?|Oh|Ω)$`)
+)
+
+// The synonym that stands for the categories of resistors; without one,
+// these are used.
+const kResistorAlias = "ohm"
+
+var kResistorCategories = []string{"resistor", "potentiometer", "r-network"}
+
+type tokenKind int
+
+const (
+	kWordToken tokenKind = iota
+	kOpenToken
+	kCloseToken
+	kOrToken
+	kAndToken
+	kNotToken
+)
+
+type queryToken struct {
+	kind   tokenKind
+	text   string // Without quotes.
+	source string // As written in the query.
+	pos    int    // Position in the query, in characters.
+	start  int    // Byte offsets in the query.
+	end    int
+}
+
+// A syntax error in a query.
+type QueryError struct {
+	Pos     int    `json:"pos"` // Position in the query, in characters.
+	Message string `json:"message"`
+}
+
+type nodeKind int
+
+const (
+	kTermNode nodeKind = iota
+	kAndNode
+	kOrNode
+	kNotNode
+	kGroupNode // Parenthesized, only kept to print the query as written.
+)
+
+// A node of the syntax tree of a query.
+type queryNode struct {
+	kind     nodeKind
+	children []*queryNode
+
+	// For terms.
+	text   string     // Without quotes.
+	source string     // As written in the query, if it comes from there.
+	term   *queryTerm // Set by compile().
+
+	substituteFor string // Part this is a substitute for, see substitutes.go
+}
+
+func newNode(kind nodeKind, children ...*queryNode) *queryNode {
+	return &queryNode{kind: kind, children: children}
+}
+
+func newTermNode(text string) *queryNode {
+	return &queryNode{kind: kTermNode, text: text}
+}
+
+// Split the query into tokens at whitespace and around the operators
+// ( ) | and the ! at the beginning of a word. Double quotes keep things
+// together, e.g. category:"ic analog". A dash at the beginning of a word
+// negates it as well, unless followed by a number such as in -5V. The
+// words AND, OR and NOT are operators if they are separate words.
+func tokenizeQuery(query string) ([]queryToken, []QueryError) {
+	result := make([]queryToken, 0)
+	errors := make([]QueryError, 0)
+	var current strings.Builder
+	in_word, in_quote := false, false
+	word_start, word_pos, quote_pos := 0, 0, 0
+	flush := func(end int) {
+		if in_word && current.Len() > 0 {
+			result = append(result, queryToken{
+				kind:   kWordToken,
+				text:   current.String(),
+				source: query[word_start:end],
+				pos:    word_pos,
+				start:  word_start,
+				end:    end,
+			})
+		}
+		in_word = false
+		current.Reset()
+	}
+	operator := func(kind tokenKind, i, pos int, r rune) {
+		end := i + utf8.RuneLen(r)
+		result = append(result, queryToken{kind: kind, text: query[i:end],
+			source: query[i:end], pos: pos, start: i, end: end})
+	}
+	pos := 0
+	for i, r := range query {
+		if !in_word && !unicode.IsSpace(r) && !strings.ContainsRune("()|!", r) &&
+			!(r == '-' && isNegatingDash(query[i+1:])) {
+			in_word, word_start, word_pos = true, i, pos
+		}
+		switch {
+		case r == '"':
+			if !in_quote {
+				quote_pos = pos
+			}
+			in_quote = !in_quote
+		case in_quote:
+			current.WriteRune(r)
+		case unicode.IsSpace(r):
+			flush(i)
+		case r == '(':
+			flush(i)
+			operator(kOpenToken, i, pos, r)
+		case r == ')':
+			flush(i)
+			operator(kCloseToken, i, pos, r)
+		case r == '|':
+			flush(i)
+			operator(kOrToken, i, pos, r)
+		case !in_word && (r == '!' || r == '-'):
+			operator(kNotToken, i, pos, r)
+		default:
+			current.WriteRune(r)
+		}
+		pos++
+	}
+	flush(len(query))
+	if in_quote {
+		errors = append(errors, QueryError{Pos: quote_pos, Message: "missing closing quote"})
+	}
+
+	// Keywords, only if they are standing alone.
+	for i := range result {
+		t := &result[i]
+		if t.kind != kWordToken || t.source != t.text {
+			continue // Quoted words are never keywords.
+		}
+		before, after := " ", " "
+		if t.start > 0 {
+			before = query[t.start-1 : t.start]
+		}
+		if t.end < len(query) {
+			after = query[t.end : t.end+1]
+		} else {
+			after = ""
+		}
+		switch strings.ToLower(t.text) {
+		case "and":
+			if isSpace(before) && isSpace(after) && t.start > 0 {
+				t.kind = kAndToken
+			}
+		case "or":
+			if isSpace(before) && isSpace(after) && t.start > 0 {
+				t.kind = kOrToken
+			}
+		case "not":
+			if (isSpace(before) || before == "(" || before == "|") &&
+				(isSpace(after) || after == "(") {
+				t.kind = kNotToken
+			}
+		}
+	}
+	return result, errors
+}
+
+func isSpace(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return s != "" && unicode.IsSpace(r)
+}
+
+// A dash at the beginning of a word is a negation, unless it is the sign
+// of a number or there is nothing to negate.
+func isNegatingDash(rest string) bool {
+	next, _ := utf8.DecodeRuneInString(rest)
+	return rest != "" && !unicode.IsSpace(next) && !unicode.IsDigit(next) &&
+		next != '.' && next != '-'
+}
+
+type queryParser struct {
+	tokens []queryToken
+	next   int
+	errors []QueryError
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.next >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.next]
+}
+
+func (p *queryParser) error(pos int, format string, args ...interface{}) {
+	p.errors = append(p.errors, QueryError{Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+// Parse alternatives up to the end of the query, or of the parenthesized
+// expression if nested.
+func (p *queryParser) parseOr(nested bool) *queryNode {
+	alternatives := make([]*queryNode, 0)
+	var last_or *queryToken
+	for {
+		and := p.parseAnd(nested)
+		if and != nil {
+			alternatives = append(alternatives, and)
+		}
+		t := p.peek()
+		if t == nil || t.kind != kOrToken {
+			if and == nil && last_or != nil {
+				p.error(last_or.pos, "missing term after '%s'", last_or.source)
+			}
+			break
+		}
+		if and == nil {
+			p.error(t.pos, "missing term before '%s'", t.source)
+		}
+		last_or = t
+		p.next++
+	}
+	switch len(alternatives) {
+	case 0:
+		return nil
+	case 1:
+		return alternatives[0]
+	}
+	return newNode(kOrNode, alternatives...)
+}
+
+func (p *queryParser) parseAnd(nested bool) *queryNode {
+	operands := make([]*queryNode, 0)
+	for t := p.peek(); t != nil; t = p.peek() {
+		if t.kind == kOrToken || (t.kind == kCloseToken && nested) {
+			break
+		}
+		if t.kind == kCloseToken {
+			p.error(t.pos, "unmatched ')'")
+			p.next++
+			continue
+		}
+		if t.kind == kAndToken {
+			p.next++
+			continue
+		}
+		if operand := p.parseUnary(); operand != nil {
+			operands = append(operands, operand)
+		}
+	}
+	switch len(operands) {
+	case 0:
+		return nil
+	case 1:
+		return operands[0]
+	}
+	return newNode(kAndNode, operands...)
+}
+
+func (p *queryParser) parseUnary() *queryNode {
+	t := p.peek()
+	if t == nil || t.kind == kOrToken || t.kind == kCloseToken {
+		return nil
+	}
+	p.next++
+	switch t.kind {
+	case kNotToken:
+		operand := p.parseUnary()
+		if operand == nil {
+			p.error(t.pos, "missing term after '%s'", t.source)
+			return nil
+		}
+		return newNode(kNotNode, operand)
+	case kOpenToken:
+		inner := p.parseOr(true)
+		if closing := p.peek(); closing != nil && closing.kind == kCloseToken {
+			p.next++
+			if inner == nil {
+				p.error(t.pos, "empty parentheses")
+			}
+		} else {
+			p.error(t.pos, "missing ')'")
+		}
+		if inner == nil {
+			return nil
+		}
+		return newNode(kGroupNode, inner)
+	case kAndToken:
+		return p.parseUnary()
+	}
+	return &queryNode{kind: kTermNode, text: t.text, source: t.source}
+}
+
+// Parse the query into its syntax tree, nil if there is nothing to search
+// for. Also returns the syntax errors, if any.
+func parseQuery(query string) (*queryNode, []QueryError) {
+	tokens, errors := tokenizeQuery(query)
+	p := &queryParser{tokens: tokens, errors: errors}
+	return p.parseOr(false), p.errors
+}
+
+// Parse the query and prepare its terms for scoring, see compile().
+func compileQuery(query string, has_image func(id int) bool) *queryNode {
+	root, _ := parseQuery(query)
+	root.compile(has_image)
+	return root
+}
+
+// The query as text; the terms as they were written, the operators
+// normalized.
+func (n *queryNode) String() string {
+	if n == nil {
+		return ""
+	}
+	parts := make([]string, len(n.children))
+	for i, child := range n.children {
+		parts[i] = child.String()
+	}
+	switch n.kind {
+	case kAndNode:
+		return strings.Join(parts, " ")
+	case kOrNode:
+		return strings.Join(parts, " | ")
+	case kNotNode:
+		return "!" + parts[0]
+	case kGroupNode:
+		return "(" + parts[0] + ")"
+	}
+	if n.source == "" && strings.ContainsAny(n.text, " \t") {
+		return `"` + n.text + `"` // Expanded phrase.
+	}
+	if n.source != "" {
+		return n.source
+	}
+	return n.text
+}
+
+// Set the queryTerm of all the terms: numeric comparisons are kept as they
+// are, as case matters for their SI prefix (m vs. M); all other terms are
+// preprocessed like the component fields. The has_image function is used
+// to look up if there is an image for has:image.
+func (n *queryNode) compile(has_image func(id int) bool) {
+	if n == nil {
+		return
+	}
+	for _, child := range n.children {
+		child.compile(has_image)
+	}
+	if n.kind == kTermNode {
+		n.term = parseQueryTerm(n.text, has_image)
+	}
+}
+
+// All the terms of the query, including negated ones.
+func (n *queryNode) terms() []*queryTerm {
+	result := make([]*queryTerm, 0)
+	var collect func(n *queryNode)
+	collect = func(n *queryNode) {
+		if n == nil {
+			return
+		}
+		if n.term != nil {
+			result = append(result, n.term)
+		}
+		for _, child := range n.children {
+			collect(child)
+		}
+	}
+	collect(n)
+	return result
+}
+
+// Expand the terms of the query that are likely meant to match more than
+// what they say:
+//   - Resistor values given with unit, e.g. 10kOhm: we store resistors
+//     without unit, so also look for the number in resistor categories.
+//   - Values written differently than they are stored, e.g. 4k7 for 4.7k.
+//     Also, nanofarad values are often given as 0.something microfarad.
+//     Internally, all capacitors are normalized to nanofarad.
+//   - Words and phrases that have synonyms, e.g. elko = aluminum cap.
+func expandQuery(n *queryNode, synonyms *SynonymDictionary) *queryNode {
+	if n == nil {
+		return nil
+	}
+	if n.kind == kTermNode {
+		return expandTerm(n, synonyms)
+	}
+	children := make([]*queryNode, 0, len(n.children))
+	for i := 0; i < len(n.children); i++ {
+		child := n.children[i]
+		if n.kind == kAndNode {
+			if expanded, words := expandPhrase(n.children[i:], synonyms); expanded != nil {
+				children = append(children, expanded)
+				i += words - 1
+				continue
+			}
+		}
+		if n.kind == kAndNode && i+1 < len(n.children) {
+			// Number and unit written as separate words.
+			unit := n.children[i+1]
+			if child.kind == kTermNode && unit.kind == kTermNode &&
+				ohmUnit.MatchString(unit.text) {
+				if expanded := expandResistor(newNode(kAndNode, child, unit),
+					child.text+unit.text, synonyms); expanded != nil {
+					children = append(children, expanded)
+					i++
+					continue
+				}
+			}
+		}
+		children = append(children, expandQuery(child, synonyms))
+	}
+	return newNode(n.kind, children...)
+}
+
+func expandTerm(n *queryNode, synonyms *SynonymDictionary) *queryNode {
+	if parseNumericTerm(n.text) != nil {
+		return n // Comparisons are taken as they are.
+	}
+	if isPlainTerm(n) {
+		if alternatives := synonyms.Synonyms(n.text); alternatives != nil {
+			return synonymGroup(n, alternatives)
+		}
+	}
+	if expanded := expandResistor(n, n.text, synonyms); expanded != nil {
+		return expanded
+	}
+	return expandValue(n)
+}
+
+// A term that is just text, no qualifier or comparison.
+func isPlainTerm(n *queryNode) bool {
+	return n.kind == kTermNode && !strings.Contains(n.text, ":") &&
+		parseNumericTerm(n.text) == nil
+}
+
+// Expand the longest phrase of words at the beginning of the given AND
+// operands that has synonyms. Returns the expansion and the number of
+// words, nil if there is none.
+func expandPhrase(operands []*queryNode, synonyms *SynonymDictionary) (*queryNode, int) {
+	words := 0
+	for words < len(operands) && words < kMaxSynonymWords && isPlainTerm(operands[words]) {
+		words++
+	}
+	for ; words >= 2; words-- {
+		texts := make([]string, words)
+		for i := range texts {
+			texts[i] = operands[i].text
+		}
+		if alternatives := synonyms.Synonyms(strings.Join(texts, " ")); alternatives != nil {
+			return synonymGroup(newNode(kAndNode, operands[:words]...), alternatives), words
+		}
+	}
+	return nil, 0
+}
+
+// (original | synonym1 | synonym2 ...)
+func synonymGroup(original *queryNode, synonyms []string) *queryNode {
+	alternatives := []*queryNode{original}
+	for _, synonym := range synonyms {
+		alternatives = append(alternatives, newTermNode(synonym))
+	}
+	return newNode(kGroupNode, newNode(kOrNode, alternatives...))
+}
+
+// Expand a resistor value with unit to (original | (number (resistor...))),
+// nil if it is not one. The categories resistors are stored in are what the
+// synonym "ohm" stands for, if the synonym file has it.
+func expandResistor(original *queryNode, text string, synonyms *SynonymDictionary) *queryNode {
+	match := possibleResistor.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+	category_names := synonyms.Synonyms(kResistorAlias)
+	if category_names == nil {
+		category_names = kResistorCategories
+	}
+	if _, ok := parseValue(match[1]); !ok {
+		return nil
+	}
+	if original.kind == kTermNode {
+		original = expandValue(original)
+	} else {
+		original = newNode(original.kind, expandValue(original.children[0]),
+			original.children[1])
+	}
+	number := expandValue(newTermNode(match[1]))
+	categories := make([]*queryNode, len(category_names))
+	for i, name := range category_names {
+		categories[i] = newTermNode(name)
+	}
+	return newNode(kGroupNode, newNode(kOrNode, original,
+		newNode(kGroupNode, newNode(kAndNode, number,
+			newNode(kGroupNode, newNode(kOrNode, categories...))))))
+}
+
+// Expand a value with SI prefix to (original | canonical), if the canonical
+// way to write it is different.
+func expandValue(n *queryNode) *queryNode {
+	value, ok := parseValue(n.text)
+	if !ok || strings.IndexFunc(n.text, unicode.IsLetter) < 0 {
+		return n // Plain numbers are left alone.
+	}
+	canonical := formatValue(value.number, value.unit, kAllPrefixes, value.digits)
+	if strings.EqualFold(canonical, n.text) {
+		return n
+	}
+	return newNode(kGroupNode, newNode(kOrNode, n, newTermNode(canonical)))
+}
+
+// Expand the query, see expandQuery(), and return it as text.
+func queryRewrite(query string) string {
+	root, _ := parseQuery(query)
+	return expandQuery(root, nil).String()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The tree with all groups explicit, to see the precedence.
+func treeString(n *queryNode) string {
+	if n == nil {
+		return "<nil>"
+	}
+	parts := make([]string, len(n.children))
+	for i, child := range n.children {
+		parts[i] = treeString(child)
+	}
+	switch n.kind {
+	case kAndNode:
+		return "and(" + strings.Join(parts, ",") + ")"
+	case kOrNode:
+		return "or(" + strings.Join(parts, ",") + ")"
+	case kNotNode:
+		return "not(" + parts[0] + ")"
+	case kGroupNode:
+		return parts[0]
+	}
+	return n.text
+}
+
+func errorsString(errors []QueryError) string {
+	result := make([]string, len(errors))
+	for i, e := range errors {
+		result[i] = fmt.Sprintf("%d:%s", e.Pos, e.Message)
+	}
+	return strings.Join(result, "; ")
+}
+
+func TestParseQuery(t *testing.T) {
+	expectTree := func(query, expected string) {
+		root, errors := parseQuery(query)
+		expectEqual(t, treeString(root), expected)
+		expectEqual(t, errorsString(errors), "")
+	}
+	expectTree("foo", "foo")
+	expectTree("", "<nil>")
+	expectTree("foo bar | baz", "or(and(foo,bar),baz)")
+	expectTree("foo (bar | baz)", "and(foo,or(bar,baz))")
+	expectTree("!foo bar", "and(not(foo),bar)")
+	expectTree("-foo NOT bar", "and(not(foo),not(bar))")
+	expectTree("not(a|b)", "not(or(a,b))")
+	expectTree("a AND b OR c and d", "or(and(a,b),and(c,d))")
+	expectTree(`"foo bar" category:"ic analog"`, "and(foo bar,category:ic analog)")
+
+	// Keywords only as separate words, quoted never.
+	expectTree("AND", "AND")
+	expectTree("nothing or", "and(nothing,or)")
+	expectTree(`cable "or" wire`, "and(cable,or,wire)")
+	expectTree("(a|and|b)", "or(a,and,b)")
+
+	// Dashes that are not a negation.
+	expectTree("lm7905 -5V", "and(lm7905,-5V)")
+	expectTree("to-220 -.5", "and(to-220,-.5)")
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	expectErrors := func(query, tree, expected string) {
+		root, errors := parseQuery(query)
+		expectEqual(t, treeString(root), tree)
+		expectEqual(t, errorsString(errors), expected)
+	}
+	expectErrors("(foo|bar", "or(foo,bar)", "0:missing ')'")
+	expectErrors("foo|bar)", "or(foo,bar)", "7:unmatched ')'")
+	expectErrors("foo |", "foo", "4:missing term after '|'")
+	expectErrors("| foo", "foo", "0:missing term before '|'")
+	expectErrors("a || b", "or(a,b)", "3:missing term before '|'")
+	expectErrors("foo ()", "foo", "4:empty parentheses")
+	expectErrors("foo !", "foo", "4:missing term after '!'")
+	expectErrors(`µF "ic analog`, "and(µF,ic analog)", "3:missing closing quote")
+	expectErrors("((a) b", "and(a,b)", "0:missing ')'")
+}
+
+func TestQueryString(t *testing.T) {
+	expectString := func(query, expected string) {
+		root, _ := parseQuery(query)
+		expectEqual(t, root.String(), expected)
+	}
+	expectString("foo  bar", "foo bar")
+	expectString("(foo|bar)", "(foo | bar)")
+	expectString(`category:"ic analog" -smd`, `category:"ic analog" !smd`)
+	expectString("a OR b AND NOT c", "a | b !c")
+	expectString("(a", "(a)") // As understood
+}
+
+func TestMinusSign(t *testing.T) {
+	regulator := NewFulltextSearch().newSearchComponent(&Component{
+		Category:    "Voltage regulator",
+		Value:       "LM7905",
+		Description: "Negative regulator, -5V, TO-220",
+	})
+	ExpectTrue(t, regulator.MatchScore("-5v") > 0, "With minus")
+	ExpectTrue(t, regulator.MatchScore("to220") > 0, "Joining dash ignored")
+	ExpectTrue(t, regulator.MatchScore("to-220") > 0, "Joining dash in query")
+
+	positive := NewFulltextSearch().newSearchComponent(&Component{
+		Value:       "LM7805",
+		Description: "Regulator 5V",
+	})
+	ExpectTrue(t, positive.MatchScore("-5v") == 0, "Sign matters")
+	ExpectTrue(t, positive.MatchScore("regulator -lm7905") > 0, "Negation")
+}
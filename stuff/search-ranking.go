@@ -0,0 +1,146 @@
+// Ranking of the search results beyond the text score: what is in stock and
+// well documented comes first. Also: the other orders the results can be
+// sorted by.
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// How much the availability of a component changes its score. Each weight
+// is the fraction the score is changed by at most, so 0 switches it off.
+type RankingWeights struct {
+	Stock    float32 // Well stocked bins up, out of stock ones down.
+	Status   float32 // Bins marked empty (or, half of it, mystery) down.
+	Image    float32 // Components with a photo up.
+	Complete float32 // Records without description or value down.
+}
+
+var kDefaultRankingWeights = RankingWeights{
+	Stock:    0.2,
+	Status:   0.5,
+	Image:    0.1,
+	Complete: 0.1,
+}
+
+// Parse weights given as e.g. "stock=0.3,image=0". Weights not mentioned
+// keep their default.
+func parseRankingWeights(spec string) (RankingWeights, error) {
+	result := kDefaultRankingWeights
+	for _, setting := range strings.Split(spec, ",") {
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(setting, "=")
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 32)
+		if !found || err != nil || weight < 0 || weight > 1 {
+			return result, fmt.Errorf("%q: need name=weight with weight between 0 and 1", setting)
+		}
+		switch strings.TrimSpace(name) {
+		case "stock":
+			result.Stock = float32(weight)
+		case "status":
+			result.Status = float32(weight)
+		case "image":
+			result.Image = float32(weight)
+		case "complete":
+			result.Complete = float32(weight)
+		default:
+			return result, fmt.Errorf("unknown ranking weight %q", name)
+		}
+	}
+	return result, nil
+}
+
+// Factor to multiply the text score of the component with.
+func (w RankingWeights) factor(c *Component, has_image bool) float32 {
+	var result float32 = 1.0
+	switch componentStatus(c) {
+	case "empty":
+		result -= w.Status
+	case "mystery":
+		result -= w.Status / 2
+	case "fair":
+		result -= w.Complete / 2
+	case "poor", "missing":
+		result -= w.Complete
+	}
+	if quantity, ok := parseQuantity(c.Quantity); ok {
+		if quantity == 0 {
+			result -= w.Stock
+		} else {
+			// Full weight from a thousand on.
+			result += w.Stock * float32(math.Min(1, math.Log10(float64(quantity))/3))
+		}
+	}
+	if has_image {
+		result += w.Image
+	}
+	if result < 0.05 {
+		result = 0.05 // Still found, if that's all there is.
+	}
+	return result
+}
+
+// The orders search results can be sorted by, the first is the default.
+var kSortOrders = []string{"relevance", "value", "id", "quantity", "updated"}
+
+// Sort the results, ordered by relevance, in the given order. Components
+// that are the same in that order stay ordered by relevance.
+func sortResults(results []*Component, order string) error {
+	switch order {
+	case "", "relevance":
+		return nil
+	case "value":
+		values := make(map[int]*physicalValue)
+		for _, c := range results {
+			if found := componentValues(c); len(found) > 0 && found[0].main {
+				values[c.Id] = &found[0]
+			}
+		}
+		sort.SliceStable(results, func(a, b int) bool {
+			va, vb := values[results[a].Id], values[results[b].Id]
+			switch {
+			case va == nil || vb == nil:
+				if va != nil || vb != nil {
+					return va != nil // Numeric values first.
+				}
+				return strings.ToLower(results[a].Value) < strings.ToLower(results[b].Value)
+			case va.unit != vb.unit:
+				return va.unit < vb.unit
+			default:
+				return va.number < vb.number
+			}
+		})
+	case "id":
+		sort.SliceStable(results, func(a, b int) bool {
+			return results[a].Id < results[b].Id
+		})
+	case "quantity":
+		quantity := func(c *Component) int {
+			if q, ok := parseQuantity(c.Quantity); ok {
+				return q
+			}
+			return -1 // Unknown last.
+		}
+		sort.SliceStable(results, func(a, b int) bool {
+			return quantity(results[a]) > quantity(results[b])
+		})
+	case "updated":
+		sort.SliceStable(results, func(a, b int) bool {
+			ta, tb := lastChange(results[a]), lastChange(results[b])
+			if ta == nil || tb == nil {
+				return ta != nil
+			}
+			return ta.After(*tb)
+		})
+	default:
+		return fmt.Errorf("unknown sort order %q, need one of %s", order,
+			strings.Join(kSortOrders, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseRankingWeights(t *testing.T) {
+	weights, err := parseRankingWeights("")
+	ExpectTrue(t, err == nil && weights == kDefaultRankingWeights, "Default")
+	weights, err = parseRankingWeights("stock=0.5, image=0")
+	ExpectTrue(t, err == nil, "Valid")
+	ExpectTrue(t, weights.Stock == 0.5 && weights.Image == 0, "Set")
+	ExpectTrue(t, weights.Status == kDefaultRankingWeights.Status, "Others default")
+
+	_, err = parseRankingWeights("stock")
+	ExpectTrue(t, err != nil, "No value")
+	_, err = parseRankingWeights("stock=2")
+	ExpectTrue(t, err != nil, "Out of range")
+	_, err = parseRankingWeights("price=0.1")
+	ExpectTrue(t, err != nil, "Unknown")
+}
+
+func TestComponentStatus(t *testing.T) {
+	expectEqual(t, componentStatus(nil), "missing")
+	expectEqual(t, componentStatus(&Component{Category: "Resistor", Value: "10k"}), "good")
+	expectEqual(t, componentStatus(&Component{Category: "IC", Value: "LM358"}), "fair")
+	expectEqual(t, componentStatus(&Component{Value: "LM358"}), "poor")
+	expectEqual(t, componentStatus(&Component{Category: "IC", Value: "empty"}), "empty")
+	expectEqual(t, componentStatus(&Component{Category: "Mystery", Value: "empty"}), "mystery")
+}
+
+func TestAvailabilityRanking(t *testing.T) {
+	fts := NewFulltextSearch()
+	fts.Update(&Component{Id: 1, Equiv_set: 1, Category: "IC", Value: "LM358", Description: "Op-amp", Quantity: "0"})
+	fts.Update(&Component{Id: 2, Equiv_set: 2, Category: "IC", Value: "LM358", Description: "Op-amp"})
+	fts.Update(&Component{Id: 3, Equiv_set: 3, Category: "IC", Value: "LM358", Description: "Op-amp", Quantity: "~100"})
+	fts.Update(&Component{Id: 4, Equiv_set: 4, Category: "IC", Value: "LM358"})
+	fts.SetImageChecker(func(id int) bool { return id == 4 })
+	ids := func() []int {
+		result := make([]int, 0)
+		for _, c := range fts.Search("lm358").Results {
+			result = append(result, c.Id)
+		}
+		return result
+	}
+	ExpectTrue(t, fmt.Sprint(ids()) == "[3 4 2 1]", "Stocked, image, unknown, empty")
+
+	fts.SetRankingWeights(RankingWeights{})
+	ExpectTrue(t, fmt.Sprint(ids()) == "[1 2 3 4]", "Text score only")
+}
+
+func TestSortResults(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	results := []*Component{
+		{Id: 3, Category: "Resistor", Value: "4.7k", Quantity: "10", Updated: &earlier},
+		{Id: 1, Category: "Resistor", Value: "10k", Quantity: "many"},
+		{Id: 2, Category: "Resistor", Value: "100", Quantity: "100", Updated: &now},
+		{Id: 4, Category: "Resistor", Value: "assorted"},
+	}
+	order := func(sorting string) string {
+		ExpectTrue(t, sortResults(results, sorting) == nil, sorting)
+		return fmt.Sprint(results[0].Id, results[1].Id, results[2].Id, results[3].Id)
+	}
+	expectEqual(t, order("relevance"), "3 1 2 4")
+	expectEqual(t, order("value"), "2 3 1 4")
+	expectEqual(t, order("id"), "1 2 3 4")
+	expectEqual(t, order("quantity"), "2 3 1 4")
+	expectEqual(t, order("updated"), "2 3 1 4")
+	ExpectTrue(t, sortResults(results, "price") != nil, "Unknown order")
+}
@@ -0,0 +1,191 @@
+// Similarity search: components like a given one, for like:42 and
+// /api/similar. Words are weighted by the field they are in and by how rare
+// they are (TF-IDF), so that common words such as "resistor" matter less
+// than a part number; components with close values score higher.
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// Fraction of the similarity that comes from how close the values
+	// are; the rest is from the words.
+	kSimilarValueWeight = 0.3
+
+	// Less similar components are not considered similar at all.
+	kMinSimilarity = 0.05
+
+	// Score of a like: term for a component with similarity 1.0
+	kSimilarScore = 10.0
+)
+
+// A component with its similarity to another one, 0..1
+type SimilarComponent struct {
+	Component  *Component
+	Similarity float32
+}
+
+// A word of a component, with the weight of the most important field it
+// appears in.
+type weightedWord struct {
+	word   string
+	weight float32
+}
+
+// The words of the preprocessed fields of a component, sorted, so that
+// adding up their weights always gives the same result. Numbers such as
+// 3.9k are kept as one word.
+func componentTermWeights(c *Component) []weightedWord {
+	weights := make(map[string]float32)
+	for _, field := range []struct {
+		text   string
+		weight float32
+	}{
+		{c.Value, 3.0},
+		{c.Category, 2.0},
+		{c.Description, 1.5},
+		{c.Notes, 1.2},
+		{c.Footprint, 1.0},
+	} {
+		for _, word := range strings.FieldsFunc(field.text, func(r rune) bool {
+			return !isWordRune(r) && r != '.'
+		}) {
+			word = strings.Trim(word, ".")
+			if word != "" && field.weight > weights[word] {
+				weights[word] = field.weight
+			}
+		}
+	}
+	result := make([]weightedWord, 0, len(weights))
+	for word, weight := range weights {
+		result = append(result, weightedWord{word, weight})
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].word < result[b].word
+	})
+	return result
+}
+
+// Count the components the words are in.
+func addTermDocs(docs map[string]int, terms []weightedWord, delta int) {
+	for _, term := range terms {
+		if docs[term.word]+delta <= 0 {
+			delete(docs, term.word)
+		} else {
+			docs[term.word] += delta
+		}
+	}
+}
+
+// How close the main values of the components are, 0..1. Zero if they are
+// not comparable.
+func valueCloseness(a, b []physicalValue) float32 {
+	for _, va := range a {
+		for _, vb := range b {
+			if !va.main || !vb.main || va.unit != vb.unit {
+				continue
+			}
+			if va.number <= 0 || vb.number <= 0 {
+				if va.number == vb.number {
+					return 1
+				}
+				return 0
+			}
+			// One decade apart: 0.5
+			return float32(1 / (1 + math.Abs(math.Log10(va.number/vb.number))))
+		}
+	}
+	return 0
+}
+
+// Similarity of all the components to the one with the given ID, not
+// including itself. Needs to be called with lock held.
+func (s *FulltextSearch) similarities(id int) map[int]float32 {
+	result := make(map[int]float32)
+	self, found := s.id2Component[id]
+	if !found {
+		return result
+	}
+	count := float64(len(s.id2Component))
+	idf := func(word string) float32 {
+		return float32(math.Log(1 + count/float64(1+s.termDocs[word])))
+	}
+	wanted := make(map[string]float32)
+	for _, term := range self.terms {
+		wanted[term.word] = term.weight * idf(term.word)
+		// Substitutes of a part are as good as the part.
+		for _, substitute := range s.substitutes[partKey(term.word)] {
+			for _, other := range componentTermWeights(&Component{Value: partKey(substitute)}) {
+				wanted[other.word] = wanted[term.word]
+			}
+		}
+	}
+	words := make([]string, 0, len(wanted))
+	for word := range wanted {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	var wanted_norm float32
+	for _, word := range words {
+		wanted_norm += wanted[word] * wanted[word]
+	}
+	if wanted_norm == 0 {
+		return result
+	}
+	wanted_norm = float32(math.Sqrt(float64(wanted_norm)))
+
+	for other_id, other := range s.id2Component {
+		if other_id == id {
+			continue
+		}
+		var dot, norm float32
+		for _, term := range other.terms {
+			weight := term.weight * idf(term.word)
+			dot += weight * wanted[term.word]
+			norm += weight * weight
+		}
+		if dot <= 0 {
+			continue // Nothing in common.
+		}
+		cosine := dot / (wanted_norm * float32(math.Sqrt(float64(norm))))
+		similarity := (1-kSimilarValueWeight)*cosine +
+			kSimilarValueWeight*valueCloseness(self.values, other.values)
+		if similarity >= kMinSimilarity {
+			result[other_id] = similarity
+		}
+	}
+	return result
+}
+
+// Components similar to the one with the given ID, most similar first.
+func (s *FulltextSearch) Similar(id int) []SimilarComponent {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]SimilarComponent, 0)
+	for other_id, similarity := range s.similarities(id) {
+		result = append(result, SimilarComponent{
+			Component:  s.id2Component[other_id].orig,
+			Similarity: similarity,
+		})
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Similarity != result[b].Similarity {
+			return result[a].Similarity > result[b].Similarity
+		}
+		return result[a].Component.Id < result[b].Component.Id
+	})
+	return result
+}
+
+// Resolve the like: terms of the query to the similarities. Needs to be
+// called with lock held.
+func (s *FulltextSearch) resolveSimilar(n *queryNode) {
+	for _, term := range n.terms() {
+		if term.field == "like" {
+			term.similar = s.similarities(term.likeId)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func similarIds(similar []SimilarComponent) string {
+	result := ""
+	for _, s := range similar {
+		result += fmt.Sprintf("%d ", s.Component.Id)
+	}
+	return result
+}
+
+func TestValueCloseness(t *testing.T) {
+	closeness := func(a, b string) float32 {
+		return valueCloseness(
+			componentValues(&Component{Category: "Resistor", Value: a}),
+			componentValues(&Component{Category: "Resistor", Value: b}))
+	}
+	ExpectTrue(t, closeness("10k", "10k") == 1, "Same")
+	ExpectTrue(t, closeness("10k", "100k") == 0.5, "Decade")
+	ExpectTrue(t, closeness("10k", "4.7k") > closeness("10k", "1M"), "Closer")
+	ExpectTrue(t, closeness("10k", "assorted") == 0, "No value")
+	ExpectTrue(t, valueCloseness(
+		componentValues(&Component{Category: "Resistor", Value: "10k"}),
+		componentValues(&Component{Category: "Capacitor (C)", Value: "10n"})) == 0, "Units")
+}
+
+func TestSimilar(t *testing.T) {
+	fts := NewFulltextSearch()
+	fts.Update(&Component{Id: 1, Category: "Resistor", Value: "10k", Description: "0.25W"})
+	fts.Update(&Component{Id: 2, Category: "Resistor", Value: "1M", Description: "0.25W"})
+	fts.Update(&Component{Id: 3, Category: "Resistor", Value: "4.7k", Description: "0.25W"})
+	fts.Update(&Component{Id: 4, Category: "Resistor", Value: "10k", Description: "Precision, 0.1%"})
+	fts.Update(&Component{Id: 5, Category: "IC", Value: "LM358", Description: "Dual op-amp"})
+	fts.Update(&Component{Id: 6, Category: "IC", Value: "TL072", Description: "Dual op-amp"})
+	fts.Update(&Component{Id: 7, Category: "IC", Value: "74HC00", Description: "Quad NAND"})
+	fts.Update(&Component{Id: 8, Category: "Capacitor (C)", Value: "10n", Description: "0.25 inch pitch"})
+
+	// Same value first, then the closer value.
+	expectEqual(t, similarIds(fts.Similar(1)), "4 3 2 ")
+
+	// Op-amps are more alike than ICs.
+	similar := fts.Similar(5)
+	expectEqual(t, similarIds(similar), "6 7 ")
+	ExpectTrue(t, similar[0].Similarity > 2*similar[1].Similarity, "Rare words count")
+	ExpectTrue(t, similar[0].Similarity <= 1, "Normalized")
+
+	expectEqual(t, similarIds(fts.Similar(42)), "") // No such component.
+
+	// The like: search is the same, and can be refined.
+	result := fts.Search("like:1")
+	ExpectTrue(t, len(result.Results) == 3 && result.Results[0].Id == 4 &&
+		result.Results[2].Id == 2, "like: ordered by similarity")
+	result = fts.Search("like:1 0.25W")
+	ExpectTrue(t, len(result.Results) == 2 && result.Results[0].Id == 3, "Refined")
+
+	// Changes are considered.
+	fts.Remove(4)
+	expectEqual(t, similarIds(fts.Similar(1)), "3 2 ")
+}
@@ -0,0 +1,65 @@
+// Page showing what people search for, and what they don't find.
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	kSearchStatsPage = "/search-stats"
+	kApiSearchStats  = "/api/search-stats"
+
+	kSearchStatsDefaultLimit = 100
+	kSearchStatsMaxLimit     = 1000
+)
+
+type SearchStatsHandler struct {
+	store    StuffStore
+	template *TemplateRenderer
+	editNets []*net.IPNet // Only editors see what others search for.
+}
+
+func AddSearchStatsHandler(store StuffStore, template *TemplateRenderer, editNets []*net.IPNet) {
+	handler := &SearchStatsHandler{
+		store:    store,
+		template: template,
+		editNets: editNets,
+	}
+	http.Handle(kSearchStatsPage, handler)
+	http.Handle(kApiSearchStats, handler)
+}
+
+type SearchStatsPage struct {
+	Popular  []*SearchStats `json:"popular"`
+	NotFound []*SearchStats `json:"not_found"` // Currently no results.
+}
+
+func (h *SearchStatsHandler) ServeHTTP(out http.ResponseWriter, r *http.Request) {
+	defer ElapsedPrint("Search stats", time.Now())
+	if !editAllowed(r, h.editNets) {
+		http.Error(out, "Not allowed to see search statistics", http.StatusForbidden)
+		return
+	}
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		limit = kSearchStatsDefaultLimit
+	}
+	if limit > kSearchStatsMaxLimit {
+		limit = kSearchStatsMaxLimit
+	}
+	page := &SearchStatsPage{
+		Popular:  h.store.TopSearches(limit, false),
+		NotFound: h.store.TopSearches(limit, true),
+	}
+	if r.URL.Path == kApiSearchStats {
+		out.Header().Set("Content-Type", "application/json")
+		json, _ := json.MarshalIndent(page, "", "  ")
+		out.Write(json)
+		return
+	}
+	h.template.Render(out, "search-stats.html", page)
+}
@@ -0,0 +1,231 @@
+// Numeric values of components, such as 4.7kΩ or 50V, and the range and
+// comparison queries on them, e.g. "1k..4.7k", ">=10u" or "voltage:>50V".
+package main
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Numbers with explicit unit mentioned somewhere in a text,
+	// e.g. "rated 50V", "2.5 A" or "-5V".
+	valueWithUnit = regexp.MustCompile(`(?i)(?:^|[\s,;(])(-?\d*[.,]?\d+\s?(?:[pnuµmkgM]|meg)?(?:hz|ohms?|Ω|v|a|w|f|h))\b`)
+
+	// Comparison in a query; the number is parsed separately.
+	numericComparison = regexp.MustCompile(`^(>=|<=|>|<|~|=)(.+)$`)
+)
+
+// Names of quantities that can be used as qualifier in a query.
+var quantityUnits = map[string]string{
+	"voltage":     "V",
+	"current":     "A",
+	"power":       "W",
+	"capacitance": "F",
+	"inductance":  "H",
+	"resistance":  "ohm",
+	"frequency":   "Hz",
+}
+
+// The unit that the value of components in the category implicitly has.
+var categoryUnits = map[string]string{
+	"resistor":      "ohm",
+	"potentiometer": "ohm",
+	"r-network":     "ohm",
+	"capacitor":     "F",
+	"inductor (l)":  "H",
+}
+
+// A number with unit found in a component.
+type physicalValue struct {
+	number float64
+	unit   string // Canonical unit, empty if not known.
+	main   bool   // This is the value of the component, not just mentioned.
+}
+
+// Find the numeric values of the component: the value itself, interpreted
+// in the context of its category, and all numbers with explicit unit
+// mentioned in the text fields.
+func componentValues(c *Component) []physicalValue {
+	result := make([]physicalValue, 0, 2)
+
+	// Reuse the cleanup we do when storing, as it normalizes various
+	// ways to write resistor and capacitor values.
+	cleaned := *c
+	cleanupValue(&cleaned)
+	fields := strings.Fields(cleaned.Value)
+	var value siValue
+	var ok bool
+	if len(fields) >= 2 {
+		value, ok = parseValue(fields[0] + fields[1])
+	}
+	if !ok && len(fields) >= 1 {
+		value, ok = parseValue(fields[0])
+	}
+	if ok {
+		number, unit := value.number, value.unit
+		if unit == "" {
+			unit = categoryUnits[categoryFamily(c.Category)]
+		}
+		if unit == "F" && number >= 1e3 && strings.Contains(fields[0], "M") {
+			// Old notation: .1M is 0.1µF, not a Megafarad.
+			number *= 1e-12
+		}
+		result = append(result, physicalValue{number: number, unit: unit, main: true})
+	}
+
+	for _, text := range []string{c.Value, c.Description, c.Notes} {
+		text = strings.Replace(text, "Ω", "ohm", -1) // \b needs word char
+		for _, match := range valueWithUnit.FindAllStringSubmatch(text, -1) {
+			if value, ok := parseSignedValue(match[1]); ok && value.unit != "" {
+				result = append(result, physicalValue{number: value.number, unit: value.unit})
+			}
+		}
+	}
+	return result
+}
+
+// Like parseValue, but also allows a leading minus, e.g. "-5V" of a
+// negative voltage regulator.
+func parseSignedValue(text string) (siValue, bool) {
+	text = strings.TrimSpace(text)
+	unsigned := strings.TrimPrefix(text, "-")
+	value, ok := parseValue(unsigned)
+	if ok && unsigned != text {
+		value.number = -value.number
+	}
+	return value, ok
+}
+
+// A query term comparing numeric values.
+type numericTerm struct {
+	unit     string // Only compare values with this unit. Empty: any.
+	any      bool   // Look at all values, not only the main value.
+	min, max float64
+	nearest  bool // Score by closeness to min (== max).
+}
+
+// Score for a value within the requested range; nearest values score
+// up to that.
+const kNumericMatchScore = 50
+
+// Parse range and comparison terms such as "1k..4.7k", ">=10u", "~4.7k",
+// "value:<1M", "voltage:>50V" or "voltage:50V". Returns nil if this is not
+// such a term.
+func parseNumericTerm(term string) *numericTerm {
+	result := &numericTerm{min: math.Inf(-1), max: math.Inf(1)}
+	if name, rest, found := strings.Cut(term, ":"); found {
+		name = strings.ToLower(name)
+		if name == "value" {
+			// Only comparisons; plain value:10k is matched as text.
+			term = rest
+		} else {
+			unit, known := quantityUnits[name]
+			if !known {
+				return nil
+			}
+			result.unit = unit
+			result.any = true
+			term = rest
+		}
+	}
+	if term == "" {
+		return nil
+	}
+
+	// Set the unit found in the query, returns false if it contradicts.
+	setUnit := func(unit string) bool {
+		if unit == "" || unit == result.unit {
+			return true
+		}
+		if result.any {
+			return false // voltage:>50A
+		}
+		result.unit = unit
+		return true
+	}
+
+	if from, to, found := strings.Cut(term, ".."); found {
+		if from == "" && to == "" {
+			return nil
+		}
+		if from != "" {
+			value, ok := parseSignedValue(from)
+			if !ok || !setUnit(value.unit) {
+				return nil
+			}
+			result.min = value.number
+		}
+		if to != "" {
+			value, ok := parseSignedValue(to)
+			if !ok || !setUnit(value.unit) {
+				return nil
+			}
+			result.max = value.number
+		}
+		return result
+	}
+
+	op := "="
+	if match := numericComparison.FindStringSubmatch(term); match != nil {
+		op, term = match[1], match[2]
+	} else if !result.any {
+		return nil // Plain numbers are matched as text.
+	}
+	value, ok := parseSignedValue(term)
+	if !ok || !setUnit(value.unit) {
+		return nil
+	}
+	number := value.number
+	// Values are compared with a little slack for rounding errors.
+	const epsilon = 1e-9
+	slack := math.Abs(number) * epsilon
+	switch op {
+	case ">=":
+		result.min = number - slack
+	case ">":
+		result.min = number + slack
+	case "<=":
+		result.max = number + slack
+	case "<":
+		result.max = number - slack
+	case "~":
+		result.min, result.max = number, number
+		result.nearest = true
+	default:
+		result.min, result.max = number-slack, number+slack
+	}
+	return result
+}
+
+// Score of a single value. Zero if it does not match.
+func (n *numericTerm) valueScore(v physicalValue) float32 {
+	if (!n.any && !v.main) || (n.unit != "" && n.unit != v.unit) {
+		return 0
+	}
+	if n.nearest {
+		if v.number <= 0 || n.min <= 0 {
+			return 0
+		}
+		// Closeness in decades; more than one decade away is no match.
+		distance := math.Abs(math.Log10(v.number / n.min))
+		if distance >= 1 {
+			return 0
+		}
+		return float32(kNumericMatchScore / (1 + 10*distance))
+	}
+	if v.number < n.min || v.number > n.max {
+		return 0
+	}
+	return kNumericMatchScore
+}
+
+// Score the best matching of the given values.
+func (n *numericTerm) score(values []physicalValue) float32 {
+	var best float32
+	for _, v := range values {
+		best = maxlist(best, n.valueScore(v))
+	}
+	return best
+}
@@ -3,73 +3,147 @@
 package main
 
 import (
-	"fmt"
+	"math"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 )
 
-var (
-	andRewrite              = regexp.MustCompile(`(?i)( and )`)
-	orRewrite               = regexp.MustCompile(`(?i)( or )`)
-	possibleResistor        = regexp.MustCompile(`(?i)([0-9]+(\.[0-9]+)*[kM]?)(\s*Ohm?)`)
-	possibleSmallMicrofarad = regexp.MustCompile(`(?i)(0?\.[0-9]+)u(\w*)`)
-	logicalTerm             = regexp.MustCompile(`(?i)([\(\)\|])`)
-	likeTerm                = regexp.MustCompile(`(?i)like:([0-9]+)`)
-)
-
-// componentResolver converts a componentID to a string containing the
-// component's terms or blank if the component doesn't exist.
-type componentResolver func(componentID int) string
+var logicalTerm = regexp.MustCompile(`(?i)([\(\)\|])`)
 
 func isSeparator(c byte) bool {
 	return c == ' ' || c == '\t' || c == '\n' || c == '.' || c == ',' || c == ';'
 }
 
-func queryRewrite(term string, componentLookup componentResolver) string {
-	term = andRewrite.ReplaceAllString(term, " ")
-
-	term = orRewrite.ReplaceAllString(term, " | ")
-
-	term = possibleResistor.ReplaceAllString(term, "($0 | ($1 (resistor|potentiometer|r-network)))")
+func preprocessTerm(term string) string {
+	// For simplistic parsing, add spaces around special characters (|)
+	term = logicalTerm.ReplaceAllString(term, " $1 ")
+	return normalizeText(term)
+}
 
-	// Nanofarad values are often given as 0.something microfarad.
-	// Internally, all capacitors are normalized to nanofarad.
-	if cmatch := possibleSmallMicrofarad.FindStringSubmatch(term); cmatch != nil {
-		val, err := strconv.ParseFloat(cmatch[1], 32)
-		if err == nil {
-			term = possibleSmallMicrofarad.ReplaceAllString(
-				term, fmt.Sprintf("($0 | %.0fn$2)", 1000*val))
+func normalizeText(text string) string {
+	// * Lowercase: we want to be case insensitive
+	// * Dash remove: we consider dashes to join words and we want to be
+	//   agnostic to various spellings. Only within words though, a dash
+	//   at the beginning is a minus sign (e.g. -50V).
+	var result strings.Builder
+	previous := ' '
+	for _, r := range strings.ToLower(text) {
+		if r != '-' || !isWordRune(previous) {
+			result.WriteRune(r)
 		}
+		previous = r
 	}
+	return result.String()
+}
 
-	term = likeTerm.ReplaceAllStringFunc(term, func(match string) string {
-		split := strings.SplitN(match, ":", 2)
-		if len(split) != 2 {
-			return match
-		}
-		val, err := strconv.ParseInt(split[1], 10, 32)
-		if err != nil {
-			return match
-		}
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
 
-		return fmt.Sprintf("(%s)", componentLookup(int(val)))
-	})
+// A term of a search query.
+type queryTerm struct {
+	text    string       // Preprocessed text.
+	field   string       // Qualifier such as "category"; empty: any field.
+	numeric *numericTerm // Set for numeric comparisons.
+	fuzzy   []string     // Similar words, if the text is misspelled.
+
+	// Set for qualifiers that are a yes/no property of the component,
+	// such as has:datasheet or id:100..199
+	predicate func(c *Component) bool
+
+	// For like:42, the component ID and the similarity of the other
+	// components to it, set by FulltextSearch.resolveSimilar().
+	likeId  int
+	similar map[int]float32
+}
 
-	return term
+// Qualifiers that restrict a term to a single text field, with the weight
+// the field has when scoring.
+var fieldWeights = map[string]float32{
+	"category":    2.0,
+	"value":       3.0,
+	"description": 1.5,
+	"notes":       1.2,
+	"footprint":   1.0,
+	"set":         3.0, // Name of the equivalence set.
+	"datasheet":   kDatasheetWeight,
 }
 
-func preprocessTerm(term string) string {
-	// For simplistic parsing, add spaces around special characters (|)
-	term = logicalTerm.ReplaceAllString(term, " $1 ")
+// Parse qualifiers that are a property of the component, such as
+// has:image, drawer:large or id:100..199. Returns nil if this is not such a term.
+// The has_image function is used to look up if there is an image.
+func parsePredicate(field string, value string, has_image func(id int) bool) func(c *Component) bool {
+	switch field {
+	case "has":
+		switch value {
+		case "image", "picture":
+			return func(c *Component) bool {
+				return has_image != nil && has_image(c.Id)
+			}
+		case "datasheet":
+			return func(c *Component) bool { return c.Datasheet_url != "" }
+		default:
+			return func(c *Component) bool { return false }
+		}
+	case "drawer":
+		for size, name := range drawerSizeNames {
+			if value == name || value == strconv.Itoa(size) {
+				return func(c *Component) bool { return c.Drawersize == size }
+			}
+		}
+		return func(c *Component) bool { return false }
+	case "id":
+		from, to, is_range := strings.Cut(value, "..")
+		if !is_range {
+			to = from
+		}
+		min, max := 0, math.MaxInt
+		var err error
+		if from != "" {
+			if min, err = strconv.Atoi(from); err != nil {
+				return nil
+			}
+		}
+		if to != "" {
+			if max, err = strconv.Atoi(to); err != nil {
+				return nil
+			}
+		}
+		return func(c *Component) bool { return c.Id >= min && c.Id <= max }
+	}
+	return nil
+}
 
-	// * Lowercase: we want to be case insensitive
-	// * Dash remove: we consider dashes to join words and we want to be
-	//   agnostic to various spellings (might break down with minus signs
-	//   (e.g. -50V), so might need refinement later)
-	return strings.Replace(strings.ToLower(term), "-", "", -1)
+// Parse a single term of a query, see queryNode.compile().
+func parseQueryTerm(token string, has_image func(id int) bool) *queryTerm {
+	if numeric := parseNumericTerm(token); numeric != nil {
+		return &queryTerm{text: token, numeric: numeric}
+	}
+	name, value, found := strings.Cut(token, ":")
+	if !found {
+		return &queryTerm{text: normalizeText(token)}
+	}
+	name = strings.ToLower(name)
+	value = normalizeText(value)
+	if id, err := strconv.Atoi(value); err == nil && name == "like" {
+		return &queryTerm{text: value, field: name, likeId: id}
+	}
+	if name == "tag" && value != "" {
+		// Tags are the hashtags in the notes.
+		return &queryTerm{text: "#" + strings.TrimPrefix(value, "#"), field: "notes"}
+	} else if _, known := fieldWeights[name]; known {
+		// Quoted values might contain parenthesis, which are
+		// spaced out in the fields.
+		return &queryTerm{text: preprocessTerm(value), field: name}
+	} else if predicate := parsePredicate(name, value, has_image); predicate != nil {
+		return &queryTerm{text: value, field: name, predicate: predicate}
+	}
+	return &queryTerm{text: normalizeText(token)}
 }
 
 func StringScore(needle string, haystack string) float32 {
@@ -103,15 +177,9 @@ func maxlist(values ...float32) (max float32) {
 	return
 }
 
-// Score terms, starting at index 'start' and goes to the end of the current
-// term (closing parenthesis or end of string). Returns score and
-// last index it went up to.
-// Treats consecutive terms as 'AND' until it reaches an 'OR' operator.
-// Like in real life, precedence AND > OR, and there are parenthesis to eval
-// terms differently.
-//
-// Scoring per component is done on a couple of important fields, but weighted
-// according to their importance (e.g. the Value field scores more than Info).
+// Score the query. Scoring per component is done on a couple of important
+// fields, but weighted according to their importance (e.g. the Value field
+// scores more than Info).
 //
 // Since we are dealing with real number scores instead of simple boolean
 // matches, the AND and OR operators are implemented to return results like
@@ -119,100 +187,138 @@ func maxlist(values ...float32) (max float32) {
 //   - If any of the subscore of an AND expression is zero, the result is zero.
 //     Otherwise, all sub-scores are added up: this gives a meaningful ordering
 //     for componets that match all terms in the AND expression.
-//     the result is zero.
 //   - For the OR-operation, we take the highest scoring sub-term. Thus if
 //     multiple sub-terms in the OR expression match, this won't result in
 //     keyword stuffing (though one could consider adding a much smaller
 //     constant weight for number of sub-terms that do match).
-func (c *SearchComponent) scoreTerms(terms []string, start int) (float32, int) {
-	var last_or_term float32 = 0.0
-	var current_score float32 = 0.0
-	for i := start; i < len(terms); i++ {
-		part := terms[i]
-		if part == "(" && i < len(terms)-1 {
-			sub_score, subterm_end := c.scoreTerms(terms, i+1)
-			if sub_score <= 0 {
-				current_score = -1000 // See below for reasoning
-			} else {
-				current_score += sub_score
+//   - The NOT-operation only says yes or no, see kNotMatchScore.
+func (c *SearchComponent) score(n *queryNode) float32 {
+	if n == nil {
+		return 0
+	}
+	switch n.kind {
+	case kAndNode:
+		var sum float32
+		for _, child := range n.children {
+			score := c.score(child)
+			if score <= 0 {
+				return 0
 			}
-			i = subterm_end
-			continue
+			sum += score
 		}
-		if part == "|" {
-			last_or_term = maxlist(last_or_term, current_score)
-			current_score = 0
-			continue
+		return sum
+	case kOrNode:
+		var best float32
+		for _, child := range n.children {
+			best = maxlist(best, c.score(child))
 		}
-		if part == ")" && start != 0 {
-			return maxlist(last_or_term, current_score), i
+		return best
+	case kNotNode:
+		if c.score(n.children[0]) > 0 {
+			return 0
 		}
+		return kNotMatchScore
+	case kGroupNode:
+		return c.score(n.children[0])
+	}
+	score := c.termScore(n.term)
+	if score == 0 && len(n.term.fuzzy) > 0 {
+		score = c.fuzzyScore(n.term)
+	}
+	return score
+}
+
+// Score of a negated term that does not match. Small, so that it does not
+// change the ordering of the results; but a query that consists only of
+// negations still returns something.
+const kNotMatchScore = 1.0
+
+// Score of a single term.
+func (c *SearchComponent) termScore(term *queryTerm) float32 {
+	switch {
+	case term.numeric != nil:
+		return term.numeric.score(c.values)
+	case term.predicate != nil:
+		if term.predicate(c.orig) {
+			return 10.0
+		}
+		return 0
+	case term.field == "like":
+		return kSimilarScore * term.similar[c.orig.Id]
+	case term.field == "":
 		// Avoid keyword stuffing by looking only at the field
 		// that scores the most.
 		// NOTE: more fields here, add to lowerCased below.
-		score := maxlist(2.0*StringScore(part, c.preprocessed.Category),
-			3.0*StringScore(part, c.preprocessed.Value),
-			1.5*StringScore(part, c.preprocessed.Description),
-			1.2*StringScore(part, c.preprocessed.Notes),
-			1.0*StringScore(part, c.preprocessed.Footprint))
-		if score == 0 {
-			// We essentially would do an early out here, but
-			// since we're in the middle of parsing until we reach
-			// the next OR, we do the simplistic thing here:
-			// just make it impossible to have max()
-			// give a positive result with the last term.
-			// (todo: if this becomes a problem, implement early out)
-			current_score = -1000
-		} else {
-			current_score += score
-		}
+		return maxlist(2.0*StringScore(term.text, c.preprocessed.Category),
+			3.0*StringScore(term.text, c.preprocessed.Value),
+			1.5*StringScore(term.text, c.preprocessed.Description),
+			1.2*StringScore(term.text, c.preprocessed.Notes),
+			1.0*StringScore(term.text, c.preprocessed.Footprint),
+			kDatasheetWeight*StringScore(term.text, c.datasheet))
+	case term.text == "":
+		return 0 // Qualifier without anything to look for.
+	}
+	field := componentField(c.preprocessed, term.field)
+	switch term.field {
+	case "set":
+		field = c.setName
+	case "datasheet":
+		field = c.datasheet
 	}
-	return maxlist(last_or_term, current_score), len(terms)
+	return fieldWeights[term.field] * StringScore(term.text, field)
 }
 
 // Matches the component and returns a score
 func (c *SearchComponent) MatchScore(term string) float32 {
-	score, _ := c.scoreTerms(strings.Fields(term), 0)
-	return score
-}
-
-// ToQuery converts the component into a normalized search query that can be
-// used to find similar components.
-func (c *SearchComponent) ToQuery() string {
-	sb := &strings.Builder{}
-
-	for _, tmp := range []string{
-		c.preprocessed.Category,
-		c.preprocessed.Description,
-		c.preprocessed.Notes,
-		c.preprocessed.Value,
-		c.preprocessed.Footprint,
-	} {
-		sb.WriteString(tmp)
-		sb.WriteString(" ")
-	}
-
-	return fmt.Sprintf("(%s)", strings.Join(strings.Fields(sb.String()), "|"))
+	return c.score(compileQuery(term, nil))
 }
 
 type SearchComponent struct {
 	orig         *Component
 	preprocessed *Component
+	setName      string // Preprocessed name of the equivalence set.
+	values       []physicalValue
+	terms        []weightedWord // Words, weighted by field.
+	datasheet    string         // Preprocessed text of its or its set's datasheet.
 }
 type FulltextSearch struct {
 	lock         sync.RWMutex
 	id2Component map[int]*SearchComponent
+	setNames     map[int]string // equiv_set -> preprocessed name
+	index        *trigramIndex
+	vocabulary   *vocabulary
+	termDocs     map[string]int // Word -> number of components with it.
+	linearScan   bool           // Score every component, don't use index (benchmarks)
+	hasImage     func(id int) bool
+	synonyms     *SynonymDictionary
+	substitutes  substituteIndex
+	ranking      RankingWeights
+	cache        *searchCache
+
+	datasheets          map[int]string // Component ID -> preprocessed datasheet text.
+	datasheetIds        []int          // Components with a datasheet, own or of the set.
+	datasheetVocabulary *vocabulary    // Words in datasheets, not misspelled.
 }
 
 func NewFulltextSearch() *FulltextSearch {
 	return &FulltextSearch{
 		id2Component: make(map[int]*SearchComponent),
+		setNames:     make(map[int]string),
+		index:        newTrigramIndex(),
+		vocabulary:   newVocabulary(),
+		termDocs:     make(map[string]int),
+		ranking:      kDefaultRankingWeights,
+		cache:        newSearchCache(kSearchCacheSize),
+
+		datasheets:          make(map[int]string),
+		datasheetVocabulary: newVocabulary(),
 	}
 }
 
 type ScoredComponent struct {
-	score float32
-	comp  *Component
+	score      float32
+	comp       *Component
+	substitute string // Only found as substitute for this part.
 }
 type ScoreList []*ScoredComponent
 
@@ -223,6 +329,9 @@ func (s ScoreList) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 func (s ScoreList) Less(a, b int) bool {
+	if (s[a].substitute == "") != (s[b].substitute == "") {
+		return s[a].substitute == "" // Substitutes after exact matches.
+	}
 	diff := s[a].score - s[b].score
 	if diff != 0 {
 		// We want to reverse score: highest match first
@@ -255,10 +364,8 @@ func (s ScoreList) Less(a, b int) bool {
 	return s[a].comp.Id < s[b].comp.Id // stable
 }
 
-func (s *FulltextSearch) Update(c *Component) {
-	if c == nil {
-		return
-	}
+// Needs to be called with lock held, as it looks up the set name.
+func (s *FulltextSearch) newSearchComponent(c *Component) *SearchComponent {
 	lowerCased := &Component{
 		// Only the fields we are interested in.
 		Category:    preprocessTerm(c.Category),
@@ -267,52 +374,272 @@ func (s *FulltextSearch) Update(c *Component) {
 		Notes:       preprocessTerm(c.Notes),
 		Footprint:   preprocessTerm(c.Footprint),
 	}
-	s.lock.Lock()
-	s.id2Component[c.Id] = &SearchComponent{
+	return &SearchComponent{
 		orig:         c,
 		preprocessed: lowerCased,
+		setName:      s.setNames[c.Equiv_set],
+		values:       componentValues(c),
+		terms:        componentTermWeights(lowerCased),
+		datasheet:    s.datasheetText(c),
+	}
+}
+
+func (s *FulltextSearch) Update(c *Component) {
+	if c == nil {
+		return
+	}
+	s.lock.Lock()
+	before, found := s.id2Component[c.Id]
+	if found {
+		s.index.remove(c.Id, componentTrigrams(before))
+		s.vocabulary.remove(componentWords(before))
+		addTermDocs(s.termDocs, before.terms, -1)
+	}
+	search_comp := s.newSearchComponent(c)
+	s.id2Component[c.Id] = search_comp
+	s.index.add(c.Id, componentTrigrams(search_comp))
+	s.vocabulary.add(componentWords(search_comp))
+	addTermDocs(s.termDocs, search_comp.terms, 1)
+	if _, owner := s.datasheets[c.Id]; owner || search_comp.datasheet != "" ||
+		(found && before.datasheet != "") {
+		s.resolveDatasheets() // The datasheet might apply to other members of the set.
+	}
+	s.lock.Unlock()
+	s.cache.invalidate()
+}
+
+// Set the name of an equivalence set, so that it can be found with the
+// set: qualifier. Empty name removes it.
+func (s *FulltextSearch) SetEquivSetName(set int, name string) {
+	name = preprocessTerm(name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if name == "" {
+		delete(s.setNames, set)
+	} else {
+		s.setNames[set] = name
 	}
+	for _, c := range s.id2Component {
+		if c.orig.Equiv_set == set {
+			c.setName = name
+		}
+	}
+	s.cache.invalidate()
+}
+
+// Set the synonyms that queries are expanded with.
+func (s *FulltextSearch) SetSynonyms(synonyms *SynonymDictionary) {
+	s.lock.Lock()
+	s.synonyms = synonyms
+	s.lock.Unlock()
+	s.cache.invalidate()
+}
+
+// Set the part substitutes searches also find.
+func (s *FulltextSearch) SetSubstitutes(substitutes []*PartSubstitute) {
+	index := newSubstituteIndex(substitutes)
+	s.lock.Lock()
+	s.substitutes = index
+	s.lock.Unlock()
+	s.cache.invalidate()
+}
+
+// Set how much the availability of components changes their rank.
+func (s *FulltextSearch) SetRankingWeights(weights RankingWeights) {
+	s.lock.Lock()
+	s.ranking = weights
+	s.lock.Unlock()
+	s.cache.invalidate()
+}
+
+// Set the function to check if a component has an image, for has:image
+func (s *FulltextSearch) SetImageChecker(has_image func(id int) bool) {
+	s.lock.Lock()
+	s.hasImage = has_image
 	s.lock.Unlock()
+	s.cache.invalidate()
 }
+
+func (s *FulltextSearch) Remove(id int) {
+	s.lock.Lock()
+	if before, found := s.id2Component[id]; found {
+		s.index.remove(id, componentTrigrams(before))
+		s.vocabulary.remove(componentWords(before))
+		addTermDocs(s.termDocs, before.terms, -1)
+		delete(s.id2Component, id)
+		if before.datasheet != "" {
+			s.resolveDatasheets()
+		}
+	}
+	s.lock.Unlock()
+	s.cache.invalidate()
+}
+
+// Components that are likely the same part as the one with the given ID
+// (see relatedValues()), plus all the components in the equivalence sets
+// any of these are in. Ordered by set, then ID.
+func (s *FulltextSearch) RelatedComponents(id int) []*Component {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]*Component, 0, 10)
+	self, found := s.id2Component[id]
+	if !found {
+		return result
+	}
+	family := categoryFamily(self.orig.Category)
+	sets := map[int]bool{self.orig.Equiv_set: true}
+	for _, c := range s.id2Component {
+		if categoryFamily(c.orig.Category) == family &&
+			relatedValues(self.orig.Value, c.orig.Value) {
+			sets[c.orig.Equiv_set] = true
+		}
+	}
+	for _, c := range s.id2Component {
+		if sets[c.orig.Equiv_set] {
+			copy := *c.orig
+			result = append(result, &copy)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Equiv_set != result[b].Equiv_set {
+			return result[a].Equiv_set < result[b].Equiv_set
+		}
+		return result[a].Id < result[b].Id
+	})
+	return result
+}
+
+// Search, with the result of recent identical queries reused. The result
+// must not be modified, it might be shared.
 func (s *FulltextSearch) Search(search_term string) *SearchResult {
+	s.lock.RLock()
+	synonyms := s.synonyms
+	s.lock.RUnlock()
+	key := searchCacheKey(search_term, synonyms.Generation())
+	now := time.Now()
+	if cached := s.cache.get(key, now); cached != nil {
+		return cached.forQuery(search_term, synonyms)
+	}
+	generation := s.cache.currentGeneration()
+	result := s.search(search_term, 0)
+	s.cache.put(key, result, generation, now)
+	return result
+}
+
+// Search, but only return the best limit results, e.g. for the first page.
+// Much cheaper than sorting all the results of a large inventory. The
+// Total of the result is the number of all results.
+func (s *FulltextSearch) SearchTop(search_term string, limit int) *SearchResult {
+	s.lock.RLock()
+	synonyms := s.synonyms
+	s.lock.RUnlock()
+	key := searchCacheKey(search_term, synonyms.Generation())
+	if cached := s.cache.get(key, time.Now()); cached != nil {
+		result := cached.forQuery(search_term, synonyms)
+		if limit > 0 && limit < len(result.Results) {
+			result.Results = result.Results[:limit]
+		}
+		return result
+	}
+	return s.search(search_term, limit)
+}
+
+// The cached result for a query that might differ from this one in case or
+// spacing: the parts showing the query are redone for this one.
+func (r *SearchResult) forQuery(search_term string, synonyms *SynonymDictionary) *SearchResult {
+	result := *r
+	query, errors := parseQuery(search_term)
+	result.OrignialQuery = search_term
+	result.RewrittenQuery = expandQuery(query, synonyms).String()
+	result.Errors = errors
+	result.Suggestion = suggestQuery(search_term, r.corrections)
+	return &result
+}
+
+// Evaluate the query. With limit > 0, only the best limit results are kept.
+func (s *FulltextSearch) search(search_term string, limit int) *SearchResult {
 	output := &SearchResult{
 		OrignialQuery: search_term,
 	}
 
-	search_term = queryRewrite(search_term, s.componentTerms)
-	output.RewrittenQuery = search_term
-	search_term = preprocessTerm(search_term)
+	query, errors := parseQuery(search_term)
 	s.lock.RLock()
-	scoredlist := make(ScoreList, 0, 10)
-	for _, search_comp := range s.id2Component {
+	synonyms := s.synonyms
+	s.lock.RUnlock()
+	query = expandQuery(query, synonyms)
+	output.RewrittenQuery = query.String()
+	output.Errors = errors
+	s.lock.RLock()
+	exact := query
+	query = addSubstitutes(exact, s.substitutes)
+	query.compile(s.hasImage)
+	s.resolveSimilar(query)
+	misspelled := make([]*queryTerm, 0)
+	for _, term := range exact.terms() {
+		if !s.datasheetVocabulary.known(term.text) {
+			misspelled = append(misspelled, term)
+		}
+	}
+	corrections := s.vocabulary.addFuzzyAlternatives(misspelled)
+	score := func(search_comp *SearchComponent) *ScoredComponent {
 		scored := &ScoredComponent{
-			score: search_comp.MatchScore(search_term),
-			comp:  search_comp.orig,
+			comp: search_comp.orig,
+		}
+		scored.score = search_comp.score(query)
+		if scored.score > 0 && exact != query && search_comp.score(exact) <= 0 {
+			if outOfStock(search_comp.orig) {
+				return nil // Only substitutes we have.
+			}
+			scored.substitute = search_comp.substituteMatch(query)
 		}
-		if scored.score > 0 {
-			scoredlist = append(scoredlist, scored)
+		if scored.score <= 0 {
+			return nil
 		}
+		has_image := s.hasImage != nil && s.hasImage(search_comp.orig.Id)
+		scored.score *= s.ranking.factor(search_comp.orig, has_image)
+		return scored
 	}
+	var components []*SearchComponent
+	candidates := s.index.candidates(query)
+	if candidates.all || s.linearScan {
+		components = make([]*SearchComponent, 0, len(s.id2Component))
+		for _, search_comp := range s.id2Component {
+			components = append(components, search_comp)
+		}
+	} else {
+		// Datasheets are not indexed, so their components always are
+		// candidates.
+		ids := unionCandidates(candidates, candidateSet{ids: s.datasheetIds}).ids
+		components = make([]*SearchComponent, 0, len(ids))
+		for _, id := range ids {
+			if search_comp, found := s.id2Component[id]; found {
+				components = append(components, search_comp)
+			}
+		}
+	}
+	scoredlist, total := scoreShards(components, limit, score)
 	s.lock.RUnlock()
-	sort.Sort(ScoreList(scoredlist))
+	output.matcher = func(c *Component) []TermMatch {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		search_comp, found := s.id2Component[c.Id]
+		if !found || search_comp.orig != c {
+			return nil // Changed in the meantime.
+		}
+		return search_comp.collectMatches(query)
+	}
+	output.corrections = corrections
+	output.Suggestion = suggestQuery(output.OrignialQuery, corrections)
+	output.Total = total
 	output.Results = make([]*Component, len(scoredlist))
 	for idx, scomp := range scoredlist {
 		output.Results[idx] = scomp.comp
+		if scomp.substitute != "" {
+			if output.Substitutes == nil {
+				output.Substitutes = make(map[int]string)
+			}
+			output.Substitutes[scomp.comp.Id] = scomp.substitute
+		}
 	}
 	return output
 }
-
-func (s *FulltextSearch) componentTerms(componentID int) string {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
-
-	component, ok := s.id2Component[componentID]
-	if !ok {
-		return ""
-	}
-
-	return component.ToQuery()
-}
-
-// Validate that componentTerms is a componentResolver.
-var _ (componentResolver) = ((*FulltextSearch)(nil)).componentTerms
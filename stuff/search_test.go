@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -65,47 +65,109 @@ func TestSearchOperators(t *testing.T) {
 }
 
 func TestQueryRewrite(t *testing.T) {
-	cExpand := func(i int) string {
-		return fmt.Sprintf("<component %d>", i)
-	}
-
 	// Identity
-	expectEqual(t, queryRewrite("foo", cExpand), "foo")
-	expectEqual(t, queryRewrite("10k", cExpand), "10k")
+	expectEqual(t, queryRewrite("foo"), "foo")
+	expectEqual(t, queryRewrite("10k"), "10k")
 
 	// AND, OR rewrite to internal operators
-	expectEqual(t, queryRewrite("foo AND bar", cExpand), "foo bar")
-	expectEqual(t, queryRewrite("foo OR bar", cExpand), "foo | bar")
-	expectEqual(t, queryRewrite("(foo AND bar) OR (bar AND baz)", cExpand),
+	expectEqual(t, queryRewrite("foo AND bar"), "foo bar")
+	expectEqual(t, queryRewrite("foo OR bar"), "foo | bar")
+	expectEqual(t, queryRewrite("(foo AND bar) OR (bar AND baz)"),
 		"(foo bar) | (bar baz)")
 
 	// Only mess with it if it is with spaces.
-	expectEqual(t, queryRewrite("fooANDbar", cExpand), "fooANDbar")
-	expectEqual(t, queryRewrite("fooORbar", cExpand), "fooORbar")
+	expectEqual(t, queryRewrite("fooANDbar"), "fooANDbar")
+	expectEqual(t, queryRewrite("fooORbar"), "fooORbar")
 
 	// We store resistors without the 'Ohm' suffix. So if someone adds
 	// Ohm to the value, expand the query to match the raw number plus
 	// something that narrows it to resistor. But also still look for the
 	// original value in case this is something
-	expectEqual(t, queryRewrite("10k", cExpand), "10k")   // no rewrite
-	expectEqual(t, queryRewrite("3.9k", cExpand), "3.9k") // no rewrite
-	expectEqual(t, queryRewrite("10kOhm", cExpand), "(10kOhm | (10k (resistor|potentiometer|r-network)))")
-	expectEqual(t, queryRewrite("10k Ohm", cExpand), "(10k Ohm | (10k (resistor|potentiometer|r-network)))")
-	expectEqual(t, queryRewrite("3.9kOhm", cExpand), "(3.9kOhm | (3.9k (resistor|potentiometer|r-network)))")
-	expectEqual(t, queryRewrite("3.kOhm", cExpand), "3.kOhm") // silly number.
-
-	expectEqual(t, queryRewrite("0.1u", cExpand), "(0.1u | 100n)")
-	expectEqual(t, queryRewrite(".1u", cExpand), "(.1u | 100n)")
-	expectEqual(t, queryRewrite("0.1uF", cExpand), "(0.1uF | 100nF)")
-	expectEqual(t, queryRewrite("0.01u", cExpand), "(0.01u | 10n)")
-	expectEqual(t, queryRewrite("0.068u", cExpand), "(0.068u | 68n)")
-
-	// Similarity search looks up the component details.
-	expectEqual(t, queryRewrite("like:42", cExpand), "(<component 42>)")
-	expectEqual(t, queryRewrite("like:foo", cExpand), "like:foo") // silly number.
+	expectEqual(t, queryRewrite("10k"), "10k")   // no rewrite
+	expectEqual(t, queryRewrite("3.9k"), "3.9k") // no rewrite
+	expectEqual(t, queryRewrite("10kOhm"), "(10kOhm | (10k (resistor | potentiometer | r-network)))")
+	expectEqual(t, queryRewrite("10k Ohm"), "(10k Ohm | (10k (resistor | potentiometer | r-network)))")
+	expectEqual(t, queryRewrite("3.9kOhm"), "(3.9kOhm | (3.9k (resistor | potentiometer | r-network)))")
+	expectEqual(t, queryRewrite("3.kOhm"), "3.kOhm") // silly number.
+
+	expectEqual(t, queryRewrite("0.1u"), "(0.1u | 100n)")
+	expectEqual(t, queryRewrite(".1u"), "(.1u | 100n)")
+	expectEqual(t, queryRewrite("0.1uF"), "(0.1uF | 100nF)")
+	expectEqual(t, queryRewrite("0.01u"), "(0.01u | 10n)")
+	expectEqual(t, queryRewrite("0.068u"), "(0.068u | 68n)")
+	expectEqual(t, queryRewrite("1000n"), "(1000n | 1u)")
+
+	// Values in notations we don't store.
+	expectEqual(t, queryRewrite("4k7"), "(4k7 | 4.7k)")
+	expectEqual(t, queryRewrite("M3"), "M3") // Screw, not 300k.
+	expectEqual(t, queryRewrite("4n7"), "(4n7 | 4.7n)")
+	expectEqual(t, queryRewrite("2R2"), "(2R2 | 2.2)")
+	expectEqual(t, queryRewrite("4.7K"), "4.7K")
+	expectEqual(t, queryRewrite("1.00k"), "1.00k")
+	expectEqual(t, queryRewrite("1000"), "1000")
+	expectEqual(t, queryRewrite("2n2222"), "2n2222")
+
+	// Similarity search is not a rewrite.
+	expectEqual(t, queryRewrite("like:42"), "like:42")
+}
+
+func TestNotOperator(t *testing.T) {
+	s := &SearchComponent{
+		preprocessed: &Component{
+			Category:  "resist",
+			Value:     "foo",
+			Notes:     "#broken",
+			Footprint: "smd",
+		},
+	}
+	expectMatch(t, s, "!bar", true)
+	expectMatch(t, s, "!foo", false)
+	expectMatch(t, s, "foo !bar", true)
+	expectMatch(t, s, "foo !smd", false)
+	expectMatch(t, s, "!!foo", true)
+	expectMatch(t, s, "!(bar|baz)", true)
+	expectMatch(t, s, "!(bar|foo)", false)
+	expectMatch(t, s, "!(foo bar)", true)
+	expectMatch(t, s, "!tag:broken", false)
+	expectMatch(t, s, "!(tag:broken)", false)
+	expectMatch(t, s, "tag:broken", true)
+	expectMatch(t, s, "tag:#broken", true)
+	expectMatch(t, s, "tag:brok", true)
+
+	// NOT binds tighter than AND and OR.
+	expectMatch(t, s, "!foo | bar", false)
+	expectMatch(t, s, "!foo | resist", true)
+	expectMatch(t, s, "!bar foo | baz", true)
+	expectMatch(t, s, "!smd foo | baz", false)
+
+	// Only at the beginning of a term it is an operator.
+	s.preprocessed.Value = "foo!bar"
+	expectMatch(t, s, "foo!bar", true)
+
+	// Negations don't change the order of the rest.
+	plain := s.MatchScore("resist")
+	ExpectTrue(t, s.MatchScore("resist !baz") == plain+kNotMatchScore, "Constant")
+}
+
+func TestNotRewrite(t *testing.T) {
+	expectEqual(t, queryRewrite("resistor -smd"), "resistor !smd")
+	expectEqual(t, queryRewrite("-smd"), "!smd")
+	expectEqual(t, queryRewrite("(-smd|-tht)"), "(!smd | !tht)")
+	expectEqual(t, queryRewrite("cap not electrolytic"), "cap !electrolytic")
+	expectEqual(t, queryRewrite("NOT (tag:broken)"), "!(tag:broken)")
+	expectEqual(t, queryRewrite("not(tag:broken)"), "!(tag:broken)")
+	expectEqual(t, queryRewrite("!(tag:broken)"), "!(tag:broken)")
+	expectEqual(t, queryRewrite("!>=10u"), "!>=10u")
+	expectEqual(t, queryRewrite("not 10kOhm"), "!(10kOhm | (10k (resistor | potentiometer | r-network)))")
+
+	// Not an operator.
+	expectEqual(t, queryRewrite("lm7905 -5V"), "lm7905 -5V")
+	expectEqual(t, queryRewrite("to-220"), "to-220")
+	expectEqual(t, queryRewrite("nothing"), "nothing")
+	expectEqual(t, queryRewrite("cannot"), "cannot")
 }
 
-func TestSearchComponent_ToQuery(t *testing.T) {
+func TestComponentTermWeights(t *testing.T) {
 
 	cases := map[string]struct {
 		component Component
@@ -113,27 +175,27 @@ func TestSearchComponent_ToQuery(t *testing.T) {
 	}{
 		"blank component": {
 			component: Component{},
-			expect:    "()",
+			expect:    "",
 		},
 		"category filled": {
 			component: Component{Category: "resistor"},
-			expect:    "(resistor)",
+			expect:    "resistor",
 		},
 		"description filled": {
 			component: Component{Description: "description"},
-			expect:    "(description)",
+			expect:    "description",
 		},
 		"notes filled": {
 			component: Component{Notes: "notes"},
-			expect:    "(notes)",
+			expect:    "notes",
 		},
 		"value filled": {
 			component: Component{Value: "value"},
-			expect:    "(value)",
+			expect:    "value",
 		},
 		"footprint filled": {
 			component: Component{Footprint: "footprint"},
-			expect:    "(footprint)",
+			expect:    "footprint",
 		},
 		"full component": {
 			component: Component{
@@ -143,7 +205,7 @@ func TestSearchComponent_ToQuery(t *testing.T) {
 				Value:       "value",
 				Footprint:   "footprint",
 			},
-			expect: "(category|d1|d2|n1|n2|value|footprint)",
+			expect: "category d1 d2 footprint n1 n2 value",
 		},
 		"ignored fields": {
 			component: Component{
@@ -151,18 +213,159 @@ func TestSearchComponent_ToQuery(t *testing.T) {
 				Drawersize:    3,
 				Quantity:      "300ish",
 			},
-			expect: "()",
+			expect: "",
 		},
 	}
 
 	for tn, tc := range cases {
 		t.Run(tn, func(t *testing.T) {
-			s := &SearchComponent{
-				preprocessed: &tc.component,
+			words := make([]string, 0)
+			for _, term := range componentTermWeights(&tc.component) {
+				words = append(words, term.word)
 			}
-
-			expectEqual(t, s.ToQuery(), tc.expect)
+			expectEqual(t, strings.Join(words, " "), tc.expect)
 		})
 	}
 
 }
+
+func expectNumericMatch(t *testing.T, c *Component, term string, expected bool) {
+	s := NewFulltextSearch().newSearchComponent(c)
+	if (s.MatchScore(term) > 0) != expected {
+		t.Errorf("%s: '%s' expected match %v", c.Value, term, expected)
+	}
+}
+
+func TestNumericSearch(t *testing.T) {
+	resistor := &Component{Category: "Resistor", Value: "2k2", Description: "1/4W"}
+	expectNumericMatch(t, resistor, "1k..4.7k", true)
+	expectNumericMatch(t, resistor, "resistor 1k..4.7k", true)
+	expectNumericMatch(t, resistor, "capacitor 1k..4.7k", false)
+	expectNumericMatch(t, resistor, "1k..2k", false)
+	expectNumericMatch(t, resistor, "2.2k..", true)
+	expectNumericMatch(t, resistor, "..1k", false)
+	expectNumericMatch(t, resistor, ">=2.2k", true)
+	expectNumericMatch(t, resistor, ">2.2k", false)
+	expectNumericMatch(t, resistor, "<1M", true)
+	expectNumericMatch(t, resistor, "<1m", false) // milli, not Mega
+	expectNumericMatch(t, resistor, "1kOhm..3kOhm", true)
+	expectNumericMatch(t, resistor, "1kF..3kF", false) // wrong unit
+	expectNumericMatch(t, resistor, "~2k", true)
+	expectNumericMatch(t, resistor, "~100k", false) // too far away
+
+	capacitor := &Component{Category: "Capacitor (C)", Value: "0.1uF", Description: "50V ceramic"}
+	expectNumericMatch(t, capacitor, ">=10u", false)
+	expectNumericMatch(t, capacitor, ">=10n", true)
+	expectNumericMatch(t, capacitor, "capacitor 47n..220nF", true)
+	expectNumericMatch(t, capacitor, "voltage:>25V", true)
+	expectNumericMatch(t, capacitor, "voltage:>50V", false)
+	expectNumericMatch(t, capacitor, "voltage:50V", true)
+	expectNumericMatch(t, capacitor, "voltage:50", true)
+	expectNumericMatch(t, capacitor, "current:>1A", false)
+	expectNumericMatch(t, capacitor, "(voltage:>100V | ceramic)", true)
+
+	regulator := &Component{Category: "Regulator", Value: "7905", Description: "-5V 1A"}
+	expectNumericMatch(t, regulator, "voltage:-5V", true)
+	expectNumericMatch(t, regulator, "voltage:<=-5V", true)
+	expectNumericMatch(t, regulator, "voltage:<-5V", false)
+	expectNumericMatch(t, regulator, "voltage:>-5V", false)
+	expectNumericMatch(t, regulator, "voltage:>=-5V", true)
+	expectNumericMatch(t, regulator, "voltage:-12V..-4V", true)
+	expectNumericMatch(t, regulator, "voltage:>5V", false)
+
+	// Old style micro farad notation.
+	expectNumericMatch(t, &Component{Category: "Capacitor (C)", Value: ".01M"}, "~10n", true)
+
+	// Three digit capacitor code.
+	expectNumericMatch(t, &Component{Category: "Capacitor (C)", Value: "104"}, "~100n", true)
+
+	// Closer values score higher.
+	fts := NewFulltextSearch()
+	close := fts.newSearchComponent(&Component{Category: "Resistor", Value: "4.7k"})
+	far := fts.newSearchComponent(&Component{Category: "Resistor", Value: "3.3k"})
+	ExpectTrue(t, close.MatchScore("~5k") > far.MatchScore("~5k"), "Nearest")
+}
+
+func TestParseNumericTerm(t *testing.T) {
+	ExpectTrue(t, parseNumericTerm("10k") == nil, "Plain number is text")
+	ExpectTrue(t, parseNumericTerm("like:42") == nil, "Not a quantity")
+	ExpectTrue(t, parseNumericTerm("foo..bar") == nil, "Not numbers")
+	ExpectTrue(t, parseNumericTerm("voltage:>5A") == nil, "Wrong unit")
+	ExpectTrue(t, parseNumericTerm("..") == nil, "Empty range")
+
+	term := parseNumericTerm("1k..4k7")
+	ExpectTrue(t, term != nil && term.min == 1000 && term.max == 4700, "Range")
+	term = parseNumericTerm("voltage:<-5V")
+	ExpectTrue(t, term != nil && term.max < -5, "Negative bound")
+	term = parseNumericTerm("voltage:>-5V")
+	ExpectTrue(t, term != nil && term.min > -5, "Negative bound")
+	term = parseNumericTerm("Voltage:>=50V")
+	ExpectTrue(t, term != nil && term.unit == "V" && term.any, "Qualified")
+}
+
+func TestNumericQueryNotRewritten(t *testing.T) {
+	expectEqual(t, queryRewrite("0.1u..1u"), "0.1u..1u")
+	expectEqual(t, queryRewrite("(1kOhm..2kOhm | >.1u)"), "(1kOhm..2kOhm | >.1u)")
+	expectEqual(t, queryRewrite("10kOhm >=10u"), "(10kOhm | (10k (resistor | potentiometer | r-network))) >=10u")
+}
+
+func TestFieldQualifiers(t *testing.T) {
+	fts := NewFulltextSearch()
+	mosfet := fts.newSearchComponent(&Component{
+		Id:            150,
+		Category:      "Mosfet",
+		Value:         "IRF540",
+		Description:   "N-Channel, better than a TO-92 one",
+		Notes:         "#power",
+		Footprint:     "TO-220",
+		Datasheet_url: "https://example.com/irf540.pdf",
+	})
+	expect := func(query string, expected bool) {
+		has_image := func(id int) bool { return id == 150 }
+		if (mosfet.score(compileQuery(query, has_image)) > 0) != expected {
+			t.Errorf("'%s' expected match %v", query, expected)
+		}
+	}
+	expect("category:mosfet", true)
+	expect("Category:MOSFET", true)
+	expect("category:irf540", false)
+	expect("value:irf540", true)
+	expect("footprint:to-220", true)
+	expect("footprint:to92", false)
+	expect("to92", true) // Unqualified looks everywhere
+	expect("description:channel", true)
+	expect("notes:#power", true)
+	expect("notes:channel", false)
+	expect("category:", false)
+	expect(`description:"better than"`, true)
+	expect(`description:"than better"`, false)
+	expect("id:150", true)
+	expect("id:100..199", true)
+	expect("id:200..", false)
+	expect("id:..150", true)
+	expect("has:datasheet", true)
+	expect("has:image", true)
+	expect("has:unicorn", false)
+
+	// Composes with the boolean operators.
+	expect("category:mosfet footprint:to-220", true)
+	expect("category:mosfet footprint:to-92", false)
+	expect("(footprint:dip-8 | footprint:to-220) has:datasheet", true)
+	expect("category:transistor | category:mosfet", true)
+}
+
+func tokenTexts(query string) string {
+	tokens, _ := tokenizeQuery(query)
+	result := make([]string, len(tokens))
+	for i, t := range tokens {
+		result[i] = t.text
+	}
+	return strings.Join(result, ",")
+}
+
+func TestTokenizeQuery(t *testing.T) {
+	expectEqual(t, tokenTexts(`(foo|bar) baz`), "(,foo,|,bar,),baz")
+	expectEqual(t, tokenTexts(`category:"ic analog" x`), "category:ic analog,x")
+	expectEqual(t, tokenTexts(`"a (b)"`), "a (b)")
+	expectEqual(t, tokenTexts(`!foo !(a|!b) x!y "!z"`), "!,foo,!,(,a,|,!,b,),x!y,!z")
+}
@@ -0,0 +1,235 @@
+// Equivalence sets ("virtual drawers"): a page per set and a listing API.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kSetPage            = "/set/"
+	kApiSets            = "/api/sets"
+	kSetSuggestionsPage = "/set-suggestions"
+
+	kSetSuggestionsMaxShown = 200
+)
+
+type SetHandler struct {
+	store    StuffStore
+	template *TemplateRenderer
+	editNets []*net.IPNet
+}
+
+func AddSetHandler(store StuffStore, template *TemplateRenderer, editNets []*net.IPNet) {
+	handler := &SetHandler{
+		store:    store,
+		template: template,
+		editNets: editNets,
+	}
+	http.Handle(kSetPage, handler)
+	http.Handle(kApiSets, handler)
+	http.Handle(kSetSuggestionsPage, handler)
+}
+
+type JsonSetMember struct {
+	Id        int    `json:"id"`
+	Category  string `json:"category"`
+	Value     string `json:"value"`
+	Footprint string `json:"footprint,omitempty"`
+	Quantity  string `json:"quantity,omitempty"`
+}
+
+type JsonSet struct {
+	EquivSetInfo
+	Quantity int             `json:"quantity"` // Sum of parseable quantities.
+	Link     string          `json:"link"`
+	Members  []JsonSetMember `json:"members"`
+}
+
+type JsonApiSetsResult struct {
+	Count int        `json:"count"`
+	Sets  []*JsonSet `json:"sets"`
+}
+
+type SetPage struct {
+	Info        EquivSetInfo
+	Members     []*Component
+	Quantity    int
+	Unparsed    int // Number of members with quantity we can't add up.
+	EditAllowed bool
+	Msg         string
+}
+
+// Add up the quantities of the given components. Returns the sum and the
+// number of components whose quantity could not be parsed.
+func combinedQuantity(components []*Component) (int, int) {
+	sum, unparsed := 0, 0
+	for _, c := range components {
+		if q, ok := parseQuantity(c.Quantity); ok {
+			sum += q
+		} else {
+			unparsed++
+		}
+	}
+	return sum, unparsed
+}
+
+type SetSuggestionsPage struct {
+	Suggestions []*SetSuggestion
+	Total       int
+	BinsFreed   int // If all suggestions were accepted.
+	EditAllowed bool
+	Msg         string
+}
+
+func (h *SetHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+	defer ElapsedPrint("Set", time.Now())
+	switch {
+	case strings.HasPrefix(req.URL.Path, kApiSets):
+		h.apiSets(out, req)
+	case strings.HasPrefix(req.URL.Path, kSetSuggestionsPage):
+		h.suggestionsPage(out, req)
+	default:
+		h.setPage(out, req)
+	}
+}
+
+func (h *SetHandler) setPage(out http.ResponseWriter, r *http.Request) {
+	set, err := strconv.Atoi(r.URL.Path[len(kSetPage):])
+	if err != nil {
+		http.Error(out, "Invalid set ID", http.StatusBadRequest)
+		return
+	}
+	// Any member leads to the set it is in.
+	if c := h.store.FindById(set); c != nil && c.Equiv_set != set {
+		http.Redirect(out, r, fmt.Sprintf("%s%d", kSetPage, c.Equiv_set),
+			http.StatusSeeOther)
+		return
+	}
+	page := &SetPage{
+		Info:        EquivSetInfo{Id: set},
+		Members:     h.store.EquivSetMembers(set),
+		EditAllowed: editAllowed(r, h.editNets),
+	}
+	if len(page.Members) == 0 {
+		http.Error(out, "No such set", http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		if page.EditAllowed {
+			h.store.EditEquivSetInfo(EquivSetInfo{
+				Id:       set,
+				Name:     cleanString(r.FormValue("name")),
+				Note:     cleanString(r.FormValue("note")),
+				Location: cleanString(r.FormValue("location")),
+			})
+			log.Printf("Set info of %d changed", set)
+			page.Msg = "Saved."
+		} else {
+			page.Msg = "Not allowed to edit."
+		}
+	}
+	if info := h.store.FindEquivSetInfo(set); info != nil {
+		page.Info = *info
+	}
+	page.Quantity, page.Unparsed = combinedQuantity(page.Members)
+	h.template.Render(out, "set-page.html", page)
+}
+
+// All sets that are worth listing: the ones with more than one member
+// or with information attached.
+func (h *SetHandler) collectSets() []*JsonSet {
+	by_id := make(map[int]*JsonSet)
+	members := make(map[int][]*Component)
+	h.store.IterateAll(func(c *Component) bool {
+		members[c.Equiv_set] = append(members[c.Equiv_set], c)
+		return true
+	})
+	for _, info := range h.store.AllEquivSetInfo() {
+		by_id[info.Id] = &JsonSet{EquivSetInfo: *info}
+	}
+	result := make([]*JsonSet, 0)
+	for set, components := range members {
+		s := by_id[set]
+		if s == nil {
+			if len(components) < 2 {
+				continue
+			}
+			s = &JsonSet{EquivSetInfo: EquivSetInfo{Id: set}}
+		}
+		sort.Slice(components, func(a, b int) bool {
+			return components[a].Id < components[b].Id
+		})
+		s.Quantity, _ = combinedQuantity(components)
+		s.Link = fmt.Sprintf("%s%d", kSetPage, set)
+		for _, c := range components {
+			s.Members = append(s.Members, JsonSetMember{
+				Id:        c.Id,
+				Category:  c.Category,
+				Value:     c.Value,
+				Footprint: c.Footprint,
+				Quantity:  c.Quantity,
+			})
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Id < result[b].Id
+	})
+	return result
+}
+
+func (h *SetHandler) apiSets(out http.ResponseWriter, r *http.Request) {
+	out.Header().Set("Cache-Control", "max-age=10")
+	out.Header().Set("Content-Type", "application/json")
+	sets := h.collectSets()
+	jsonResult := &JsonApiSetsResult{
+		Count: len(sets),
+		Sets:  sets,
+	}
+	json, _ := json.MarshalIndent(jsonResult, "", "  ")
+	out.Write(json)
+}
+
+func (h *SetHandler) suggestionsPage(out http.ResponseWriter, r *http.Request) {
+	page := &SetSuggestionsPage{
+		EditAllowed: editAllowed(r, h.editNets),
+	}
+	if r.Method == "POST" {
+		key := r.FormValue("key")
+		// Always work on the current state, things might have changed
+		// since the page was shown.
+		suggestion := findSetSuggestion(h.store, key)
+		switch {
+		case !page.EditAllowed:
+			page.Msg = "Not allowed to edit."
+		case suggestion == nil:
+			page.Msg = "Suggestion is not valid anymore."
+		case r.FormValue("op") == "accept":
+			acceptSetSuggestion(h.store, suggestion)
+			log.Printf("Accepted set suggestion '%s' into set %d", key, suggestion.Target)
+			page.Msg = fmt.Sprintf("Joined %d components in set %d.",
+				len(suggestion.Members), suggestion.Target)
+		case r.FormValue("op") == "reject":
+			h.store.RejectSetSuggestion(key)
+			log.Printf("Rejected set suggestion '%s'", key)
+			page.Msg = "Won't suggest that again."
+		}
+	}
+	page.Suggestions = suggestSets(h.store)
+	page.Total = len(page.Suggestions)
+	for _, s := range page.Suggestions {
+		page.BinsFreed += s.BinsFreed
+	}
+	if len(page.Suggestions) > kSetSuggestionsMaxShown {
+		page.Suggestions = page.Suggestions[:kSetSuggestionsMaxShown]
+	}
+	h.template.Render(out, "set-suggestions.html", page)
+}
@@ -0,0 +1,112 @@
+// Suggesting equivalence sets for the whole inventory: components with the
+// same normalized value, category and footprint belong in the same drawer.
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+type SetSuggestion struct {
+	Key       string       // Identifies the suggestion, e.g. for rejecting.
+	Target    int          // The set all members would join.
+	Members   []*Component // Ordered by ID.
+	Sets      int          // Number of different sets the members are in now.
+	BinsFreed int          // Sets that would become empty.
+}
+
+// The key of the cluster the component belongs to. Empty if it should not
+// be clustered at all.
+func setSuggestionKey(c *Component) string {
+	if isEmptyBin(c) {
+		return ""
+	}
+	tokens := valueTokens(c.Value)
+	if len(tokens) == 0 {
+		return ""
+	}
+	return strings.Join([]string{
+		categoryFamily(c.Category),
+		strings.Join(tokens, " "),
+		normalizeFootprint(c.Footprint),
+	}, "|")
+}
+
+// Cluster all components into suggested equivalence sets. Only clusters that
+// span more than one set are suggested, and none that have been rejected.
+// The ones that free up the most bins come first.
+func suggestSets(store StuffStore) []*SetSuggestion {
+	clusters := make(map[string][]*Component)
+	set_size := make(map[int]int)
+	store.IterateAll(func(c *Component) bool {
+		set_size[c.Equiv_set]++
+		if key := setSuggestionKey(c); key != "" {
+			clusters[key] = append(clusters[key], c)
+		}
+		return true
+	})
+	rejected := store.RejectedSetSuggestions()
+	result := make([]*SetSuggestion, 0)
+	for key, members := range clusters {
+		if len(members) < 2 || rejected[key] {
+			continue
+		}
+		in_set := make(map[int]int) // Cluster members per set.
+		for _, c := range members {
+			in_set[c.Equiv_set]++
+		}
+		if len(in_set) < 2 {
+			continue // Already organized.
+		}
+		sort.Slice(members, func(a, b int) bool {
+			return members[a].Id < members[b].Id
+		})
+		s := &SetSuggestion{
+			Key:     key,
+			Target:  members[0].Equiv_set,
+			Members: members,
+			Sets:    len(in_set),
+		}
+		for set := range in_set {
+			if set < s.Target {
+				s.Target = set
+			}
+		}
+		for set, count := range in_set {
+			// Sets with other parts are still needed for those.
+			if set != s.Target && count == set_size[set] {
+				s.BinsFreed++
+			}
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].BinsFreed != result[b].BinsFreed {
+			return result[a].BinsFreed > result[b].BinsFreed
+		}
+		if len(result[a].Members) != len(result[b].Members) {
+			return len(result[a].Members) > len(result[b].Members)
+		}
+		return result[a].Target < result[b].Target
+	})
+	return result
+}
+
+// Find the current suggestion with the given key, nil if there is none.
+func findSetSuggestion(store StuffStore, key string) *SetSuggestion {
+	for _, s := range suggestSets(store) {
+		if s.Key == key {
+			return s
+		}
+	}
+	return nil
+}
+
+// Have all members of the suggestion join the target set.
+func acceptSetSuggestion(store StuffStore, s *SetSuggestion) {
+	for _, c := range s.Members {
+		if c.Equiv_set != s.Target {
+			store.JoinSet(c.Id, s.Target)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSuggestSets(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "suggest-sets")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+	for id, c := range map[int]Component{
+		1:  {Category: "Resistor", Value: "10k"},
+		2:  {Category: "Resistor", Value: "10 kOhm"},
+		3:  {Category: "Resistor", Value: "10k"},
+		4:  {Category: "Resistor", Value: "1k"}, // Lives with 3
+		5:  {Category: "Transistor", Value: "2N3904", Footprint: "TO-92"},
+		6:  {Category: "Mosfet", Value: "2N3904", Footprint: "TO92"},
+		7:  {Category: "Transistor", Value: "2N3904", Footprint: "TO-220"},
+		8:  {Category: "Capacitor (C)", Value: "100nF"},
+		9:  {Category: "Capacitor (C)", Value: "0.1uF"},
+		10: {Value: "empty"},
+		11: {Value: "empty"},
+	} {
+		id, c := id, c
+		store.EditRecord(id, func(comp *Component) bool { c.Id = id; *comp = c; return true })
+	}
+	store.JoinSet(4, 3)
+	store.JoinSet(9, 8)
+
+	suggestions := suggestSets(store)
+	ExpectTrue(t, len(suggestions) == 2, "Resistors and transistors")
+
+	// Most bins freed first: 2 is freed, 3 is still needed for the 1k.
+	resistors := suggestions[0]
+	ExpectTrue(t, len(resistors.Members) == 3, "len(resistors.Members)")
+	ExpectTrue(t, resistors.Target == 1, "resistors.Target")
+	ExpectTrue(t, resistors.Sets == 3, "resistors.Sets")
+	ExpectTrue(t, resistors.BinsFreed == 1, "resistors.BinsFreed")
+
+	transistors := suggestions[1]
+	ExpectTrue(t, len(transistors.Members) == 2, "len(transistors.Members)") // Not the TO-220
+	ExpectTrue(t, transistors.Members[0].Id == 5, "transistors.Members[0].Id")
+	ExpectTrue(t, transistors.BinsFreed == 1, "transistors.BinsFreed")
+
+	acceptSetSuggestion(store, findSetSuggestion(store, resistors.Key))
+	ExpectTrue(t, store.FindById(2).Equiv_set == 1, "Joined #2")
+	ExpectTrue(t, store.FindById(3).Equiv_set == 1, "Joined #3")
+	ExpectTrue(t, store.FindById(4).Equiv_set == 4, "1k is on its own")
+
+	store.RejectSetSuggestion(transistors.Key)
+	ExpectTrue(t, len(suggestSets(store)) == 0, "All handled")
+	ExpectTrue(t, findSetSuggestion(store, transistors.Key) == nil, "Rejected")
+}
@@ -0,0 +1,191 @@
+// Parsing and formatting of component values: numbers with SI prefix and
+// unit such as 4.7kΩ, 100nF or 0.1µF, RKM notation (4k7, 2R2, 4n7) and the
+// EIA codes printed on parts (104, 01C).
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// A number with optional SI prefix, such as 0.1u, 100n, 10k or RKM
+	// notation 4k7 (prefix as decimal point, at most two digits after; only
+	// R as in R47 can come without digits before).
+	// The unit is removed before.
+	siNumber = regexp.MustCompile(`^(\d*)(?:[.,](\d+))?(p|P|n|N|u|U|µ|m|k|K|M|[Mm][Ee][Gg]|G|R|r)?(\d{0,2})$`)
+
+	// A unit at the end of a value.
+	unitSuffix = regexp.MustCompile(`(?i)(hz|ohms?|Ω|[vawfh])$`)
+
+	eiaCode   = regexp.MustCompile(`^(\d{2,3})(\d)$`)
+	eia96Code = regexp.MustCompile(`^(\d\d)([ZYRXSABHCDEF])$`)
+)
+
+var siMultiplier = map[string]float64{
+	"p": 1e-12, "P": 1e-12, "n": 1e-9, "N": 1e-9,
+	"u": 1e-6, "U": 1e-6, "µ": 1e-6, "m": 1e-3,
+	"": 1, "R": 1, "r": 1,
+	"k": 1e3, "K": 1e3, "M": 1e6, "meg": 1e6, "G": 1e9,
+}
+
+// Prefixes values are formatted with, in increasing order.
+var (
+	kCapacitorPrefixes = []string{"p", "n", "u"}
+	kResistorPrefixes  = []string{"", "k", "M", "G"}
+	kAllPrefixes       = []string{"p", "n", "u", "m", "", "k", "M", "G"}
+)
+
+// Values of the EIA-96 resistor codes 01 to 96.
+var eia96Values = [...]float64{
+	100, 102, 105, 107, 110, 113, 115, 118, 121, 124, 127, 130,
+	133, 137, 140, 143, 147, 150, 154, 158, 162, 165, 169, 174,
+	178, 182, 187, 191, 196, 200, 205, 210, 215, 221, 226, 232,
+	237, 243, 249, 255, 261, 267, 274, 280, 287, 294, 301, 309,
+	316, 324, 332, 340, 348, 357, 365, 374, 383, 392, 402, 412,
+	422, 432, 442, 453, 464, 475, 487, 499, 511, 523, 536, 549,
+	562, 576, 590, 604, 619, 634, 649, 665, 681, 698, 715, 732,
+	750, 768, 787, 806, 825, 845, 866, 887, 909, 931, 953, 976,
+}
+
+var eia96Multiplier = map[string]float64{
+	"Z": 0.001, "Y": 0.01, "R": 0.01, "X": 0.1, "S": 0.1, "A": 1,
+	"B": 10, "H": 10, "C": 100, "D": 1e3, "E": 1e4, "F": 1e5,
+}
+
+// A parsed component value.
+type siValue struct {
+	number float64
+	unit   string // Canonical unit, empty if not given.
+	digits int    // Significant digits as written: 2 for 4.7k, 3 for 1.00k
+}
+
+func canonicalUnit(unit string) string {
+	switch strings.ToLower(unit) {
+	case "":
+		return ""
+	case "hz":
+		return "Hz"
+	case "ohm", "ohms", "ω": // Lowercase Ω
+		return "ohm"
+	default:
+		return strings.ToUpper(unit)
+	}
+}
+
+// Parse a value with SI prefix and optional unit, e.g. "4.7k", "4k7Ω",
+// "2R2", "100nF", "0.1µF", "10 kOhm", "1meg" or "50V". Case matters for
+// the prefix (m vs. M). Returns false if this is not a plain value.
+func parseValue(text string) (siValue, bool) {
+	text = strings.Replace(strings.TrimSpace(text), " ", "", -1)
+	unit := unitSuffix.FindString(text)
+	m := siNumber.FindStringSubmatch(strings.TrimSuffix(text, unit))
+	if m == nil || (m[1] == "" && m[2] == "" && m[4] == "") {
+		return siValue{}, false
+	}
+	integer, fraction, prefix, rkm := m[1], m[2], m[3], m[4]
+	if len(fraction) == 3 && integer != "" && strings.Contains(m[0], ",") {
+		integer, fraction = integer+fraction, "" // Thousands separator: 1,000
+	}
+	if rkm != "" && (fraction != "" || prefix == "") {
+		return siValue{}, false // Things like 1.2k3 or 123
+	}
+	if integer == "" && fraction == "" && prefix != "R" && prefix != "r" {
+		return siValue{}, false // Only R47 starts with the prefix; M3 is a screw.
+	}
+	if fraction == "" {
+		fraction = rkm
+	}
+	multiplier, found := siMultiplier[prefix]
+	if !found {
+		multiplier = siMultiplier[strings.ToLower(prefix)] // meg
+	}
+	number, err := strconv.ParseFloat(integer+"."+fraction+"0", 64)
+	if err != nil {
+		return siValue{}, false
+	}
+	// Leading zeros are never significant, trailing zeros only after the
+	// decimal point.
+	digits := strings.TrimLeft(integer+fraction, "0")
+	if fraction == "" {
+		digits = strings.TrimRight(digits, "0")
+	}
+	return siValue{
+		number: number * multiplier,
+		unit:   canonicalUnit(unit),
+		digits: len(digits),
+	}, true
+}
+
+// Parse an EIA code as printed on capacitors and SMD resistors, e.g.
+// "104" or "1002": the last digit is the power of ten (8 and 9 meaning
+// 0.01 and 0.1), multiplied by 'base', the unit of the code (1pF for
+// capacitors, 1Ω for resistors).
+func parseEIACode(code string, base float64) (float64, bool) {
+	m := eiaCode.FindStringSubmatch(code)
+	if m == nil {
+		return 0, false
+	}
+	value, _ := strconv.ParseFloat(m[1], 64)
+	var multiplier float64
+	switch exponent := m[2][0] - '0'; {
+	case exponent <= 6:
+		multiplier = math.Pow10(int(exponent))
+	case exponent == 8:
+		multiplier = 0.01
+	case exponent == 9:
+		multiplier = 0.1
+	default:
+		return 0, false
+	}
+	return value * multiplier * base, true
+}
+
+// Parse an EIA-96 code of a precision SMD resistor, e.g. "01C" for 10kΩ.
+func parseEIA96(code string) (float64, bool) {
+	m := eia96Code.FindStringSubmatch(strings.ToUpper(code))
+	if m == nil {
+		return 0, false
+	}
+	index, _ := strconv.Atoi(m[1])
+	if index < 1 || index > len(eia96Values) {
+		return 0, false
+	}
+	return eia96Values[index-1] * eia96Multiplier[m[2]], true
+}
+
+// Format the number with the largest of the given SI prefixes that keeps
+// the number in front of it at least 1, e.g. 4700 becomes "4.7k". If
+// digits is larger than what the number needs, trailing zeros are added
+// to show that many significant digits, e.g. "1.00k".
+func formatValue(number float64, unit string, prefixes []string, digits int) string {
+	prefix := prefixes[0]
+	for _, p := range prefixes {
+		if p == "" && number == 0 {
+			prefix = p
+			break
+		}
+		if roundValue(math.Abs(number)/siMultiplier[p]) >= 1 {
+			prefix = p
+		}
+	}
+	mantissa := strconv.FormatFloat(roundValue(number/siMultiplier[prefix]), 'f', -1, 64)
+
+	significant := len(strings.TrimLeft(strings.Replace(mantissa, ".", "", 1), "-0"))
+	if missing := digits - significant; missing > 0 {
+		if !strings.Contains(mantissa, ".") {
+			mantissa += "."
+		}
+		mantissa += strings.Repeat("0", missing)
+	}
+	return mantissa + prefix + unit
+}
+
+// Round away the noise of floating point calculations, e.g.
+// 99.99999999999999 for 0.1µ in n.
+func roundValue(number float64) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(number, 'g', 9, 64), 64)
+	return rounded
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func expectClose(t *testing.T, value float64, expected float64, msg string) {
+	ExpectTrue(t, math.Abs(value-expected) <= 1e-9*math.Abs(expected),
+		fmt.Sprintf("%s: %g vs. %g", msg, value, expected))
+}
+
+func TestParseValue(t *testing.T) {
+	for text, expected := range map[string]siValue{
+		"100nF":   {100e-9, "F", 1},
+		"0.1uF":   {0.1e-6, "F", 1},
+		"0.1µ":    {0.1e-6, "", 1},
+		"4k7":     {4.7e3, "", 2},
+		"4.7k":    {4.7e3, "", 2},
+		"4n7":     {4.7e-9, "", 2},
+		"2R2":     {2.2, "", 2},
+		"R47":     {0.47, "", 2},
+		"1,000":   {1000, "", 1},
+		"4,7k":    {4.7e3, "", 2},
+		"10kOhm":  {10e3, "ohm", 1},
+		"10 kOhm": {10e3, "ohm", 1},
+		"4k7Ω":    {4.7e3, "ohm", 2},
+		"2M2":     {2.2e6, "", 2},
+		"1meg":    {1e6, "", 1},
+		"470":     {470, "", 2},
+		".5":      {0.5, "", 1},
+		"1.00k":   {1e3, "", 3},
+		"0.015":   {0.015, "", 2},
+		"100mA":   {0.1, "A", 1},
+		"50V":     {50, "V", 1},
+		"16MHz":   {16e6, "Hz", 2},
+		"1,5uF":   {1.5e-6, "F", 2},
+	} {
+		value, ok := parseValue(text)
+		ExpectTrue(t, ok, text)
+		expectClose(t, value.number, expected.number, text)
+		expectEqual(t, value.unit, expected.unit)
+		ExpectTrue(t, value.digits == expected.digits,
+			fmt.Sprintf("%s: %d digits", text, value.digits))
+	}
+	for _, text := range []string{"2N3904", "LM358", "foo", "", "k", "1.2k3", "12k345", "10k x", "M3", "m3", "k47"} {
+		_, ok := parseValue(text)
+		ExpectTrue(t, !ok, text)
+	}
+}
+
+func TestParseEIACode(t *testing.T) {
+	for code, expected := range map[string]float64{
+		"104": 100e-9, "100": 10e-12, "479": 4.7e-12, "1002": 10e3 * 1e-12,
+	} {
+		value, ok := parseEIACode(code, 1e-12)
+		ExpectTrue(t, ok, code)
+		expectClose(t, value, expected, code)
+	}
+	for _, code := range []string{"107", "10", "10k", "12345"} {
+		_, ok := parseEIACode(code, 1)
+		ExpectTrue(t, !ok, code)
+	}
+
+	for code, expected := range map[string]float64{
+		"01C": 10e3, "68X": 49.9, "01A": 100, "96F": 97.6e6, "44r": 2.80,
+	} {
+		value, ok := parseEIA96(code)
+		ExpectTrue(t, ok, code)
+		expectClose(t, value, expected, code)
+	}
+	for _, code := range []string{"00C", "97A", "01K", "1C", "104"} {
+		_, ok := parseEIA96(code)
+		ExpectTrue(t, !ok, code)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	expectEqual(t, formatValue(4700, "", kResistorPrefixes, 0), "4.7k")
+	expectEqual(t, formatValue(4700, "", kResistorPrefixes, 3), "4.70k")
+	expectEqual(t, formatValue(1000, "", kResistorPrefixes, 3), "1.00k")
+	expectEqual(t, formatValue(10e3, "", kResistorPrefixes, 3), "10.0k")
+	expectEqual(t, formatValue(10e3, "", kResistorPrefixes, 1), "10k")
+	expectEqual(t, formatValue(0.47, "", kResistorPrefixes, 0), "0.47")
+	expectEqual(t, formatValue(2.2, "", kResistorPrefixes, 0), "2.2")
+	expectEqual(t, formatValue(0, "", kResistorPrefixes, 0), "0")
+	expectEqual(t, formatValue(0.1e-6, "F", kCapacitorPrefixes, 0), "100nF")
+	expectEqual(t, formatValue(1e-3, "F", kCapacitorPrefixes, 0), "1000uF")
+	expectEqual(t, formatValue(0.5e-12, "F", kCapacitorPrefixes, 0), "0.5pF")
+	expectEqual(t, formatValue(0.068e-6, "", []string{"n"}, 0), "68n")
+	expectEqual(t, formatValue(16e6, "Hz", kAllPrefixes, 0), "16MHz")
+	expectEqual(t, formatValue(-5, "V", kAllPrefixes, 0), "-5V")
+}
+
+// Everything we format, we can parse back.
+func TestValueRoundTrip(t *testing.T) {
+	for _, prefixes := range [][]string{kAllPrefixes, kResistorPrefixes, kCapacitorPrefixes} {
+		for exp := -13; exp <= 9; exp++ {
+			for _, mantissa := range []float64{1, 1.5, 2.2, 4.7, 4.75, 6.8, 9.99} {
+				number := mantissa * math.Pow10(exp)
+				for _, unit := range []string{"", "F", "V", "Hz"} {
+					text := formatValue(number, unit, prefixes, 3)
+					value, ok := parseValue(text)
+					ExpectTrue(t, ok, text)
+					expectClose(t, value.number, number, text)
+					expectEqual(t, value.unit, unit)
+					// Formatting it again results in the same.
+					expectEqual(t, formatValue(value.number, value.unit, prefixes, value.digits), text)
+				}
+			}
+		}
+	}
+
+	// And the canonical notation stays the same.
+	for _, text := range []string{"4.7k", "1.00k", "10k", "2.2", "0.47", "22M", "4.70k"} {
+		value, _ := parseValue(text)
+		expectEqual(t, formatValue(value.number, value.unit, kResistorPrefixes, value.digits), text)
+	}
+	for _, text := range []string{"100nF", "4.7uF", "22pF", "1.5nF"} {
+		value, _ := parseValue(text)
+		expectEqual(t, formatValue(value.number, value.unit, kCapacitorPrefixes, 0), text)
+	}
+}
@@ -53,51 +53,44 @@ type JsonApiStatusResult struct {
 	Items      []JsonStatus `json:"status"`
 }
 
+// Ad-hoc categorization of how well the component is documented: missing,
+// poor, fair or good. Or if it is empty or a mystery.
+func componentStatus(comp *Component) string {
+	if comp == nil {
+		return "missing"
+	}
+	if strings.Contains(strings.ToLower(comp.Category), "mystery") ||
+		strings.Contains(comp.Value, "?") {
+		return "mystery"
+	}
+	if strings.Contains(strings.ToLower(comp.Value), "empty") ||
+		strings.Contains(strings.ToLower(comp.Category), "empty") {
+		return "empty"
+	}
+	count := 0
+	if comp.Category != "" {
+		count++
+	}
+	if comp.Value != "" {
+		count++
+	}
+	// Description should be set. But for simple things such
+	// as resistors or capacitors, we see just one value
+	// to be sufficient. Totally hacky classification :)
+	if comp.Description != "" ||
+		(comp.Category == "Resistor" && comp.Value != "") ||
+		(comp.Category == "Capacitor (C)" && comp.Value != "") {
+		count++
+	}
+	return []string{"missing", "poor", "fair", "good"}[count]
+}
+
 func fillStatusItem(store StuffStore, imageDir string, id int, item *StatusItem) {
-	comp := store.FindById(id)
 	item.Number = id
-	if comp != nil {
-		// Ad-hoc categorization...
-		count := 0
-		if comp.Category != "" {
-			count++
-		}
-		if comp.Value != "" {
-			count++
-		}
-		// Description should be set. But for simple things such
-		// as resistors or capacitors, we see just one value
-		// to be sufficient. Totally hacky classification :)
-		if comp.Description != "" ||
-			(comp.Category == "Resistor" && comp.Value != "") ||
-			(comp.Category == "Capacitor (C)" && comp.Value != "") {
-			count++
-		}
-		switch count {
-		case 0:
-			item.Status = "missing"
-		case 1:
-			item.Status = "poor"
-		case 2:
-			item.Status = "fair"
-		case 3:
-			item.Status = "good"
-		}
-		if strings.Contains(strings.ToLower(comp.Value), "empty") ||
-			strings.Contains(strings.ToLower(comp.Category), "empty") {
-			item.Status = "empty"
-		}
-		if strings.Contains(strings.ToLower(comp.Category), "mystery") ||
-			strings.Contains(comp.Value, "?") {
-			item.Status = "mystery"
-		}
-	} else {
-		item.Status = "missing"
-	}
+	item.Status = componentStatus(store.FindById(id))
 	if _, err := os.Stat(fmt.Sprintf("%s/%d.jpg", imageDir, id)); err == nil {
 		item.HasPicture = true
 	}
-
 }
 
 func (h *StatusHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
@@ -1,5 +1,9 @@
 package main
 
+import (
+	"time"
+)
+
 type Component struct {
 	Id            int    `json:"id"`
 	Equiv_set     int    `json:"equiv_set,omitempty"`
@@ -11,6 +15,45 @@ type Component struct {
 	Datasheet_url string `json:"datasheet_url,omitempty"`
 	Drawersize    int    `json:"drawersize,omitempty"`
 	Footprint     string `json:"footprint,omitempty"`
+	Vendor        string `json:"vendor,omitempty"`
+	Min_stock     int    `json:"min_stock,omitempty"` // 0: category default
+
+	// Maintained by the store. Might be nil for very old records.
+	Created *time.Time `json:"created,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// Time of the last change of the component, or nil if not known.
+func lastChange(c *Component) *time.Time {
+	if c.Updated != nil {
+		return c.Updated
+	}
+	return c.Created
+}
+
+// Additional information about an equivalence set ("virtual drawer").
+type EquivSetInfo struct {
+	Id       int    `json:"id"` // The equiv_set, i.e. lowest member ID.
+	Name     string `json:"name,omitempty"`
+	Note     string `json:"note,omitempty"`
+	Location string `json:"location,omitempty"` // Target physical location
+}
+
+// A part that can be used instead of another one, e.g. 1N914 for 1N4148.
+// Substitutes work both ways.
+type PartSubstitute struct {
+	Part       string `json:"part"`
+	Substitute string `json:"substitute"`
+	Note       string `json:"note,omitempty"` // e.g. "different pinout"
+}
+
+// How often a search query has been used, see search-analytics.go.
+type SearchStats struct {
+	Query       string    `json:"query"` // Lowercase, single spaces.
+	Count       int       `json:"count"`
+	ZeroResults int       `json:"zero_results"` // How often nothing was found.
+	Results     int       `json:"results"`      // Number of results last time.
+	LastSeen    time.Time `json:"last_seen"`
 }
 
 // Modify a user pointer. Returns 'true' if the changes should be commited.
@@ -36,6 +79,50 @@ type StuffStore interface {
 	// (which is equiv_set == id)
 	LeaveSet(id int)
 
+	// Move component to a different ID, e.g. when reorganizing drawers.
+	// The target ID must not exist or be marked as empty bin. Set
+	// memberships are kept. Requests to the old ID can be redirected with
+	// FindRedirect().
+	MoveComponent(from int, to int) error
+
+	// Swap the components of the two given IDs.
+	SwapComponents(a int, b int) error
+
+	// Returns the ID the component with the given ID has been moved to
+	// or 0 if it has not been moved.
+	FindRedirect(id int) int
+
+	// All components in the given equivalence set, ordered by ID.
+	EquivSetMembers(set int) []*Component
+
+	// Get name, note and location of the given equivalence set. Returns
+	// nil if there is none. The info moves along with the set if it
+	// changes its ID.
+	FindEquivSetInfo(set int) *EquivSetInfo
+
+	// Store the set info. All empty fields remove it.
+	EditEquivSetInfo(info EquivSetInfo)
+
+	// All the sets that have info attached, ordered by set ID.
+	AllEquivSetInfo() []*EquivSetInfo
+
+	// Don't suggest the equivalence set with the given key again.
+	RejectSetSuggestion(key string)
+
+	// Keys of all rejected set suggestions.
+	RejectedSetSuggestions() map[string]bool
+
+	// Set the function the search uses to find out if there is an
+	// image for a component (has:image).
+	SetImageChecker(has_image func(id int) bool)
+
+	// Set the synonyms search queries are expanded with.
+	SetSynonyms(synonyms *SynonymDictionary)
+
+	// Set how much stock, status, image and completeness of components
+	// change their rank in search results.
+	SetRankingWeights(weights RankingWeights)
+
 	// Get possible matching components of given component,
 	// including all the components that are in the sets the matches
 	// are in.
@@ -46,6 +133,51 @@ type StuffStore interface {
 	// by some internal scoring system. Don't modify the returned objects!
 	Search(search_term string) *SearchResult
 
+	// Like Search, but only the best limit results. The Total of the
+	// result still counts all of them.
+	SearchTop(search_term string, limit int) *SearchResult
+
+	// Components similar to the given one, most similar first. Used
+	// for like: searches.
+	SimilarComponents(id int) []SimilarComponent
+
+	// Store the text extracted from the datasheet of the component, so
+	// that it is searched. Empty text removes it.
+	SetDatasheetText(id int, text string)
+
+	// IDs of the components that have datasheet text stored.
+	DatasheetTextIds() map[int]bool
+
 	// Iterate through all elements.
 	IterateAll(func(comp *Component) bool)
+
+	// Return up to "limit" components ordered by their last change, most
+	// recent first. Components without timestamps are not returned.
+	RecentlyChanged(limit int) []*Component
+
+	// Default minimum stock per category, used for components that
+	// don't have their own Min_stock set.
+	CategoryMinStock() map[string]int
+
+	// Set the default minimum stock for a category. A value <= 0
+	// removes the default.
+	SetCategoryMinStock(category string, min_stock int)
+
+	// All part substitutes, ordered by part.
+	PartSubstitutes() []*PartSubstitute
+
+	// Add a part substitute, or update its note.
+	EditPartSubstitute(substitute PartSubstitute) error
+
+	// Remove the given substitute of a part, in either direction.
+	RemovePartSubstitute(part string, substitute string)
+
+	// Count a search for the given query that had the given number of
+	// results.
+	RecordSearch(query string, results int)
+
+	// Most frequent search queries, at most "limit". With zero_results,
+	// only the queries that did not find anything the last time,
+	// ordered by how often that happened.
+	TopSearches(limit int, zero_results bool) []*SearchStats
 }
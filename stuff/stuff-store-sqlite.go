@@ -4,7 +4,11 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -25,6 +29,7 @@ create table component (
        footprint     varchar(30),
        quantity      varchar(5),   -- Initially text to allow freeform e.g '< 50'
        drawersize    int,          -- 0=small, 1=medium, 2=large
+       min_stock     int,          -- restock threshold; 0: category default
 
        created timestamp,
        updated timestamp,
@@ -36,6 +41,122 @@ create table component (
 );
 `
 
+// Tables that have been added after the initial schema. These are created
+// on startup if they don't exist yet, so older databases get them as well.
+var create_additional_tables string = `
+create table if not exists category_min_stock (
+       category      varchar(40) constraint pk_category_min_stock primary key,
+       min_stock     int not null
+);
+
+-- Name, note and target location of an equivalence set. Keyed by the
+-- equiv_set value, so moves along when the lowest member of the set changes.
+create table if not exists equiv_set_info (
+       set_id        int constraint pk_equiv_set_info primary key,
+       name          varchar(80),
+       note          text,
+       location      varchar(80)
+);
+
+-- Suggested equivalence sets that have been rejected, so that they are
+-- not suggested again.
+create table if not exists rejected_set_suggestion (
+       suggestion_key varchar(200) constraint pk_rejected_set_suggestion primary key,
+       created        timestamp
+);
+
+-- When components are moved to a different bin, the old ID redirects.
+create table if not exists component_redirect (
+       old_id        int constraint pk_component_redirect primary key,
+       new_id        int not null
+);
+
+-- Parts that can substitute each other, in both directions.
+create table if not exists part_substitute (
+       part          varchar(80) not null,
+       substitute    varchar(80) not null,
+       note          text,
+       constraint pk_part_substitute primary key (part, substitute)
+);
+
+-- Search queries, aggregated, so that we know what people look for.
+create table if not exists search_query (
+       query         varchar(200) constraint pk_search_query primary key,
+       count         int not null,
+       zero_results  int not null, -- number of times nothing was found.
+       results       int not null, -- number of results last time.
+       last_seen     timestamp
+);
+
+-- Text extracted from the uploaded datasheet PDFs, for the search.
+create table if not exists datasheet_text (
+       component     int constraint pk_datasheet_text primary key,
+       text          text not null
+);
+
+-- Counts changes to components, so that we know if a persisted search
+-- index is still valid. Maintained by triggers, so it also catches
+-- changes made outside this program.
+create table if not exists change_counter (
+       id            int constraint pk_change_counter primary key,
+       changes       int not null
+);
+insert or ignore into change_counter (id, changes) values (1, 0);
+create trigger if not exists component_insert_count after insert on component
+begin
+       update change_counter set changes = changes + 1 where id = 1;
+end;
+create trigger if not exists component_update_count after update on component
+begin
+       update change_counter set changes = changes + 1 where id = 1;
+end;
+create trigger if not exists component_delete_count after delete on component
+begin
+       update change_counter set changes = changes + 1 where id = 1;
+end;
+`
+
+// Columns that have been added to the component table after the initial
+// schema. Added to older databases on startup.
+var added_component_columns = []struct {
+	name string
+	decl string
+}{
+	{"min_stock", "int"},
+}
+
+func upgradeSchema(db *sql.DB) error {
+	existing := make(map[string]bool)
+	rows, err := db.Query("PRAGMA table_info(component)")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notnull int
+		var dflt *string
+		var pk int
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	for _, col := range added_component_columns {
+		if existing[col.name] {
+			continue
+		}
+		log.Printf("Adding column %s to component table", col.name)
+		if _, err := db.Exec("ALTER TABLE component ADD COLUMN " + col.name + " " + col.decl); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(create_additional_tables)
+	return err
+}
+
 func nullIfEmpty(s string) *string {
 	if s == "" {
 		return nil
@@ -63,15 +184,24 @@ func row2Component(row *sql.Rows) (*Component, error) {
 		datasheet   *string
 		drawersize  *int
 		footprint   *string
+		vendor      *string
+		min_stock   *int
+		created     *time.Time
+		updated     *time.Time
 	}
 	rec := &ReadRecord{}
 	err := row.Scan(&rec.id, &rec.category, &rec.value,
 		&rec.description, &rec.notes, &rec.quantity, &rec.datasheet,
-		&rec.drawersize, &rec.footprint, &rec.equiv_set)
+		&rec.drawersize, &rec.footprint, &rec.vendor, &rec.min_stock,
+		&rec.equiv_set, &rec.created, &rec.updated)
 	drawersize := 0
 	if rec.drawersize != nil {
 		drawersize = *rec.drawersize
 	}
+	min_stock := 0
+	if rec.min_stock != nil {
+		min_stock = *rec.min_stock
+	}
 	switch {
 	case err == sql.ErrNoRows:
 		return nil, nil // no rows are ok error.
@@ -89,6 +219,10 @@ func row2Component(row *sql.Rows) (*Component, error) {
 			Datasheet_url: emptyIfNull(rec.datasheet),
 			Drawersize:    drawersize,
 			Footprint:     emptyIfNull(rec.footprint),
+			Vendor:        emptyIfNull(rec.vendor),
+			Min_stock:     min_stock,
+			Created:       rec.created,
+			Updated:       rec.updated,
 		}
 		return result, nil
 	}
@@ -96,27 +230,52 @@ func row2Component(row *sql.Rows) (*Component, error) {
 }
 
 type SqlStuffStore struct {
-	db            *sql.DB
-	findById      *sql.Stmt
-	insertRecord  *sql.Stmt
-	updateRecord  *sql.Stmt
-	joinSet       *sql.Stmt
-	leaveSet      *sql.Stmt
-	findEquivById *sql.Stmt
-	selectAll     *sql.Stmt
-	fts           *FulltextSearch
+	db              *sql.DB
+	findById        *sql.Stmt
+	insertRecord    *sql.Stmt
+	updateRecord    *sql.Stmt
+	joinSet         *sql.Stmt
+	leaveSet        *sql.Stmt
+	selectAll       *sql.Stmt
+	equivSetMembers *sql.Stmt
+	selectMinStock  *sql.Stmt
+	setMinStock     *sql.Stmt
+	fts             *FulltextSearch
+
+	// Persisted search index; empty if not used.
+	searchIndexFile  string
+	savedIndexChange int64 // Change counter when index was last saved.
+	// Held shared while modifying components, exclusively while saving
+	// the search index, so that it is consistent with the change counter.
+	mutation sync.RWMutex
 }
 
+// How often to check if the persisted search index needs to be updated.
+const kSearchIndexSaveInterval = 5 * time.Minute
+
 func NewSqlStuffStore(db *sql.DB, create_tables bool) (*SqlStuffStore, error) {
+	return NewSqlStuffStoreWithSearchIndex(db, create_tables, "")
+}
+
+// Like NewSqlStuffStore(), but keeps the full text search index in the
+// given file, so that startup does not have to read all components from the
+// database. The file is only used if it is still up to date with the
+// database. An empty filename disables this.
+func NewSqlStuffStoreWithSearchIndex(db *sql.DB, create_tables bool, index_file string) (*SqlStuffStore, error) {
 	if create_tables {
 		_, err := db.Exec(create_schema)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
+	if err := upgradeSchema(db); err != nil {
+		return nil, err
+	}
 	// All the fields in a component.
-	all_fields := "category, value, description, notes, quantity, datasheet_url,drawersize,footprint,equiv_set"
-	findById, err := db.Prepare("SELECT id, " + all_fields + " FROM component where id=$1")
+	all_fields := "category, value, description, notes, quantity, datasheet_url,drawersize,footprint,vendor,min_stock,equiv_set"
+	// Fields to read; the timestamps are maintained by the store.
+	read_fields := all_fields + ",created,updated"
+	findById, err := db.Prepare("SELECT id, " + read_fields + " FROM component where id=$1")
 	if err != nil {
 		return nil, err
 	}
@@ -125,12 +284,12 @@ func NewSqlStuffStore(db *sql.DB, create_tables bool) (*SqlStuffStore, error) {
 	// component update, we explicitly do not want to update the
 	// membership to the set, so we don't touch these fields.
 	insertRecord, err := db.Prepare("INSERT INTO component (id, created, updated, " + all_fields + ") " +
-		" VALUES (?1, ?2, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?1)")
+		" VALUES (?1, ?2, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?1)")
 	if err != nil {
 		return nil, err
 	}
 	updateRecord, err := db.Prepare("UPDATE component SET " +
-		"updated=?2, category=?3, value=?4, description=?5, notes=?6, quantity=?7, datasheet_url=?8, drawersize=?9, footprint=?10 WHERE id=?1")
+		"updated=?2, category=?3, value=?4, description=?5, notes=?6, quantity=?7, datasheet_url=?8, drawersize=?9, footprint=?10, vendor=?11, min_stock=?12 WHERE id=?1")
 	if err != nil {
 		return nil, err
 	}
@@ -146,46 +305,119 @@ func NewSqlStuffStore(db *sql.DB, create_tables bool) (*SqlStuffStore, error) {
 		return nil, err
 	}
 
-	// We want all articles that match the same (category, name), but also
-	// all that are in the sets that are covered in any set the matching
-	// components are in.
-	// Todo: maybe in-memory and more lenient way to match values
-	findEquivById, err := db.Prepare(`
-	    SELECT id, ` + all_fields + ` FROM component where equiv_set in
-	        (select c2.equiv_set from component c1, component c2
-	          where lower(c1.value) = lower(c2.value)
-	            and c1.category = c2.category and c1.id = ?1)
-	    ORDER BY equiv_set, id`)
+	selectAll, err := db.Prepare("SELECT id, " + read_fields + " FROM component ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
 
-	selectAll, err := db.Prepare("SELECT id, " + all_fields + " FROM component ORDER BY id")
+	equivSetMembers, err := db.Prepare("SELECT id, " + read_fields + " FROM component WHERE equiv_set=?1 ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
-	// Populate fts with existing components.
-	fts := NewFulltextSearch()
-	rows, _ := selectAll.Query()
+
+	selectMinStock, err := db.Prepare("SELECT category, min_stock FROM category_min_stock")
+	if err != nil {
+		return nil, err
+	}
+	setMinStock, err := db.Prepare("INSERT OR REPLACE INTO category_min_stock (category, min_stock) VALUES (?1, ?2)")
+	if err != nil {
+		return nil, err
+	}
+	store := &SqlStuffStore{
+		db:              db,
+		findById:        findById,
+		insertRecord:    insertRecord,
+		updateRecord:    updateRecord,
+		joinSet:         joinSet,
+		leaveSet:        leaveSet,
+		selectAll:       selectAll,
+		equivSetMembers: equivSetMembers,
+		selectMinStock:  selectMinStock,
+		setMinStock:     setMinStock,
+		fts:             NewFulltextSearch(),
+		searchIndexFile: index_file,
+	}
+	store.populateSearch()
+	store.fts.SetSubstitutes(store.PartSubstitutes())
+	store.fts.SetDatasheets(store.datasheetTexts(nil))
+	if index_file != "" {
+		go store.saveSearchIndexPeriodically()
+	}
+	return store, nil
+}
+
+// Populate fts with existing components, from the persisted index if
+// possible.
+func (d *SqlStuffStore) populateSearch() {
+	set_names, _ := d.db.Query("SELECT set_id, name FROM equiv_set_info WHERE name IS NOT NULL")
+	for set_names != nil && set_names.Next() {
+		var set int
+		var name string
+		if set_names.Scan(&set, &name) == nil {
+			d.fts.SetEquivSetName(set, name)
+		}
+	}
+	if set_names != nil {
+		set_names.Close()
+	}
+
+	if d.searchIndexFile != "" {
+		changes := d.changeCounter()
+		err := d.fts.Load(d.searchIndexFile, changes)
+		if err == nil {
+			d.savedIndexChange = changes
+			log.Printf("Loaded full text search from %s", d.searchIndexFile)
+			return
+		}
+		log.Printf("Can't use search index %s: %v", d.searchIndexFile, err)
+	}
+
+	rows, _ := d.selectAll.Query()
 	count := 0
 	for rows != nil && rows.Next() {
 		c, _ := row2Component(rows)
-		fts.Update(c)
+		d.fts.Update(c)
 		count++
 	}
 	rows.Close()
-
 	log.Printf("Prepopulated full text search with %d items", count)
-	return &SqlStuffStore{
-		db:            db,
-		findById:      findById,
-		insertRecord:  insertRecord,
-		updateRecord:  updateRecord,
-		joinSet:       joinSet,
-		leaveSet:      leaveSet,
-		findEquivById: findEquivById,
-		selectAll:     selectAll,
-		fts:           fts}, nil
+
+	if d.searchIndexFile != "" {
+		d.saveSearchIndex()
+	}
+}
+
+// Number of changes to the component table ever. Negative on error.
+func (d *SqlStuffStore) changeCounter() int64 {
+	var changes int64
+	err := d.db.QueryRow("SELECT changes FROM change_counter WHERE id=1").Scan(&changes)
+	if err != nil {
+		log.Printf("Reading change counter: %v", err)
+		return -1
+	}
+	return changes
+}
+
+func (d *SqlStuffStore) saveSearchIndex() {
+	d.mutation.Lock()
+	defer d.mutation.Unlock()
+	changes := d.changeCounter()
+	if changes < 0 {
+		return
+	}
+	if err := d.fts.Save(d.searchIndexFile, changes); err != nil {
+		log.Printf("Saving search index to %s: %v", d.searchIndexFile, err)
+		return
+	}
+	d.savedIndexChange = changes
+}
+
+func (d *SqlStuffStore) saveSearchIndexPeriodically() {
+	for range time.Tick(kSearchIndexSaveInterval) {
+		if d.changeCounter() != d.savedIndexChange {
+			d.saveSearchIndex()
+		}
+	}
 }
 
 func (d *SqlStuffStore) FindById(id int) *Component {
@@ -212,6 +444,8 @@ func (d *SqlStuffStore) IterateAll(callback func(comp *Component) bool) {
 }
 
 func (d *SqlStuffStore) EditRecord(id int, update ModifyFun) (bool, string) {
+	d.mutation.RLock()
+	defer d.mutation.RUnlock()
 	needsInsert := false
 	rec := d.FindById(id)
 	if rec == nil {
@@ -225,6 +459,8 @@ func (d *SqlStuffStore) EditRecord(id int, update ModifyFun) (bool, string) {
 		}
 		// We're not in the business in modifying this.
 		rec.Equiv_set = before.Equiv_set
+		rec.Created = before.Created
+		rec.Updated = before.Updated
 
 		if *rec == before {
 			return false, "No change."
@@ -237,11 +473,13 @@ func (d *SqlStuffStore) EditRecord(id int, update ModifyFun) (bool, string) {
 		} else {
 			toExec = d.updateRecord
 		}
-		result, err := toExec.Exec(id, time.Now(),
+		now := time.Now()
+		result, err := toExec.Exec(id, now,
 			nullIfEmpty(rec.Category), nullIfEmpty(rec.Value),
 			nullIfEmpty(rec.Description), nullIfEmpty(rec.Notes),
 			nullIfEmpty(rec.Quantity), nullIfEmpty(rec.Datasheet_url),
-			rec.Drawersize, rec.Footprint)
+			rec.Drawersize, rec.Footprint, nullIfEmpty(rec.Vendor),
+			rec.Min_stock)
 
 		if err != nil {
 			log.Printf("Oops: %s", err)
@@ -252,6 +490,11 @@ func (d *SqlStuffStore) EditRecord(id int, update ModifyFun) (bool, string) {
 			log.Printf("Oops, expected 1 row to update but was %d", affected)
 			return false, "ERR: not updated"
 		}
+		if needsInsert {
+			rec.Equiv_set = id // New components start in their own set.
+			rec.Created = &now
+		}
+		rec.Updated = &now
 		d.fts.Update(rec)
 
 		json, _ := json.Marshal(rec)
@@ -263,38 +506,595 @@ func (d *SqlStuffStore) EditRecord(id int, update ModifyFun) (bool, string) {
 }
 
 func (d *SqlStuffStore) JoinSet(id int, set int) {
-	d.LeaveSet(id) // precondition.
+	d.mutation.RLock()
+	defer d.mutation.RUnlock()
+	d.removeFromSet(id) // precondition.
+	// The info of the set joined wins over the one the component had
+	// on its own.
+	if _, err := d.db.Exec("DELETE FROM equiv_set_info WHERE set_id=?1", id); err != nil {
+		log.Printf("Best effort JoinSet() info fail: %v.", err)
+	}
 	_, err := d.joinSet.Exec(id, set)
 	if err != nil {
 		log.Printf("Best effort JoinSet() fail: %v.", err)
 	}
+	if id < set {
+		d.moveEquivSetInfo(set, id)
+	}
+	d.refreshSearch(id)
 }
 
 func (d *SqlStuffStore) LeaveSet(id int) {
+	d.mutation.RLock()
+	defer d.mutation.RUnlock()
+	d.removeFromSet(id)
+}
+
+func (d *SqlStuffStore) removeFromSet(id int) {
 	// The limited way SQLite works, we have to find the equivalence
 	// set first before we can update. Not really efficient, and we
 	// would need a transaction here, but, yeah, good enough for a
 	// 0.001 qps service :)
 	c := d.FindById(id)
 	if c != nil {
-		_, err := d.leaveSet.Exec(id, c.Equiv_set)
+		// Lowest of the remaining members, which will be the new set ID.
+		var remaining *int
+		err := d.db.QueryRow("SELECT min(id) FROM component WHERE equiv_set=?1 AND id != ?2",
+			c.Equiv_set, id).Scan(&remaining)
 		if err != nil {
 			log.Printf("Best effort LeaveSet() fail: %v.", err)
 		}
+		_, err = d.leaveSet.Exec(id, c.Equiv_set)
+		if err != nil {
+			log.Printf("Best effort LeaveSet() fail: %v.", err)
+		}
+		if remaining == nil {
+			return // Was on its own anyway.
+		}
+		if c.Equiv_set == id {
+			// The info stays with the set, not with the one leaving.
+			d.moveEquivSetInfo(id, *remaining)
+		}
+		d.refreshSearch(id, *remaining)
+	}
+}
+
+// Let the equivalence set info follow when the set gets a new ID.
+func (d *SqlStuffStore) moveEquivSetInfo(from_set int, to_set int) {
+	_, err := d.db.Exec("UPDATE OR REPLACE equiv_set_info SET set_id=?2 WHERE set_id=?1",
+		from_set, to_set)
+	if err != nil {
+		log.Printf("Best effort moving set info fail: %v.", err)
+	}
+	d.fts.SetEquivSetName(from_set, "")
+	if info := d.FindEquivSetInfo(to_set); info != nil {
+		d.fts.SetEquivSetName(to_set, info.Name)
 	}
 }
 
 func (d *SqlStuffStore) MatchingEquivSetForComponent(id int) []*Component {
-	result := make([]*Component, 0, 10)
-	rows, _ := d.findEquivById.Query(id)
+	return d.fts.RelatedComponents(id)
+}
+
+func (d *SqlStuffStore) Search(search_term string) *SearchResult {
+	return d.fts.Search(search_term)
+}
+
+func (d *SqlStuffStore) SearchTop(search_term string, limit int) *SearchResult {
+	return d.fts.SearchTop(search_term, limit)
+}
+
+func (d *SqlStuffStore) SimilarComponents(id int) []SimilarComponent {
+	return d.fts.Similar(id)
+}
+
+func (d *SqlStuffStore) SetImageChecker(has_image func(id int) bool) {
+	d.fts.SetImageChecker(has_image)
+}
+
+func (d *SqlStuffStore) SetSynonyms(synonyms *SynonymDictionary) {
+	d.fts.SetSynonyms(synonyms)
+}
+
+func (d *SqlStuffStore) SetRankingWeights(weights RankingWeights) {
+	d.fts.SetRankingWeights(weights)
+}
+
+func (d *SqlStuffStore) CategoryMinStock() map[string]int {
+	result := make(map[string]int)
+	rows, _ := d.selectMinStock.Query()
 	for rows != nil && rows.Next() {
-		c, _ := row2Component(rows)
-		result = append(result, c)
+		var category string
+		var min_stock int
+		if rows.Scan(&category, &min_stock) == nil {
+			result[category] = min_stock
+		}
+	}
+	if rows != nil {
+		rows.Close()
 	}
-	rows.Close()
 	return result
 }
 
-func (d *SqlStuffStore) Search(search_term string) *SearchResult {
-	return d.fts.Search(search_term)
+func (d *SqlStuffStore) SetCategoryMinStock(category string, min_stock int) {
+	var err error
+	if min_stock <= 0 {
+		_, err = d.db.Exec("DELETE FROM category_min_stock WHERE category=?1", category)
+	} else {
+		_, err = d.setMinStock.Exec(category, min_stock)
+	}
+	if err != nil {
+		log.Printf("SetCategoryMinStock(%q) fail: %v", category, err)
+	}
+}
+
+func (d *SqlStuffStore) PartSubstitutes() []*PartSubstitute {
+	result := make([]*PartSubstitute, 0)
+	rows, err := d.db.Query("SELECT part, substitute, note FROM part_substitute ORDER BY part, substitute")
+	if err != nil {
+		log.Printf("PartSubstitutes() fail: %v", err)
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := &PartSubstitute{}
+		var note *string
+		if rows.Scan(&s.Part, &s.Substitute, &note) == nil {
+			s.Note = emptyIfNull(note)
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (d *SqlStuffStore) EditPartSubstitute(s PartSubstitute) error {
+	if err := cleanPartSubstitute(&s); err != nil {
+		return err
+	}
+	// Substitutes work both ways: update the reverse if we have that.
+	result, err := d.db.Exec("UPDATE part_substitute SET note=?3 WHERE "+
+		"(part=?1 COLLATE NOCASE AND substitute=?2 COLLATE NOCASE) OR "+
+		"(part=?2 COLLATE NOCASE AND substitute=?1 COLLATE NOCASE)",
+		s.Part, s.Substitute, nullIfEmpty(s.Note))
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		_, err = d.db.Exec("INSERT INTO part_substitute (part, substitute, note) VALUES (?1, ?2, ?3)",
+			s.Part, s.Substitute, nullIfEmpty(s.Note))
+		if err != nil {
+			return err
+		}
+	}
+	d.fts.SetSubstitutes(d.PartSubstitutes())
+	return nil
+}
+
+func (d *SqlStuffStore) RemovePartSubstitute(part string, substitute string) {
+	_, err := d.db.Exec("DELETE FROM part_substitute WHERE "+
+		"(part=?1 COLLATE NOCASE AND substitute=?2 COLLATE NOCASE) OR "+
+		"(part=?2 COLLATE NOCASE AND substitute=?1 COLLATE NOCASE)",
+		part, substitute)
+	if err != nil {
+		log.Printf("RemovePartSubstitute(%q, %q) fail: %v", part, substitute, err)
+	}
+	d.fts.SetSubstitutes(d.PartSubstitutes())
+}
+
+func (d *SqlStuffStore) SetDatasheetText(id int, text string) {
+	var err error
+	if text == "" {
+		_, err = d.db.Exec("DELETE FROM datasheet_text WHERE component=?1", id)
+	} else {
+		_, err = d.db.Exec("INSERT OR REPLACE INTO datasheet_text (component, text) VALUES (?1, ?2)", id, text)
+	}
+	if err != nil {
+		log.Printf("SetDatasheetText(%d) fail: %v", id, err)
+	}
+	d.fts.SetDatasheet(id, text)
+}
+
+func (d *SqlStuffStore) DatasheetTextIds() map[int]bool {
+	result := make(map[int]bool)
+	rows, err := d.db.Query("SELECT component FROM datasheet_text")
+	if err != nil {
+		log.Printf("DatasheetTextIds() fail: %v", err)
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			result[id] = true
+		}
+	}
+	return result
+}
+
+// The datasheet texts of the given components, empty for the ones that
+// have none. All of them if ids is nil.
+func (d *SqlStuffStore) datasheetTexts(ids []int) map[int]string {
+	result := make(map[int]string)
+	if ids != nil {
+		for _, id := range ids {
+			var text string
+			_ = d.db.QueryRow("SELECT text FROM datasheet_text WHERE component=?1", id).Scan(&text)
+			result[id] = text
+		}
+		return result
+	}
+	rows, err := d.db.Query("SELECT component, text FROM datasheet_text")
+	if err != nil {
+		log.Printf("datasheetTexts() fail: %v", err)
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var text string
+		if rows.Scan(&id, &text) == nil {
+			result[id] = text
+		}
+	}
+	return result
+}
+
+func (d *SqlStuffStore) RecordSearch(query string, results int) {
+	zero := 0
+	if results == 0 {
+		zero = 1
+	}
+	_, err := d.db.Exec("INSERT INTO search_query (query, count, zero_results, results, last_seen) VALUES (?1, 1, ?2, ?3, ?4) "+
+		"ON CONFLICT(query) DO UPDATE SET count=count+1, zero_results=zero_results+?2, results=?3, last_seen=?4",
+		query, zero, results, time.Now())
+	if err != nil {
+		log.Printf("RecordSearch(%q) fail: %v", query, err)
+	}
+}
+
+func (d *SqlStuffStore) TopSearches(limit int, zero_results bool) []*SearchStats {
+	query := "SELECT query, count, zero_results, results, last_seen FROM search_query ORDER BY count DESC, query LIMIT ?1"
+	if zero_results {
+		query = "SELECT query, count, zero_results, results, last_seen FROM search_query WHERE results=0 ORDER BY zero_results DESC, query LIMIT ?1"
+	}
+	result := make([]*SearchStats, 0)
+	rows, err := d.db.Query(query, limit)
+	if err != nil {
+		log.Printf("TopSearches() fail: %v", err)
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		stats := &SearchStats{}
+		var last_seen *time.Time
+		if rows.Scan(&stats.Query, &stats.Count, &stats.ZeroResults, &stats.Results, &last_seen) != nil {
+			continue
+		}
+		if last_seen != nil {
+			stats.LastSeen = *last_seen
+		}
+		result = append(result, stats)
+	}
+	return result
+}
+
+func (d *SqlStuffStore) RecentlyChanged(limit int) []*Component {
+	// Older records might have the timestamps stored in different formats,
+	// so sorting is not reliable in SQL. Do it here.
+	result := make([]*Component, 0, 100)
+	d.IterateAll(func(c *Component) bool {
+		if lastChange(c) != nil {
+			result = append(result, c)
+		}
+		return true
+	})
+	sort.SliceStable(result, func(a, b int) bool {
+		return lastChange(result[a]).After(*lastChange(result[b]))
+	})
+	if limit >= 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+func (d *SqlStuffStore) FindRedirect(id int) int {
+	var new_id int
+	err := d.db.QueryRow("SELECT new_id FROM component_redirect WHERE old_id=?1", id).Scan(&new_id)
+	if err != nil {
+		return 0
+	}
+	return new_id
+}
+
+func (d *SqlStuffStore) MoveComponent(from int, to int) error {
+	d.mutation.RLock()
+	defer d.mutation.RUnlock()
+	if from == to {
+		return errors.New("Same ID")
+	}
+	if d.FindById(from) == nil {
+		return fmt.Errorf("No component with ID %d", from)
+	}
+	target := d.FindById(to)
+	if target != nil && !isEmptyBin(target) {
+		return fmt.Errorf("Bin %d is not empty", to)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if target != nil {
+		// Make room, but let the other members of its set stay together.
+		if _, err = tx.Exec("DELETE FROM component WHERE id=?1", to); err != nil {
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM datasheet_text WHERE component=?1", to); err != nil {
+			return err
+		}
+		if err = fixEquivSet(tx, target.Equiv_set); err != nil {
+			return err
+		}
+	}
+	if err = renumberComponents(tx, map[int]int{from: to}); err != nil {
+		return err
+	}
+	// Existing redirects to the old ID now point to the new one.
+	if _, err = tx.Exec("DELETE FROM component_redirect WHERE old_id=?1", to); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE component_redirect SET new_id=?2 WHERE new_id=?1", from, to); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT OR REPLACE INTO component_redirect (old_id, new_id) VALUES (?1, ?2)", from, to); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("MOVE %d -> %d", from, to)
+	d.fts.Remove(from)
+	d.fts.Remove(to)
+	d.refreshSearch(to)
+	d.fts.SetDatasheets(d.datasheetTexts([]int{from, to}))
+	return nil
+}
+
+func (d *SqlStuffStore) SwapComponents(a int, b int) error {
+	d.mutation.RLock()
+	defer d.mutation.RUnlock()
+	if a == b {
+		return errors.New("Same ID")
+	}
+	if d.FindById(a) == nil || d.FindById(b) == nil {
+		return fmt.Errorf("Need existing components %d and %d to swap", a, b)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = renumberComponents(tx, map[int]int{a: b, b: a}); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("SWAP %d <-> %d", a, b)
+	d.refreshSearch(a, b)
+	d.fts.SetDatasheets(d.datasheetTexts([]int{a, b}))
+	return nil
+}
+
+// Update the full text search for the given components and all the members
+// of the sets they are in.
+func (d *SqlStuffStore) refreshSearch(ids ...int) {
+	for _, id := range ids {
+		c := d.FindById(id)
+		if c == nil {
+			continue
+		}
+		rows, _ := d.db.Query("SELECT id FROM component WHERE equiv_set=?1", c.Equiv_set)
+		members := make([]int, 0)
+		for rows != nil && rows.Next() {
+			var member int
+			if rows.Scan(&member) == nil {
+				members = append(members, member)
+			}
+		}
+		if rows != nil {
+			rows.Close()
+		}
+		for _, member := range members {
+			d.fts.Update(d.FindById(member))
+		}
+	}
+}
+
+// Let the equivalence set point to its lowest member again.
+func fixEquivSet(tx *sql.Tx, set int) error {
+	var lowest *int
+	err := tx.QueryRow("SELECT min(id) FROM component WHERE equiv_set=?1", set).Scan(&lowest)
+	if err != nil {
+		return err
+	}
+	if lowest == nil {
+		// Nobody left in this set.
+		_, err = tx.Exec("DELETE FROM equiv_set_info WHERE set_id=?1", set)
+		return err
+	}
+	if _, err = tx.Exec("UPDATE component SET equiv_set=?2 WHERE equiv_set=?1", set, *lowest); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE OR REPLACE equiv_set_info SET set_id=?2 WHERE set_id=?1", set, *lowest)
+	return err
+}
+
+// Within a transaction, change the IDs of components according to the
+// mapping old ID -> new ID. New IDs must not be in use, unless they are
+// renumbered in the same go (e.g. swap). Set memberships are retained, but
+// the sets are pointed to their new lowest member.
+func renumberComponents(tx *sql.Tx, mapping map[int]int) error {
+	// Remember old set membership of all affected sets.
+	set_members := make(map[int][]int)
+	for old_id := range mapping {
+		var set int
+		err := tx.QueryRow("SELECT equiv_set FROM component WHERE id=?1", old_id).Scan(&set)
+		if err != nil {
+			return err
+		}
+		if _, seen := set_members[set]; seen {
+			continue
+		}
+		rows, err := tx.Query("SELECT id FROM component WHERE equiv_set=?1", set)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			set_members[set] = append(set_members[set], id)
+		}
+		rows.Close()
+	}
+
+	// Go through temporary negative IDs to not collide while swapping.
+	now := time.Now()
+	for old_id := range mapping {
+		if _, err := tx.Exec("UPDATE component SET id=?2 WHERE id=?1", old_id, -old_id-1); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE datasheet_text SET component=?2 WHERE component=?1", old_id, -old_id-1); err != nil {
+			return err
+		}
+	}
+	for old_id, new_id := range mapping {
+		if _, err := tx.Exec("UPDATE component SET id=?2, updated=?3 WHERE id=?1", -old_id-1, new_id, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE OR REPLACE datasheet_text SET component=?2 WHERE component=?1", -old_id-1, new_id); err != nil {
+			return err
+		}
+	}
+
+	new_set_ids := make(map[int]int)
+	for set, members := range set_members {
+		new_set := -1
+		for i, id := range members {
+			if new_id, found := mapping[id]; found {
+				members[i] = new_id
+			}
+			if new_set < 0 || members[i] < new_set {
+				new_set = members[i]
+			}
+		}
+		for _, id := range members {
+			if _, err := tx.Exec("UPDATE component SET equiv_set=?2 WHERE id=?1", id, new_set); err != nil {
+				return err
+			}
+		}
+		new_set_ids[set] = new_set
+	}
+
+	// Same dance for the set info, as set IDs might be swapped as well.
+	for old_set := range new_set_ids {
+		if _, err := tx.Exec("UPDATE equiv_set_info SET set_id=?2 WHERE set_id=?1", old_set, -old_set-1); err != nil {
+			return err
+		}
+	}
+	for old_set, new_set := range new_set_ids {
+		if _, err := tx.Exec("UPDATE OR REPLACE equiv_set_info SET set_id=?2 WHERE set_id=?1", -old_set-1, new_set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *SqlStuffStore) FindEquivSetInfo(set int) *EquivSetInfo {
+	var name, note, location *string
+	err := d.db.QueryRow("SELECT name, note, location FROM equiv_set_info WHERE set_id=?1", set).Scan(&name, &note, &location)
+	if err != nil {
+		return nil
+	}
+	return &EquivSetInfo{
+		Id:       set,
+		Name:     emptyIfNull(name),
+		Note:     emptyIfNull(note),
+		Location: emptyIfNull(location),
+	}
+}
+
+func (d *SqlStuffStore) EditEquivSetInfo(info EquivSetInfo) {
+	var err error
+	if info.Name == "" && info.Note == "" && info.Location == "" {
+		_, err = d.db.Exec("DELETE FROM equiv_set_info WHERE set_id=?1", info.Id)
+	} else {
+		_, err = d.db.Exec("INSERT OR REPLACE INTO equiv_set_info (set_id, name, note, location) VALUES (?1, ?2, ?3, ?4)",
+			info.Id, nullIfEmpty(info.Name), nullIfEmpty(info.Note), nullIfEmpty(info.Location))
+	}
+	if err != nil {
+		log.Printf("EditEquivSetInfo(%d) fail: %v", info.Id, err)
+		return
+	}
+	d.fts.SetEquivSetName(info.Id, info.Name)
+	d.refreshSearch(info.Id)
+}
+
+func (d *SqlStuffStore) AllEquivSetInfo() []*EquivSetInfo {
+	result := make([]*EquivSetInfo, 0)
+	rows, _ := d.db.Query("SELECT set_id, name, note, location FROM equiv_set_info ORDER BY set_id")
+	for rows != nil && rows.Next() {
+		var name, note, location *string
+		info := &EquivSetInfo{}
+		if rows.Scan(&info.Id, &name, &note, &location) != nil {
+			continue
+		}
+		info.Name = emptyIfNull(name)
+		info.Note = emptyIfNull(note)
+		info.Location = emptyIfNull(location)
+		result = append(result, info)
+	}
+	if rows != nil {
+		rows.Close()
+	}
+	return result
+}
+
+func (d *SqlStuffStore) RejectSetSuggestion(key string) {
+	_, err := d.db.Exec("INSERT OR REPLACE INTO rejected_set_suggestion (suggestion_key, created) VALUES (?1, ?2)",
+		key, time.Now())
+	if err != nil {
+		log.Printf("RejectSetSuggestion(%s) fail: %v", key, err)
+	}
+}
+
+func (d *SqlStuffStore) RejectedSetSuggestions() map[string]bool {
+	result := make(map[string]bool)
+	rows, _ := d.db.Query("SELECT suggestion_key FROM rejected_set_suggestion")
+	for rows != nil && rows.Next() {
+		var key string
+		if rows.Scan(&key) == nil {
+			result[key] = true
+		}
+	}
+	if rows != nil {
+		rows.Close()
+	}
+	return result
+}
+
+func (d *SqlStuffStore) EquivSetMembers(set int) []*Component {
+	result := make([]*Component, 0)
+	rows, _ := d.equivSetMembers.Query(set)
+	for rows != nil && rows.Next() {
+		c, _ := row2Component(rows)
+		result = append(result, c)
+	}
+	if rows != nil {
+		rows.Close()
+	}
+	return result
 }
@@ -186,4 +186,173 @@ func TestQueryEquiv(t *testing.T) {
 	ExpectTrue(t, matching[0].Id == 1, "#10")
 	ExpectTrue(t, matching[1].Id == 2, "#11")
 	ExpectTrue(t, matching[2].Id == 4, "#12")
+
+	// Different notation and related categories are considered the same.
+	store.EditRecord(5, func(c *Component) bool {
+		c.Value = "0.1uF"
+		c.Category = "Capacitor (C)"
+		return true
+	})
+	store.EditRecord(6, func(c *Component) bool {
+		c.Value = "100n"
+		c.Category = "Aluminum Cap"
+		return true
+	})
+	store.EditRecord(7, func(c *Component) bool {
+		c.Value = "100n"
+		c.Category = "Inductor (L)"
+		return true
+	})
+	matching = store.MatchingEquivSetForComponent(5)
+	ExpectTrue(t, len(matching) == 2, fmt.Sprintf("Expected 2 caps got %d", len(matching)))
+	ExpectTrue(t, matching[1].Id == 6, "Aluminum cap")
+}
+
+func TestTimestamps(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "timestamps")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+
+	store.EditRecord(1, func(c *Component) bool { c.Value = "one"; return true })
+	store.EditRecord(2, func(c *Component) bool { c.Value = "two"; return true })
+
+	one := store.FindById(1)
+	ExpectTrue(t, one.Created != nil && one.Updated != nil, "Timestamps set")
+	ExpectTrue(t, one.Created.Equal(*one.Updated), "New record")
+
+	// A no-op edit does not count as change even if the caller does not
+	// preserve the timestamps.
+	ok, _ := store.EditRecord(1, func(c *Component) bool {
+		*c = Component{Id: 1, Value: "one"}
+		return true
+	})
+	ExpectTrue(t, !ok, "Nothing changed")
+
+	store.EditRecord(1, func(c *Component) bool { c.Value = "uno"; return true })
+	edited := store.FindById(1)
+	ExpectTrue(t, edited.Created.Equal(*one.Created), "Created unchanged")
+	ExpectTrue(t, edited.Updated.After(*one.Updated), "Updated moved")
+
+	recent := store.RecentlyChanged(10)
+	ExpectTrue(t, len(recent) == 2, fmt.Sprintf("Expected 2, got %d", len(recent)))
+	ExpectTrue(t, recent[0].Id == 1, "Most recently edited first")
+	ExpectTrue(t, recent[1].Id == 2, "Then the older one")
+	ExpectTrue(t, len(store.RecentlyChanged(1)) == 1, "Limit")
+}
+
+func TestMoveComponent(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "move")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+
+	store.EditRecord(1, func(c *Component) bool { c.Value = "one"; return true })
+	store.EditRecord(2, func(c *Component) bool { c.Value = "two"; return true })
+	store.EditRecord(3, func(c *Component) bool { c.Value = "three"; return true })
+	store.EditRecord(10, func(c *Component) bool { c.Value = "empty"; return true })
+	store.JoinSet(2, 1)
+
+	ExpectTrue(t, store.MoveComponent(1, 3) != nil, "Can't move to occupied bin")
+	ExpectTrue(t, store.MoveComponent(4, 5) != nil, "Can't move non-existing")
+
+	// Moving the lowest member of a set: set now points to next lowest.
+	ExpectTrue(t, store.MoveComponent(1, 5) == nil, "Move to new bin")
+	ExpectTrue(t, store.FindById(1) == nil, "#1 gone")
+	ExpectTrue(t, store.FindById(5).Value == "one", "#5 moved")
+	ExpectTrue(t, store.FindById(5).Equiv_set == 2, "#5 set")
+	ExpectTrue(t, store.FindById(2).Equiv_set == 2, "#2 set")
+	ExpectTrue(t, store.FindRedirect(1) == 5, "Redirect 1 -> 5")
+	ExpectTrue(t, store.FindRedirect(2) == 0, "No redirect")
+	ExpectTrue(t, len(store.Search("one").Results) == 1, "Search updated")
+	ExpectTrue(t, store.Search("one").Results[0].Id == 5, "Search new ID")
+
+	// Moving into a bin marked empty is fine. Redirects follow.
+	ExpectTrue(t, store.MoveComponent(5, 10) == nil, "Move to empty bin")
+	ExpectTrue(t, store.FindById(10).Value == "one", "#10 replaced")
+	ExpectTrue(t, store.FindRedirect(1) == 10, "Redirect 1 -> 10")
+	ExpectTrue(t, store.FindRedirect(5) == 10, "Redirect 5 -> 10")
+
+	// Moving back to an old ID removes its redirect.
+	ExpectTrue(t, store.MoveComponent(10, 1) == nil, "Move back")
+	ExpectTrue(t, store.FindRedirect(1) == 0, "No redirect anymore")
+	ExpectTrue(t, store.FindById(1).Equiv_set == 1, "Lowest in set again")
+	ExpectTrue(t, store.FindById(2).Equiv_set == 1, "Set follows")
+}
+
+func TestSwapComponents(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "swap")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+
+	for i := 1; i <= 4; i++ {
+		value := fmt.Sprintf("value%d", i)
+		store.EditRecord(i, func(c *Component) bool { c.Value = value; return true })
+	}
+	store.JoinSet(3, 1) // Sets: {1, 3}, {2, 4}
+	store.JoinSet(4, 2)
+
+	ExpectTrue(t, store.SwapComponents(1, 2) == nil, "Swap")
+	ExpectTrue(t, store.FindById(1).Value == "value2", "#1")
+	ExpectTrue(t, store.FindById(2).Value == "value1", "#2")
+	// value1 and value3 are still together, now with lowest id 2
+	ExpectTrue(t, store.FindById(2).Equiv_set == 2, "#3")
+	ExpectTrue(t, store.FindById(3).Equiv_set == 2, "#4")
+	ExpectTrue(t, store.FindById(1).Equiv_set == 1, "#5")
+	ExpectTrue(t, store.FindById(4).Equiv_set == 1, "#6")
+	ExpectTrue(t, store.FindRedirect(1) == 0, "No redirect on swap")
+}
+
+func TestEquivSetInfo(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "setinfo")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+
+	store.EditRecord(2, func(c *Component) bool { c.Value = "two"; return true })
+	store.EditRecord(3, func(c *Component) bool { c.Value = "three"; return true })
+	store.EditRecord(4, func(c *Component) bool { c.Value = "four"; return true })
+	store.JoinSet(3, 4)
+	ExpectTrue(t, store.FindEquivSetInfo(3) == nil, "No info yet")
+
+	store.EditEquivSetInfo(EquivSetInfo{Id: 3, Name: "Small signal", Location: "Shelf A"})
+	ExpectTrue(t, store.FindEquivSetInfo(3).Name == "Small signal", "Name stored")
+	ExpectTrue(t, len(store.AllEquivSetInfo()) == 1, "Listed")
+	ExpectTrue(t, len(store.EquivSetMembers(3)) == 2, "Members")
+	ExpectTrue(t, len(store.Search("set:signal").Results) == 2, "Found by set name")
+	ExpectTrue(t, len(store.Search("set:three").Results) == 0, "Only set name")
+
+	// A lower ID joining moves the info to the new set ID.
+	store.JoinSet(2, 3)
+	ExpectTrue(t, store.FindEquivSetInfo(3) == nil, "Moved away")
+	ExpectTrue(t, store.FindEquivSetInfo(2).Location == "Shelf A", "Moved to 2")
+	ExpectTrue(t, len(store.Search("set:signal").Results) == 3, "Search follows")
+
+	// Leader leaving: info stays with the remaining members.
+	store.LeaveSet(2)
+	ExpectTrue(t, store.FindEquivSetInfo(2) == nil, "Not with leaving one")
+	ExpectTrue(t, store.FindEquivSetInfo(3).Name == "Small signal", "Back at 3")
+	ExpectTrue(t, len(store.Search("set:signal").Results) == 2, "Search follows leave")
+
+	// Renumbering the set moves info along.
+	ExpectTrue(t, store.MoveComponent(3, 1) == nil, "Move")
+	ExpectTrue(t, store.FindEquivSetInfo(1).Name == "Small signal", "Follows move")
+
+	// Empty info removes it.
+	store.EditEquivSetInfo(EquivSetInfo{Id: 1})
+	ExpectTrue(t, len(store.AllEquivSetInfo()) == 0, "Removed")
+	ExpectTrue(t, len(store.Search("set:signal").Results) == 0, "Removed from search")
 }
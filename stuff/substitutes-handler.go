@@ -0,0 +1,120 @@
+// Page to view and edit the part substitutes, with CSV import and export.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	kSubstitutesPage = "/substitutes"
+	kSubstitutesCsv  = "/substitutes.csv"
+	kApiSubstitutes  = "/api/substitutes"
+)
+
+type SubstitutesHandler struct {
+	store    StuffStore
+	template *TemplateRenderer
+	editNets []*net.IPNet
+}
+
+func AddSubstitutesHandler(store StuffStore, template *TemplateRenderer, editNets []*net.IPNet) {
+	handler := &SubstitutesHandler{
+		store:    store,
+		template: template,
+		editNets: editNets,
+	}
+	http.Handle(kSubstitutesPage, handler)
+	http.Handle(kSubstitutesCsv, handler)
+	http.Handle(kApiSubstitutes, handler)
+}
+
+type SubstitutesPage struct {
+	Substitutes []*PartSubstitute
+	EditAllowed bool
+	Msg         string
+}
+
+func (h *SubstitutesHandler) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+	defer ElapsedPrint("Substitutes", time.Now())
+	switch {
+	case strings.HasPrefix(req.URL.Path, kApiSubstitutes):
+		out.Header().Set("Content-Type", "application/json")
+		json, _ := json.MarshalIndent(h.store.PartSubstitutes(), "", "  ")
+		out.Write(json)
+	case strings.HasPrefix(req.URL.Path, kSubstitutesCsv):
+		h.substitutesCsv(out, req)
+	default:
+		h.substitutesPage(out, req)
+	}
+}
+
+func (h *SubstitutesHandler) substitutesPage(out http.ResponseWriter, r *http.Request) {
+	page := &SubstitutesPage{
+		EditAllowed: editAllowed(r, h.editNets),
+	}
+	if r.Method == "POST" {
+		if page.EditAllowed {
+			page.Msg = h.edit(r)
+		} else {
+			page.Msg = "Not allowed to edit."
+		}
+	}
+	page.Substitutes = h.store.PartSubstitutes()
+	h.template.Render(out, "substitutes.html", page)
+}
+
+// Handle the edit form: add, remove or import. Returns the message to show.
+func (h *SubstitutesHandler) edit(r *http.Request) string {
+	switch r.FormValue("action") {
+	case "remove":
+		h.store.RemovePartSubstitute(r.FormValue("part"), r.FormValue("substitute"))
+		return fmt.Sprintf("Removed %s = %s", r.FormValue("part"), r.FormValue("substitute"))
+	case "import":
+		var input io.Reader = strings.NewReader(r.FormValue("csv"))
+		if file, _, err := r.FormFile("csvfile"); err == nil {
+			defer file.Close()
+			input = file
+		}
+		substitutes, err := parseSubstitutesCSV(input)
+		if err != nil {
+			return "Not imported: " + err.Error()
+		}
+		for _, s := range substitutes {
+			if err := h.store.EditPartSubstitute(*s); err != nil {
+				return fmt.Sprintf("Import stopped at %s = %s: %v", s.Part, s.Substitute, err)
+			}
+		}
+		log.Printf("Imported %d part substitutes", len(substitutes))
+		return fmt.Sprintf("Imported %d substitutes", len(substitutes))
+	default:
+		substitute := PartSubstitute{
+			Part:       r.FormValue("part"),
+			Substitute: r.FormValue("substitute"),
+			Note:       r.FormValue("note"),
+		}
+		if err := h.store.EditPartSubstitute(substitute); err != nil {
+			return "Not saved: " + err.Error()
+		}
+		return fmt.Sprintf("Saved %s = %s", substitute.Part, substitute.Substitute)
+	}
+}
+
+func (h *SubstitutesHandler) substitutesCsv(out http.ResponseWriter, r *http.Request) {
+	out.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	out.Header().Set("Content-Disposition", "attachment; filename=substitutes.csv")
+	records := [][]string{{"part", "substitute", "note"}}
+	for _, s := range h.store.PartSubstitutes() {
+		records = append(records, []string{s.Part, s.Substitute, s.Note})
+	}
+	if err := csv.NewWriter(out).WriteAll(records); err != nil {
+		log.Printf("Writing substitutes CSV: %v", err)
+	}
+}
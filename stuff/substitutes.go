@@ -0,0 +1,149 @@
+// Part-number cross-reference: parts that can be used instead of others,
+// e.g. LM2904 for LM358. A search for a part also finds its substitutes in
+// stock, ranked below the exact matches.
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Normalized part number -> its substitutes, as written.
+type substituteIndex map[string][]string
+
+func partKey(part string) string {
+	return normalizeText(strings.Join(strings.Fields(part), " "))
+}
+
+func newSubstituteIndex(substitutes []*PartSubstitute) substituteIndex {
+	result := make(substituteIndex)
+	add := func(part, substitute string) {
+		key := partKey(part)
+		if key == partKey(substitute) || containsString(result[key], substitute) {
+			return
+		}
+		result[key] = append(result[key], substitute)
+	}
+	for _, s := range substitutes {
+		add(s.Part, s.Substitute)
+		add(s.Substitute, s.Part)
+	}
+	return result
+}
+
+// Check that the substitute is usable and remove superfluous spaces.
+func cleanPartSubstitute(s *PartSubstitute) error {
+	s.Part = strings.Join(strings.Fields(s.Part), " ")
+	s.Substitute = strings.Join(strings.Fields(s.Substitute), " ")
+	s.Note = strings.TrimSpace(s.Note)
+	if s.Part == "" || s.Substitute == "" {
+		return fmt.Errorf("need part and substitute")
+	}
+	if partKey(s.Part) == partKey(s.Substitute) {
+		return fmt.Errorf("%s can't substitute itself", s.Part)
+	}
+	for _, part := range []string{s.Part, s.Substitute} {
+		if strings.ContainsAny(part, `()|!":`) {
+			return fmt.Errorf("%q contains a search operator", part)
+		}
+	}
+	return nil
+}
+
+// Read substitutes from CSV with the columns part, substitute and an
+// optional note. A header line starting with "part" is skipped.
+func parseSubstitutesCSV(in io.Reader) ([]*PartSubstitute, error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	result := make([]*PartSubstitute, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "part") {
+			continue
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if len(record) < 2 || len(record) > 3 {
+			return nil, fmt.Errorf("line %d: need part,substitute[,note]", line)
+		}
+		s := &PartSubstitute{Part: record[0], Substitute: record[1]}
+		if len(record) == 3 {
+			s.Note = record[2]
+		}
+		if err := cleanPartSubstitute(s); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// Add the substitutes of plain terms as alternatives, e.g. LM358 becomes
+// (LM358 | LM2904). The substitute terms are marked, so that the components
+// only found through them can be labelled. Negated terms are not expanded.
+// Returns the original nodes where nothing changed.
+func addSubstitutes(n *queryNode, index substituteIndex) *queryNode {
+	if n == nil || len(index) == 0 || n.kind == kNotNode {
+		return n
+	}
+	if n.kind == kTermNode {
+		if !isPlainTerm(n) || n.substituteFor != "" {
+			return n
+		}
+		substitutes := index[partKey(n.text)]
+		if substitutes == nil {
+			return n
+		}
+		alternatives := []*queryNode{n}
+		for _, substitute := range substitutes {
+			node := newTermNode(substitute)
+			node.substituteFor = n.text
+			alternatives = append(alternatives, node)
+		}
+		return newNode(kGroupNode, newNode(kOrNode, alternatives...))
+	}
+	changed := false
+	children := make([]*queryNode, len(n.children))
+	for i, child := range n.children {
+		children[i] = addSubstitutes(child, index)
+		changed = changed || children[i] != child
+	}
+	if !changed {
+		return n
+	}
+	return newNode(n.kind, children...)
+}
+
+// The part the component is a substitute for, if it matches one of the
+// substitute terms in the query. Empty if none.
+func (c *SearchComponent) substituteMatch(n *queryNode) string {
+	if n == nil || n.kind == kNotNode {
+		return ""
+	}
+	if n.substituteFor != "" && c.score(n) > 0 {
+		return n.substituteFor
+	}
+	for _, child := range n.children {
+		if part := c.substituteMatch(child); part != "" {
+			return part
+		}
+	}
+	return ""
+}
+
+// If the component is known to be out of stock.
+func outOfStock(c *Component) bool {
+	quantity, ok := parseQuantity(c.Quantity)
+	return ok && quantity == 0
+}
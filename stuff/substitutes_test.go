@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseSubstitutesCSV(t *testing.T) {
+	substitutes, err := parseSubstitutesCSV(strings.NewReader(
+		"part,substitute,note\n1N4148, 1N914\n# Comment\nLM358,LM2904,\"wider temperature, range\"\n"))
+	ExpectTrue(t, err == nil, "Valid")
+	ExpectTrue(t, len(substitutes) == 2, "Two")
+	expectEqual(t, substitutes[0].Substitute, "1N914")
+	expectEqual(t, substitutes[1].Note, "wider temperature, range")
+
+	_, err = parseSubstitutesCSV(strings.NewReader("1N4148\n"))
+	ExpectTrue(t, err != nil && err.Error() == "line 1: need part,substitute[,note]", "Missing substitute")
+	_, err = parseSubstitutesCSV(strings.NewReader("LM358,lm-358\n"))
+	ExpectTrue(t, err != nil, "Itself")
+	_, err = parseSubstitutesCSV(strings.NewReader("LM358,(LM2904 | LM324)\n"))
+	ExpectTrue(t, err != nil, "Operators")
+}
+
+func TestSubstituteSearch(t *testing.T) {
+	fts := NewFulltextSearch()
+	fts.SetSubstitutes([]*PartSubstitute{
+		{Part: "LM358", Substitute: "LM2904"},
+		{Part: "1N4148", Substitute: "1N914"},
+	})
+	fts.Update(&Component{Id: 1, Equiv_set: 1, Category: "IC", Value: "LM2904", Quantity: "5"})
+	fts.Update(&Component{Id: 2, Equiv_set: 2, Category: "IC", Value: "LM358", Description: "DIP-8"})
+	fts.Update(&Component{Id: 3, Equiv_set: 3, Category: "IC", Value: "LM2904", Quantity: "0"})
+	fts.Update(&Component{Id: 4, Equiv_set: 4, Category: "Diode", Value: "1N914"})
+
+	result := fts.Search("lm358")
+	ExpectTrue(t, len(result.Results) == 2, "Exact and one substitute in stock")
+	ExpectTrue(t, result.Results[0].Id == 2 && result.Results[1].Id == 1, "Substitute after exact")
+	expectEqual(t, result.Substitutes[1], "lm358")
+	ExpectTrue(t, len(result.Substitutes) == 1, "Only one substitute")
+	expectEqual(t, result.RewrittenQuery, "lm358") // Not shown as rewrite.
+
+	// Exact matches out of stock are still shown.
+	result = fts.Search("LM2904")
+	ExpectTrue(t, len(result.Results) == 3, "Both ways")
+	ExpectTrue(t, result.Results[2].Id == 2, "Substitute last")
+	expectEqual(t, result.Substitutes[2], "LM2904")
+
+	// Substitute has to match the other terms as well.
+	ExpectTrue(t, len(fts.Search("LM2904 dip").Results) == 1, "With other terms")
+	ExpectTrue(t, len(fts.Search("1n4148").Results) == 1, "Only substitute")
+	ExpectTrue(t, len(fts.Search("ic -lm358").Results) == 2, "Negation not expanded")
+	ExpectTrue(t, len(fts.Search("value:lm358").Results) == 1, "Qualified not expanded")
+
+	// Components like this one include the ones that could replace it.
+	fts.Update(&Component{Id: 5, Equiv_set: 5, Category: "Small signal", Value: "1N4148"})
+	result = fts.Search("like:4")
+	ExpectTrue(t, len(result.Results) == 1 && result.Results[0].Id == 5, "Similar")
+}
+
+func TestStorePartSubstitutes(t *testing.T) {
+	dbfile, _ := os.CreateTemp("", "substitutes")
+	defer syscall.Unlink(dbfile.Name())
+	db, err := sql.Open("sqlite3", dbfile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, _ := NewSqlStuffStore(db, true)
+	store.EditRecord(1, func(c *Component) bool {
+		c.Id, c.Value = 1, "1N914"
+		return true
+	})
+	ExpectTrue(t, len(store.Search("1N4148").Results) == 0, "Not yet")
+
+	ExpectTrue(t, store.EditPartSubstitute(PartSubstitute{Part: "1N4148", Substitute: " 1N914 "}) == nil, "Add")
+	ExpectTrue(t, store.EditPartSubstitute(PartSubstitute{Part: "1n914", Substitute: "1n4148", Note: "Same"}) == nil, "Update reverse")
+	ExpectTrue(t, store.EditPartSubstitute(PartSubstitute{Part: "1N914"}) != nil, "Need substitute")
+	substitutes := store.PartSubstitutes()
+	ExpectTrue(t, len(substitutes) == 1, "One")
+	expectEqual(t, substitutes[0].Substitute, "1N914")
+	expectEqual(t, substitutes[0].Note, "Same")
+	ExpectTrue(t, len(store.Search("1N4148").Results) == 1, "Search updated")
+
+	store.RemovePartSubstitute("1N914", "1N4148")
+	ExpectTrue(t, len(store.PartSubstitutes()) == 0, "Removed")
+	ExpectTrue(t, len(store.Search("1N4148").Results) == 0, "Search updated on remove")
+}
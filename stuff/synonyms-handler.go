@@ -0,0 +1,57 @@
+// Page to view and edit the synonyms search queries are expanded with.
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const kSynonymsPage = "/synonyms"
+
+type SynonymsHandler struct {
+	synonyms *SynonymDictionary
+	template *TemplateRenderer
+	editNets []*net.IPNet
+}
+
+type SynonymsPage struct {
+	Text        string
+	Groups      int
+	EditAllowed bool
+	Msg         string
+}
+
+func AddSynonymsHandler(synonyms *SynonymDictionary, template *TemplateRenderer, editNets []*net.IPNet) {
+	handler := &SynonymsHandler{
+		synonyms: synonyms,
+		template: template,
+		editNets: editNets,
+	}
+	http.Handle(kSynonymsPage, handler)
+}
+
+func (h *SynonymsHandler) ServeHTTP(out http.ResponseWriter, r *http.Request) {
+	defer ElapsedPrint("Synonyms", time.Now())
+	page := &SynonymsPage{
+		EditAllowed: editAllowed(r, h.editNets),
+	}
+	if r.Method == "POST" {
+		if !page.EditAllowed {
+			page.Msg = "Not allowed to edit."
+		} else if err := h.synonyms.Save(r.FormValue("synonyms")); err != nil {
+			page.Msg = "Not saved: " + err.Error()
+			page.Text = r.FormValue("synonyms") // Keep the edits to fix.
+		} else {
+			log.Printf("Synonyms changed")
+			page.Msg = "Saved."
+		}
+	}
+	text, groups := h.synonyms.Text()
+	if page.Text == "" {
+		page.Text = text
+	}
+	page.Groups = groups
+	h.template.Render(out, "synonyms.html", page)
+}
@@ -0,0 +1,251 @@
+// Synonyms and aliases for search terms, maintained in a text file: each
+// line lists words that mean the same, separated by '=', e.g.
+//
+//	opamp = op-amp = operational amplifier
+//
+// A search for any of them finds all of them. An alias only works one way:
+//
+//	ohm -> resistor = potentiometer = r-network
+//
+// A search for ohm finds all of them, but a search for potentiometer does
+// not find resistors. The ohm alias also gives the categories of values
+// with the unit Ohm.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Longest phrase, in words, that is looked up in a query.
+const kMaxSynonymWords = 4
+
+// A line of the synonym file.
+type synonymLine struct {
+	alias string   // Stands for the words; empty if they are all the same.
+	words []string // As written, without duplicates.
+}
+
+type SynonymDictionary struct {
+	lock     sync.RWMutex
+	filename string
+	modTime  time.Time
+	text     string // Content of the file.
+	groups   []synonymLine
+	index    map[string][]string // Normalized synonym -> its group.
+	changes  int                 // Incremented on every change.
+}
+
+// Create a dictionary from the given file. It is fine if the file does not
+// exist (yet). Empty filename: no synonyms.
+func NewSynonymDictionary(filename string) *SynonymDictionary {
+	result := &SynonymDictionary{
+		filename: filename,
+		index:    make(map[string][]string),
+	}
+	if filename == "" {
+		return result
+	}
+	if err := result.Load(); err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("No synonyms yet in %s", filename)
+		} else {
+			log.Printf("Synonyms: %v", err)
+		}
+	}
+	return result
+}
+
+// Parse the synonym file: one group per line, entries separated by '=',
+// optionally preceded by an alias and '->'. Empty lines and lines starting
+// with '#' are ignored.
+func parseSynonyms(text string) ([]synonymLine, error) {
+	result := make([]synonymLine, 0)
+	for number, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		group := synonymLine{words: make([]string, 0)}
+		seen := make(map[string]bool)
+		if arrow := strings.Index(line, "->"); arrow >= 0 {
+			group.alias = strings.Join(strings.Fields(line[:arrow]), " ")
+			if group.alias == "" || strings.Contains(group.alias, "=") {
+				return nil, fmt.Errorf("line %d: need one word before '->'", number+1)
+			}
+			seen[normalizeText(group.alias)] = true
+			line = line[arrow+2:]
+		}
+		for _, entry := range strings.Split(line, "=") {
+			entry = strings.Join(strings.Fields(entry), " ")
+			normalized := normalizeText(entry)
+			if entry == "" || seen[normalized] {
+				continue
+			}
+			if strings.ContainsAny(entry, `()|!"`) || strings.Contains(entry, "->") {
+				return nil, fmt.Errorf("line %d: %q contains a search operator", number+1, entry)
+			}
+			seen[normalized] = true
+			group.words = append(group.words, entry)
+		}
+		switch {
+		case group.alias != "" && len(group.words) == 0:
+			return nil, fmt.Errorf("line %d: need words after '->'", number+1)
+		case group.alias == "" && len(group.words) < 2:
+			return nil, fmt.Errorf("line %d: need at least two different words separated by '='", number+1)
+		}
+		result = append(result, group)
+	}
+	return result, nil
+}
+
+// Use the given synonyms. Words in more than one group get all of them.
+func (d *SynonymDictionary) set(text string, groups []synonymLine) {
+	index := make(map[string][]string)
+	add := func(key string, synonym string) {
+		if normalizeText(synonym) != key && !containsString(index[key], synonym) {
+			index[key] = append(index[key], synonym)
+		}
+	}
+	for _, group := range groups {
+		if group.alias != "" {
+			key := normalizeText(group.alias)
+			for _, word := range group.words {
+				add(key, word)
+			}
+			continue
+		}
+		for _, entry := range group.words {
+			key := normalizeText(entry)
+			for _, synonym := range group.words {
+				add(key, synonym)
+			}
+		}
+	}
+	d.lock.Lock()
+	d.text, d.groups, d.index = text, groups, index
+	d.changes++
+	d.lock.Unlock()
+}
+
+func containsString(list []string, s string) bool {
+	for _, element := range list {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
+
+// (Re-)load the synonyms from the file. On error, the previous synonyms are
+// kept.
+func (d *SynonymDictionary) Load() error {
+	info, err := os.Stat(d.filename)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(d.filename)
+	if err != nil {
+		return err
+	}
+	groups, err := parseSynonyms(string(content))
+	if err != nil {
+		return fmt.Errorf("%s: %v", d.filename, err)
+	}
+	d.set(string(content), groups)
+	d.lock.Lock()
+	d.modTime = info.ModTime()
+	d.lock.Unlock()
+	log.Printf("Loaded %d synonym groups from %s", len(groups), d.filename)
+	return nil
+}
+
+// Reload the file whenever it changes, checking in the given interval.
+// Does not return.
+func (d *SynonymDictionary) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		info, err := os.Stat(d.filename)
+		if err != nil {
+			continue
+		}
+		d.lock.RLock()
+		changed := !info.ModTime().Equal(d.modTime)
+		d.lock.RUnlock()
+		if changed {
+			if err := d.Load(); err != nil {
+				log.Printf("Synonyms not reloaded: %v", err)
+			}
+		}
+	}
+}
+
+// Replace the synonyms with the given text and write it to the file.
+func (d *SynonymDictionary) Save(text string) error {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	groups, err := parseSynonyms(text)
+	if err != nil {
+		return err
+	}
+	if d.filename == "" {
+		return fmt.Errorf("no synonym file configured")
+	}
+	tmpfile, err := os.CreateTemp(filepath.Dir(d.filename), ".synonyms")
+	if err != nil {
+		return err
+	}
+	if _, err = tmpfile.WriteString(text); err == nil {
+		err = tmpfile.Close()
+	} else {
+		tmpfile.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpfile.Name(), d.filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmpfile.Name())
+		return err
+	}
+	d.set(text, groups)
+	if info, err := os.Stat(d.filename); err == nil {
+		d.lock.Lock()
+		d.modTime = info.ModTime()
+		d.lock.Unlock()
+	}
+	return nil
+}
+
+// The content of the synonym file and the number of groups in it.
+func (d *SynonymDictionary) Text() (string, int) {
+	if d == nil {
+		return "", 0
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.text, len(d.groups)
+}
+
+// Counter of the changes, so that results using the synonyms can be told
+// to be outdated.
+func (d *SynonymDictionary) Generation() int {
+	if d == nil {
+		return 0
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.changes
+}
+
+// The synonyms of the given word or phrase, nil if there are none.
+func (d *SynonymDictionary) Synonyms(text string) []string {
+	if d == nil {
+		return nil
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.index[normalizeText(strings.Join(strings.Fields(text), " "))]
+}
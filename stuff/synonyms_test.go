@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSynonyms(t *testing.T) {
+	groups, err := parseSynonyms("# Comment\n\nfet=mosfet\n opamp = op-amp =  operational   amplifier \n")
+	ExpectTrue(t, err == nil, "Valid")
+	ExpectTrue(t, len(groups) == 2, "Two groups")
+	expectEqual(t, groups[1].words[1], "operational amplifier") // op-amp is the same
+
+	groups, _ = parseSynonyms("opamp = OpAmp = op-amp = op amp")
+	ExpectTrue(t, len(groups[0].words) == 2, "Same after normalizing removed")
+
+	groups, err = parseSynonyms("ohm -> resistor = potentiometer = Ohm")
+	ExpectTrue(t, err == nil, "Valid alias")
+	expectEqual(t, groups[0].alias, "ohm")
+	ExpectTrue(t, len(groups[0].words) == 2, "Alias not repeated")
+	_, err = parseSynonyms("ohm ->")
+	ExpectTrue(t, err != nil, "Alias without words")
+	_, err = parseSynonyms("a = b -> c")
+	ExpectTrue(t, err != nil, "Alias of several words")
+
+	_, err = parseSynonyms("fet=mosfet\nelko\n")
+	ExpectTrue(t, err != nil && err.Error() == "line 2: need at least two different words separated by '='", "One word")
+	_, err = parseSynonyms("fet = (mosfet | jfet)")
+	ExpectTrue(t, err != nil, "Operators")
+}
+
+func TestSynonymExpansion(t *testing.T) {
+	d := NewSynonymDictionary("")
+	groups, _ := parseSynonyms("fet=mosfet\nelko=aluminum cap\nopamp=op-amp=operational amplifier\nfet=transistor")
+	d.set("", groups)
+	expand := func(query string) string {
+		root, _ := parseQuery(query)
+		return expandQuery(root, d).String()
+	}
+	expectEqual(t, expand("FET"), "(FET | mosfet | transistor)")
+	expectEqual(t, expand("elko 100u"), `(elko | "aluminum cap") 100u`)
+	expectEqual(t, expand("aluminum cap"), "(aluminum cap | elko)")
+	expectEqual(t, expand(`"aluminum cap"`), `("aluminum cap" | elko)`)
+	expectEqual(t, expand("big aluminum cap"), "big (aluminum cap | elko)")
+	expectEqual(t, expand("op-amp"), `(op-amp | "operational amplifier")`)
+	expectEqual(t, expand("-fet"), "!(fet | mosfet | transistor)")
+	expectEqual(t, expand("category:fet"), "category:fet") // Qualified as written
+	expectEqual(t, expand("aluminum | cap"), "aluminum | cap")
+
+	// The synonyms are found.
+	fts := NewFulltextSearch()
+	fts.SetSynonyms(d)
+	fts.Update(&Component{Id: 1, Equiv_set: 1, Category: "Aluminum Cap", Value: "100u"})
+	fts.Update(&Component{Id: 2, Equiv_set: 2, Category: "IC", Description: "Operational amplifier"})
+	result := fts.Search("elko")
+	ExpectTrue(t, len(result.Results) == 1 && result.Results[0].Id == 1, "elko")
+	expectEqual(t, result.RewrittenQuery, `(elko | "aluminum cap")`)
+	result = fts.Search("opamp")
+	ExpectTrue(t, len(result.Results) == 1 && result.Results[0].Id == 2, "opamp")
+}
+
+func TestSynonymAlias(t *testing.T) {
+	d := NewSynonymDictionary("")
+	groups, _ := parseSynonyms("ohm -> resistor = potentiometer\npot = potentiometer")
+	d.set("", groups)
+	expand := func(query string) string {
+		root, _ := parseQuery(query)
+		return expandQuery(root, d).String()
+	}
+	// Only works one way.
+	expectEqual(t, expand("ohm"), "(ohm | resistor | potentiometer)")
+	expectEqual(t, expand("potentiometer"), "(potentiometer | pot)")
+	expectEqual(t, expand("resistor"), "resistor")
+
+	// The categories of values in Ohm.
+	expectEqual(t, expand("10k Ohm"), "(10k Ohm | (10k (resistor | potentiometer)))")
+	d.set("", nil)
+	expectEqual(t, expand("10k Ohm"), "(10k Ohm | (10k (resistor | potentiometer | r-network)))") // Built in.
+}
+
+func TestSynonymFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "synonyms")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "synonyms.txt")
+
+	d := NewSynonymDictionary(file) // Not there yet.
+	ExpectTrue(t, d.Synonyms("fet") == nil, "Empty")
+
+	ExpectTrue(t, d.Save("fet=mosfet\n") == nil, "Save")
+	ExpectTrue(t, len(d.Synonyms("fet")) == 1, "Saved ones used")
+	content, _ := os.ReadFile(file)
+	expectEqual(t, string(content), "fet=mosfet\n")
+
+	ExpectTrue(t, d.Save("fet\n") != nil, "Invalid not saved")
+	content, _ = os.ReadFile(file)
+	expectEqual(t, string(content), "fet=mosfet\n")
+
+	// Changed outside: reloaded.
+	os.WriteFile(file, []byte("fet=mosfet=jfet\n"), 0644)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(file, later, later)
+	go d.Watch(10 * time.Millisecond)
+	for i := 0; i < 100 && len(d.Synonyms("fet")) != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ExpectTrue(t, len(d.Synonyms("fet")) == 2, "Reloaded")
+	text, groups := d.Text()
+	expectEqual(t, text, "fet=mosfet=jfet\n")
+	ExpectTrue(t, groups == 1, "One group")
+}
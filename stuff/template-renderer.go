@@ -30,6 +30,15 @@ func NewTemplateRenderer(baseDir string, doCache bool) *TemplateRenderer {
 			baseDir+"/display-template.html",
 			baseDir+"/status-table.html",
 			baseDir+"/set-drag-drop.html",
+			baseDir+"/restock.html",
+			baseDir+"/recent.html",
+			baseDir+"/duplicates.html",
+			baseDir+"/merge-form.html",
+			baseDir+"/set-page.html",
+			baseDir+"/set-suggestions.html",
+			baseDir+"/synonyms.html",
+			baseDir+"/search-stats.html",
+			baseDir+"/substitutes.html",
 			// Templates to create component images
 			baseDir+"/component/category-Diode.svg",
 			baseDir+"/component/category-LED.svg",